@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix namespaces the environment overrides: database.password is
+	// read from USERSERVICE_DATABASE_PASSWORD.
+	envPrefix = "USERSERVICE"
+	// fileEnvSuffix marks a variable naming a file that holds the value, e.g.
+	// USERSERVICE_DATABASE_PASSWORD_FILE=/run/secrets/db_password.
+	fileEnvSuffix = "_FILE"
+)
+
+const (
+	redacted      = "redacted"
+	redactedValue = "********"
+)
+
+var (
+	ErrConflictingEnv = errors.New("both a variable and its _FILE variant are set")
+	ErrInvalidConfig  = errors.New("invalid configuration")
+)
+
+// defaults makes every setting optional and lets AutomaticEnv see the keys
+// that no config file mentions.
+var defaults = map[string]any{
+	"log.level": "info",
+
+	"server.port":              "8080",
+	"server.grpc_port":         "9090",
+	"server.health_timeout":    "2s",
+	"server.drain_delay":       "0s",
+	"server.shutdown_timeout":  "30s",
+	"server.cors_origins":      []string{},
+	"server.disabled_features": []string{},
+
+	"server.tls.cert_file":       "",
+	"server.tls.key_file":        "",
+	"server.tls.min_version":     "1.2",
+	"server.tls.client_ca_file":  "",
+	"server.tls.allowed_clients": []string{},
+
+	"database.host":            "localhost",
+	"database.port":            5432,
+	"database.user":            "postgres",
+	"database.password":        "",
+	"database.dbname":          "users_db",
+	"database.sslmode":         "require",
+	"database.skip_migrations": false,
+
+	"tracing.exporter":     "none",
+	"tracing.endpoint":     "",
+	"tracing.insecure":     false,
+	"tracing.sample_ratio": 1.0,
+
+	"rate_limit.enabled":          true,
+	"rate_limit.storage":          "memory",
+	"rate_limit.key_by":           []string{"principal", "ip"},
+	"rate_limit.default.requests": 6000,
+	"rate_limit.default.period":   "1m",
+	"rate_limit.default.burst":    300,
+	// Signups are the usual target of spam, logins and password resets of
+	// credential stuffing and mail bombing, and MFA codes of guessing.
+	"rate_limit.routes": []map[string]any{
+		{"method": "POST", "path": "/users", "requests": 60, "period": "1m", "burst": 10},
+		{"method": "POST", "path": "/users/*/mfa/totp/verify", "requests": 10, "period": "1m", "burst": 5},
+		{"method": "", "path": "/users/*/mfa/totp", "requests": 10, "period": "1m", "burst": 5},
+		{"method": "POST", "path": "/auth/login", "requests": 10, "period": "1m", "burst": 5},
+		{"method": "POST", "path": "/auth/login/*", "requests": 10, "period": "1m", "burst": 5},
+		{"method": "POST", "path": "/auth/*", "requests": 30, "period": "1m", "burst": 10},
+		{"method": "POST", "path": "/auth/password/*", "requests": 10, "period": "1m", "burst": 5},
+	},
+
+	"email.canonical_gmail": false,
+	"email.blocked_domains": []string{},
+	"email.blocklist_file":  "",
+
+	"email_change.verify":      true,
+	"email_change.secret":      "",
+	"email_change.token_ttl":   "24h",
+	"email_change.revert_ttl":  "168h",
+	"email_change.confirm_url": "http://localhost:8080/users/{id}/email/confirm?token={token}",
+	"email_change.revert_url":  "http://localhost:8080/users/{id}/email/revert?token={token}",
+
+	"auth.reset_token_ttl":     "1h",
+	"auth.reset_url":           "http://localhost:8080/reset-password?token={token}",
+	"auth.session_ttl":         "1h",
+	"auth.refresh_ttl":         "720h",
+	"auth.min_password_length": 10,
+	"auth.mfa_issuer":          "User service",
+	"auth.mfa_challenge_ttl":   "5m",
+
+	"scim.bearer_tokens": []string{},
+
+	"mail.backend":             "file",
+	"mail.from":                "User service <no-reply@localhost>",
+	"mail.dir":                 "mail",
+	"mail.templates_dir":       "",
+	"mail.default_locale":      "en",
+	"mail.smtp.host":           "localhost",
+	"mail.smtp.port":           587,
+	"mail.smtp.username":       "",
+	"mail.smtp.password":       "",
+	"mail.smtp.tls":            "starttls",
+	"mail.smtp.timeout":        "10s",
+	"mail.queue.enabled":       true,
+	"mail.queue.max_attempts":  8,
+	"mail.queue.backoff":       "30s",
+	"mail.queue.max_backoff":   "1h",
+	"mail.queue.poll_interval": "10s",
+	"mail.queue.batch_size":    20,
+	"mail.queue.retention":     "168h",
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspect configuration",
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "print the effective configuration",
+	Long:  "print the configuration merged from defaults, --config and the environment, even when it is invalid",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFile, _ := cmd.Flags().GetString(config)
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+
+		if redactedValue, _ := cmd.Flags().GetBool(redacted); redactedValue {
+			config = config.Redacted()
+		}
+
+		enc := yaml.NewEncoder(cmd.OutOrStdout())
+		defer enc.Close()
+
+		return enc.Encode(config)
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check the effective configuration",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := resolveConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "print the JSON Schema of the config file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(configSchema())
+	},
+}
+
+func init() {
+	configPrintCmd.Flags().Bool(redacted, false, "mask secrets")
+
+	configCmd.AddCommand(configPrintCmd, configValidateCmd, configSchemaCmd)
+}
+
+// Validate reports every invalid setting, each prefixed with its full key.
+func (c Config) Validate() error {
+	sections := []struct {
+		key string
+		err error
+	}{
+		{"log", c.Log.Validate()},
+		{"server", c.Server.Validate()},
+		{"database", c.Database.Validate()},
+		{"tracing", c.Tracing.Validate()},
+		{"rate_limit", c.RateLimit.Validate()},
+		{"email", c.Email.Validate()},
+		{"email_change", c.EmailChange.Validate()},
+		{"auth", c.Auth.Validate()},
+		{"scim", c.SCIM.Validate()},
+		{"mail", c.Mail.Validate()},
+	}
+
+	var errs []error
+	for _, section := range sections {
+		if section.err == nil {
+			continue
+		}
+
+		causes := []error{section.err}
+		if joined, ok := section.err.(interface{ Unwrap() []error }); ok {
+			causes = joined.Unwrap()
+		}
+
+		for _, cause := range causes {
+			errs = append(errs, fmt.Errorf("%s.%w", section.key, cause))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.Join(append([]error{ErrInvalidConfig}, errs...)...)
+}
+
+// Redacted returns a copy of c with secrets masked, safe to print or log.
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = redactedValue
+	}
+
+	if c.EmailChange.Secret != "" {
+		c.EmailChange.Secret = redactedValue
+	}
+
+	if c.Mail.SMTP.Password != "" {
+		c.Mail.SMTP.Password = redactedValue
+	}
+
+	if len(c.SCIM.BearerTokens) > 0 {
+		tokens := make([]string, len(c.SCIM.BearerTokens))
+		for i := range tokens {
+			tokens[i] = redactedValue
+		}
+		c.SCIM.BearerTokens = tokens
+	}
+
+	return c
+}
+
+// resolveConfig loads and validates the configuration of cmd, see
+// readConfig, and applies its log level.
+func resolveConfig(cmd *cobra.Command) (Config, error) {
+	config, err := readConfig(cmd)
+	if err != nil {
+		logger.Error("cannot load config", zap.Error(err))
+		return Config{}, err
+	}
+
+	level, _ := zapcore.ParseLevel(config.Log.Level)
+	loggerLevel.SetLevel(level)
+
+	return config, nil
+}
+
+// readConfig loads the configuration from the file given by --config, if any,
+// overridden by the environment and --log-level, and validates it.
+func readConfig(cmd *cobra.Command) (Config, error) {
+	configFile, err := cmd.Flags().GetString(config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if cmd.Flags().Changed(logLevel) {
+		config.Log.Level, _ = cmd.Flags().GetString(logLevel)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// loadConfig merges, from lowest to highest precedence, the defaults, the
+// config file when configFile is not empty, USERSERVICE_* variables and
+// USERSERVICE_*_FILE secrets. The file format follows its extension.
+func loadConfig(configFile string) (Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err := v.ReadInConfig(); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, key := range v.AllKeys() {
+		value, ok, err := readSecretFile(key)
+		if err != nil {
+			return Config{}, err
+		}
+
+		if ok {
+			v.Set(key, value)
+		}
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// readSecretFile returns the content of the file named by the key's _FILE
+// variable, without the trailing newline editors and `echo` leave behind.
+func readSecretFile(key string) (string, bool, error) {
+	name := envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+
+	path, ok := os.LookupEnv(name + fileEnvSuffix)
+	if !ok {
+		return "", false, nil
+	}
+
+	if _, ok := os.LookupEnv(name); ok {
+		return "", false, errors.Join(ErrConflictingEnv, fmt.Errorf("%s and %s", name, name+fileEnvSuffix))
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
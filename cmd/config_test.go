@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/repository"
+	"go-user-service/src/tracing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		config, err := loadConfig("")
+		require.NoError(t, err, err)
+		require.Equal(t, "8080", config.Server.Port)
+		require.Equal(t, 30*time.Second, config.Server.ShutdownTimeout)
+		require.Equal(t, 5432, config.Database.Port)
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		dir := t.TempDir()
+		configFile := filepath.Join(dir, "service.prod.yml")
+		err := os.WriteFile(configFile, []byte("server:\n  port: 8081\ndatabase:\n  host: db\n  password: plain\n"), 0o600)
+		require.NoError(t, err, err)
+
+		secretFile := filepath.Join(dir, "password")
+		err = os.WriteFile(secretFile, []byte("s3cret\n"), 0o600)
+		require.NoError(t, err, err)
+
+		t.Setenv("USERSERVICE_DATABASE_HOST", "db.internal")
+		t.Setenv("USERSERVICE_DATABASE_PASSWORD_FILE", secretFile)
+
+		config, err := loadConfig(configFile)
+		require.NoError(t, err, err)
+		require.Equal(t, "8081", config.Server.Port)
+		require.Equal(t, "db.internal", config.Database.Host)
+		require.Equal(t, "s3cret", config.Database.Password)
+
+		t.Setenv("USERSERVICE_DATABASE_PASSWORD", "other")
+		_, err = loadConfig(configFile)
+		require.ErrorIs(t, err, ErrConflictingEnv)
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	config, err := loadConfig("")
+	require.NoError(t, err, err)
+	require.NoError(t, config.Validate())
+
+	config.Server.Port = "http"
+	config.Database.SslMode = "prefer"
+	config.Tracing.Exporter = "jaeger"
+
+	err = config.Validate()
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	require.ErrorIs(t, err, tracing.ErrUnknownExporter)
+	require.Contains(t, err.Error(), "server.port: ")
+	require.Contains(t, err.Error(), "database.sslmode: ")
+	require.Contains(t, err.Error(), "tracing.exporter: ")
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := configSchema()
+	properties := schema["properties"].(map[string]any)
+	require.ElementsMatch(t, []string{"log", "server", "database", "tracing", "rate_limit", "email", "email_change", "auth", "scim", "mail"}, keys(properties))
+
+	database := properties["database"].(map[string]any)["properties"].(map[string]any)
+	require.Equal(t, repository.SslModes, database["sslmode"].(map[string]any)["enum"])
+
+	// Every key with a default must be part of the schema.
+	for key := range defaults {
+		parts := strings.Split(key, ".")
+		parent := properties
+		for _, part := range parts[:len(parts)-1] {
+			parent = parent[part].(map[string]any)["properties"].(map[string]any)
+		}
+
+		require.Contains(t, parent, parts[len(parts)-1], key)
+	}
+}
+
+func keys(m map[string]any) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+
+	return result
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/exporter"
+	"go-user-service/src/repository"
+)
+
+const emailDomain = "email-domain"
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "stream users to a CSV, NDJSON or Parquet file",
+	Long: "stream users ordered by id to --output (default stdout) using a server-side cursor, " +
+		"so the table is never loaded into memory.",
+	Args: cobra.NoArgs,
+	RunE: runExportCmd,
+}
+
+func init() {
+	exportCmd.Flags().String(format, "", "output format: csv, ndjson or parquet (default: from --output extension, else csv)")
+	exportCmd.Flags().StringP(output, "o", "", "file to write (default: stdout)")
+	exportCmd.Flags().String(emailDomain, "", "only export users whose email is in this domain")
+	exportCmd.Flags().Int(after, 0, "only export users with a greater id")
+}
+
+func runExportCmd(cmd *cobra.Command, _ []string) error {
+	outputValue, _ := cmd.Flags().GetString(output)
+
+	formatValue, _ := cmd.Flags().GetString(format)
+	if formatValue == "" {
+		formatValue = strings.TrimPrefix(filepath.Ext(outputValue), ".")
+		if formatValue == "jsonl" {
+			formatValue = string(exporter.FormatNDJSON)
+		}
+
+		if formatValue == "" {
+			formatValue = string(exporter.FormatCSV)
+		}
+	}
+
+	if exporter.Format(formatValue).ContentType() == "" {
+		return exporter.ErrBadFormat
+	}
+
+	config, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	repo, err := openRepository(config.Database)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	var out io.Writer = cmd.OutOrStdout()
+	if outputValue != "" && outputValue != "-" {
+		file, err := os.Create(outputValue)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	domainValue, _ := cmd.Flags().GetString(emailDomain)
+	afterValue, _ := cmd.Flags().GetInt(after)
+
+	buffered := bufio.NewWriter(out)
+	total, err := exporter.Run(cmd.Context(), controllers.New(repo, controllers.Options{}), buffered, exporter.Options{
+		Format: exporter.Format(formatValue),
+		Filter: repository.ExportFilter{
+			EmailDomain: domainValue,
+			AfterId:     afterValue,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "exported %d users\n", total)
+
+	return nil
+}
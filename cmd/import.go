@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/importer"
+)
+
+const (
+	format    = "format"
+	upsert    = "upsert"
+	dryRun    = "dry-run"
+	batchSize = "batch-size"
+	report    = "report"
+)
+
+var ErrUnknownFormat = errors.New("cannot infer format from file name, pass --format csv|ndjson")
+
+var importCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "bulk import users from a CSV or NDJSON file (- for stdin)",
+	Long: "bulk import users from a CSV file with an email,name header or from NDJSON " +
+		"objects with email and name fields. Rejected rows are written to --report as CSV.",
+	Args: cobra.ExactArgs(1),
+	RunE: runImportCmd,
+}
+
+func init() {
+	importCmd.Flags().String(format, "", "input format: csv or ndjson (default: from file extension)")
+	importCmd.Flags().Bool(upsert, false, "update the name of users whose email already exists")
+	importCmd.Flags().Bool(dryRun, false, "validate and report without writing")
+	importCmd.Flags().Int(batchSize, importer.DefaultBatchSize, "rows per COPY batch")
+	importCmd.Flags().String(report, "", "write rejected rows to this CSV file (default: stderr)")
+}
+
+func runImportCmd(cmd *cobra.Command, args []string) error {
+	formatValue, _ := cmd.Flags().GetString(format)
+	if formatValue == "" {
+		formatValue = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+		if formatValue == "jsonl" {
+			formatValue = string(importer.FormatNDJSON)
+		}
+
+		if formatValue == "" {
+			return ErrUnknownFormat
+		}
+	}
+
+	config, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = cmd.InOrStdin()
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		in = file
+	}
+
+	reportOut := cmd.ErrOrStderr()
+	if reportFile, _ := cmd.Flags().GetString(report); reportFile != "" {
+		file, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reportOut = file
+	}
+
+	reportWriter := csv.NewWriter(reportOut)
+	reportWriter.Write([]string{"line", "email", "error"})
+
+	repo, err := openRepository(config.Database)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	emailPolicy, err := emailaddr.NewPolicy(config.Email)
+	if err != nil {
+		return err
+	}
+
+	upsertValue, _ := cmd.Flags().GetBool(upsert)
+	dryRunValue, _ := cmd.Flags().GetBool(dryRun)
+	batchSizeValue, _ := cmd.Flags().GetInt(batchSize)
+
+	result, err := importer.Run(cmd.Context(), controllers.New(repo, controllers.Options{Emails: emailPolicy}), in, importer.Options{
+		Format:    importer.Format(formatValue),
+		Upsert:    upsertValue,
+		DryRun:    dryRunValue,
+		BatchSize: batchSizeValue,
+		OnError: func(rowErr importer.RowError) {
+			reportWriter.Write([]string{strconv.Itoa(rowErr.Line), rowErr.Email, rowErr.Error})
+		},
+	})
+
+	reportWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	mode := "imported"
+	if result.DryRun {
+		mode = "dry run"
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s: %d rows, %d inserted, %d updated, %d failed\n",
+		mode, result.Total, result.Inserted, result.Updated, result.Failed)
+
+	return reportWriter.Error()
+}
@@ -1,109 +1,107 @@
 package main
 
 import (
-	"errors"
 	"os"
-	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"go-user-service/src/controllers"
-	"go-user-service/src/handlers"
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/logging"
+	"go-user-service/src/mailer"
+	"go-user-service/src/ratelimit"
 	"go-user-service/src/repository"
 	"go-user-service/src/repository/postgres"
+	"go-user-service/src/scim"
 	"go-user-service/src/server"
+	"go-user-service/src/tracing"
 )
 
-var ErrInvalidConfigFileName = errors.New("invalid config file name")
-
 const (
-	config = "config"
-	cfg    = "c"
+	config   = "config"
+	cfg      = "c"
+	logLevel = "log-level"
 )
 
 type Config struct {
-	Server   server.Config     `yaml:"server"`
-	Database repository.Config `yaml:"database"`
+	Log         logging.Config                `mapstructure:"log" yaml:"log"`
+	Server      server.Config                 `mapstructure:"server" yaml:"server"`
+	Database    repository.Config             `mapstructure:"database" yaml:"database"`
+	Tracing     tracing.Config                `mapstructure:"tracing" yaml:"tracing"`
+	RateLimit   ratelimit.Config              `mapstructure:"rate_limit" yaml:"rate_limit"`
+	Email       emailaddr.Config              `mapstructure:"email" yaml:"email"`
+	EmailChange controllers.EmailChangeConfig `mapstructure:"email_change" yaml:"email_change"`
+	Auth        controllers.AuthConfig        `mapstructure:"auth" yaml:"auth"`
+	SCIM        scim.Config                   `mapstructure:"scim" yaml:"scim"`
+	Mail        mailer.Config                 `mapstructure:"mail" yaml:"mail"`
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "userservice",
 	Short: "user service",
 	Long:  "user service",
-	RunE:  runRootCmd,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Arguments are valid by now; runtime errors need no usage dump.
+		cmd.SilenceUsage = true
+
+		level, _ := cmd.Flags().GetString(logLevel)
+		l, err := newLogger(level)
+		if err != nil {
+			return err
+		}
+
+		logger = l
+		// Code running outside a request logs through the global logger.
+		zap.ReplaceGlobals(logger)
+
+		return nil
+	},
 }
 
+// logger is built from --log-level before any subcommand runs. Its level
+// follows log.level once the config is loaded, and config reloads after that.
+var (
+	logger      = zap.NewNop()
+	loggerLevel = zap.NewAtomicLevel()
+)
+
 func init() {
-	rootCmd.Flags().StringP(config, cfg, "", "path to config file")
+	rootCmd.PersistentFlags().StringP(config, cfg, "", "path to config file, optional; see also USERSERVICE_* variables")
+	rootCmd.PersistentFlags().String(logLevel, zapcore.InfoLevel.String(), "log level: debug, info, warn, error; overrides log.level")
+
+	rootCmd.AddCommand(serveCmd, migrateCmd, healthcheckCmd, userCmd, importCmd, exportCmd, configCmd, versionCmd)
 }
 
 func main() {
 	err := rootCmd.Execute()
+	_ = logger.Sync()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
-func runRootCmd(cmd *cobra.Command, args []string) error {
-	logger, err := zap.NewProduction()
-	if err != nil {
-		panic(err)
-	}
-	defer logger.Sync()
-
-	err = cmd.Flags().Parse(args)
+func newLogger(level string) (*zap.Logger, error) {
+	lvl, err := zapcore.ParseLevel(level)
 	if err != nil {
-		logger.Error("failed to parse flags", zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	configFile, err := cmd.Flags().GetString(config)
-	if err != nil {
-		logger.Error("failed to get config path", zap.Error(err))
-		return err
-	}
+	loggerLevel.SetLevel(lvl)
 
-	splitPath := strings.Split(configFile, "/")
-	fullName := splitPath[len(splitPath)-1]
-	splitName := strings.Split(fullName, ".")
-	if len(splitName) != 2 {
-		logger.Error("cannot parse config", zap.String("file name", fullName))
-		return ErrInvalidConfigFileName
-	}
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = loggerLevel
 
-	viper.SetConfigName(splitName[0])
-	viper.SetConfigType(splitName[1])
-	viper.AddConfigPath(strings.Join(splitPath[:len(splitPath)-1], "/"))
-
-	// Read the config file
-	if err := viper.ReadInConfig(); err != nil {
-		logger.Error("cannot read config", zap.Error(err))
-		return err
-	}
-
-	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
-		logger.Error("unable to decode config file into struct", zap.Error(err))
-		return err
-	}
+	return zapConfig.Build()
+}
 
-	repo, err := postgres.NewRepository(config.Database)
+func openRepository(cfg repository.Config) (*postgres.Repository, error) {
+	repo, err := postgres.NewRepository(cfg)
 	if err != nil {
 		logger.Error("cannot create repo", zap.Error(err))
-		return err
-	}
-
-	userController := controllers.New(repo)
-	userHandler := handlers.New(userController)
-	microservice := server.New(config.Server, logger, userHandler)
-
-	err = microservice.Start()
-	if err != nil {
-		logger.Error("while running server", zap.Error(err))
-		return err
+		return nil, err
 	}
 
-	return nil
+	return repo, nil
 }
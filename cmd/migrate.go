@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "apply pending database migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := resolveConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Migrate explicitly below so the applied migrations can be reported.
+		config.Database.SkipMigrations = true
+		repo, err := openRepository(config.Database)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+
+		applied, err := repo.Migrate(cmd.Context())
+		for _, migration := range applied {
+			fmt.Fprintf(cmd.OutOrStdout(), "applied %s\n", migration.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "database is up to date")
+		}
+
+		return nil
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show applied and pending migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := resolveConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		config.Database.SkipMigrations = true
+		repo, err := openRepository(config.Database)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+
+		status, err := repo.MigrationStatus(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "current version %d, latest %d\n", status.Current, status.Latest)
+		for _, migration := range status.Pending {
+			fmt.Fprintf(cmd.OutOrStdout(), "pending %s\n", migration.Name)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateStatusCmd)
+}
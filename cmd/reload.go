@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var ErrRestartRequired = errors.New("changed settings require a restart")
+
+// reloadableKeys are the settings applied by a config reload. Changes to any
+// other key are rejected until the service restarts.
+var reloadableKeys = []string{
+	"log.level",
+	"server.cors_origins",
+	"server.disabled_features",
+	"rate_limit.enabled",
+	"rate_limit.key_by",
+	"rate_limit.default.requests",
+	"rate_limit.default.period",
+	"rate_limit.default.burst",
+	"rate_limit.routes",
+}
+
+// configChange is one setting that differs between two configurations.
+type configChange struct {
+	Key string
+	Old string
+	New string
+}
+
+// configWatcher reloads the config file whenever it changes and hands the new
+// configuration to apply, provided it is valid and only reloadable keys
+// changed. A rejected file leaves the running configuration untouched.
+type configWatcher struct {
+	cmd   *cobra.Command
+	apply func(Config)
+
+	mu      sync.Mutex
+	current Config
+}
+
+// watchConfig starts watching the --config file of cmd, if any.
+func watchConfig(cmd *cobra.Command, current Config, apply func(Config)) {
+	configFile, _ := cmd.Flags().GetString(config)
+	if configFile == "" {
+		return
+	}
+
+	w := &configWatcher{cmd: cmd, apply: apply, current: current}
+
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.OnConfigChange(func(fsnotify.Event) {
+		w.reload()
+	})
+	v.WatchConfig()
+
+	logger.Info("watching config for changes", zap.String("file", configFile), zap.Strings("reloadable", reloadableKeys))
+}
+
+func (w *configWatcher) reload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := readConfig(w.cmd)
+	if err != nil {
+		logger.Error("config reload rejected", zap.Error(err))
+		return
+	}
+
+	changes := diffConfig(w.current, next)
+	if len(changes) == 0 {
+		return
+	}
+
+	err = checkReloadable(changes)
+	if err != nil {
+		logger.Error("config reload rejected", zap.Error(err))
+		return
+	}
+
+	for _, change := range changes {
+		logger.Info("config changed", zap.String("key", change.Key), zap.String("old", change.Old), zap.String("new", change.New))
+	}
+
+	w.apply(next)
+	w.current = next
+}
+
+func checkReloadable(changes []configChange) error {
+	var keys []string
+	for _, change := range changes {
+		if !reloadable(change.Key) {
+			keys = append(keys, change.Key)
+		}
+	}
+
+	if len(keys) > 0 {
+		return errors.Join(ErrRestartRequired, fmt.Errorf("keys %s", strings.Join(keys, ", ")))
+	}
+
+	return nil
+}
+
+func reloadable(key string) bool {
+	for _, reloadableKey := range reloadableKeys {
+		if key == reloadableKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diffConfig lists the settings that differ between old and new, in field
+// order. Secrets are compared in full but reported masked.
+func diffConfig(old, new Config) []configChange {
+	oldValues, newValues := flattenConfig(old), flattenConfig(new)
+	oldShown, newShown := flattenConfig(old.Redacted()), flattenConfig(new.Redacted())
+
+	var changes []configChange
+	for i := range oldValues {
+		if oldValues[i].Value != newValues[i].Value {
+			changes = append(changes, configChange{Key: oldValues[i].Key, Old: oldShown[i].Value, New: newShown[i].Value})
+		}
+	}
+
+	return changes
+}
+
+type configValue struct {
+	Key   string
+	Value string
+}
+
+// flattenConfig lists the leaves of c in field order, keyed by their dotted
+// yaml names.
+func flattenConfig(c Config) []configValue {
+	return flatten(reflect.ValueOf(c), "", nil)
+}
+
+func flatten(v reflect.Value, prefix string, values []configValue) []configValue {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			values = flatten(v.Field(i), prefix+name+".", values)
+			continue
+		}
+
+		values = append(values, configValue{Key: prefix + name, Value: fmt.Sprint(v.Field(i).Interface())})
+	}
+
+	return values
+}
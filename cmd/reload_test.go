@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old, err := loadConfig("")
+	require.NoError(t, err, err)
+
+	next := old
+	next.Log.Level = "debug"
+	next.Database.Password = "changed"
+
+	changes := diffConfig(old, next)
+	require.Equal(t, []configChange{
+		{Key: "log.level", Old: "info", New: "debug"},
+		{Key: "database.password", Old: "", New: redactedValue},
+	}, changes)
+	require.ErrorIs(t, checkReloadable(changes), ErrRestartRequired)
+	require.NoError(t, checkReloadable(changes[:1]))
+}
+
+func TestWatchConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(configFile, []byte(content), 0o600))
+	}
+	writeConfig("log:\n  level: info\n")
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String(config, configFile, "")
+	cmd.Flags().String(logLevel, "", "")
+
+	current, err := readConfig(cmd)
+	require.NoError(t, err, err)
+
+	applied := make(chan Config, 10)
+	watchConfig(cmd, current, func(next Config) {
+		applied <- next
+	})
+
+	writeConfig("server:\n  port: 8081\n")
+	writeConfig("log:\n  level: warn\n")
+	writeConfig("log:\n  level: verbose\n")
+	writeConfig("log:\n  level: debug\nserver:\n  cors_origins: [https://admin.example.com]\n")
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case next := <-applied:
+			require.NotEqual(t, "8081", next.Server.Port)
+			if next.Log.Level == "debug" {
+				require.Equal(t, []string{"https://admin.example.com"}, next.Server.CORSOrigins)
+				return
+			}
+		case <-timeout:
+			t.Fatal("config change not applied")
+		}
+	}
+}
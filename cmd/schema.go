@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"go-user-service/src/mailer"
+	"go-user-service/src/ratelimit"
+	"go-user-service/src/repository"
+	"go-user-service/src/server"
+	"go-user-service/src/tracing"
+)
+
+const (
+	schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	// durationPattern matches what time.ParseDuration accepts.
+	durationPattern = `^-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// schemaConstraints refine the property generated for a key beyond its Go
+// type.
+var schemaConstraints = map[string]map[string]any{
+	// Ports are strings in Go but usually written as numbers in YAML.
+	"server.port":                {"type": []string{"string", "integer"}},
+	"server.grpc_port":           {"type": []string{"string", "integer"}},
+	"database.port":              {"minimum": 1, "maximum": 65535},
+	"database.sslmode":           {"enum": repository.SslModes},
+	"log.level":                  {"enum": []string{"debug", "info", "warn", "error"}},
+	"server.disabled_features[]": {"enum": server.Features},
+	"server.tls.min_version":     {"enum": []string{server.TLSVersion12, server.TLSVersion13}},
+	"rate_limit.storage":         {"enum": []string{ratelimit.StorageMemory, ratelimit.StoragePostgres}},
+	"rate_limit.key_by[]":        {"enum": []string{ratelimit.KeyPrincipal, ratelimit.KeyAPIKey, ratelimit.KeyIP}},
+	"tracing.exporter":           {"enum": []string{tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout}},
+	"tracing.sample_ratio":       {"minimum": 0, "maximum": 1},
+	"mail.backend":               {"enum": mailer.Backends},
+	"mail.smtp.tls":              {"enum": mailer.TLSModes},
+	"mail.smtp.port":             {"minimum": 1, "maximum": 65535},
+}
+
+// configSchema describes the config file as a JSON Schema, derived from the
+// yaml tags of Config, the defaults and schemaConstraints.
+func configSchema() map[string]any {
+	schema := structSchema(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = schemaDialect
+	schema["title"] = "userservice configuration"
+
+	return schema
+}
+
+func structSchema(t reflect.Type, prefix string) map[string]any {
+	properties := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		properties[name] = fieldSchema(field.Type, prefix+name)
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func fieldSchema(t reflect.Type, key string) map[string]any {
+	var schema map[string]any
+	switch {
+	case t == durationType:
+		schema = map[string]any{"type": "string", "pattern": durationPattern}
+	case t.Kind() == reflect.Struct:
+		return structSchema(t, key+".")
+	case t.Kind() == reflect.String:
+		schema = map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		schema = map[string]any{"type": "array", "items": fieldSchema(t.Elem(), key+"[]")}
+	default:
+		schema = map[string]any{}
+	}
+
+	if value, ok := defaults[key]; ok {
+		schema["default"] = value
+	}
+
+	for k, v := range schemaConstraints[key] {
+		schema[k] = v
+	}
+
+	return schema
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/gql"
+	"go-user-service/src/grpchandlers"
+	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/mailer"
+	"go-user-service/src/metrics"
+	"go-user-service/src/ratelimit"
+	"go-user-service/src/repository/postgres"
+	"go-user-service/src/scim"
+	"go-user-service/src/server"
+	"go-user-service/src/tracing"
+)
+
+var ErrMigrationsPending = errors.New("database migrations pending")
+
+var serveCmd = &cobra.Command{
+	Use:     "serve",
+	Aliases: []string{"run"},
+	Short:   "run the HTTP and gRPC servers",
+	Args:    cobra.NoArgs,
+	RunE:    runServeCmd,
+}
+
+func runServeCmd(cmd *cobra.Command, args []string) error {
+	config, err := resolveConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(cmd.Context(), config.Tracing, version)
+	if err != nil {
+		logger.Error("cannot set up tracing", zap.Error(err))
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	repo, err := openRepository(config.Database)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	serviceMetrics := metrics.New()
+	err = serviceMetrics.Register(collectors.NewDBStatsCollector(repo.DB(), config.Database.DbName))
+	if err != nil {
+		return err
+	}
+
+	healthRegistry := health.NewRegistry(config.Server.HealthTimeout)
+	healthRegistry.Register("database", repo.Ping)
+	healthRegistry.Register("migrations", migrationsCheck(repo))
+
+	emailPolicy, err := emailaddr.NewPolicy(config.Email)
+	if err != nil {
+		return err
+	}
+
+	mailSender, stopMail, err := newMailSender(config.Mail, repo)
+	if err != nil {
+		logger.Error("cannot set up mail", zap.Error(err))
+		return err
+	}
+	defer stopMail()
+
+	var emailChanges *controllers.EmailChanges
+	if config.EmailChange.Verify {
+		if config.EmailChange.Secret == "" {
+			logger.Warn("email_change.secret is not set; email change links only work on this replica until it restarts")
+		}
+
+		emailChanges, err = controllers.NewEmailChanges(config.EmailChange, mailSender)
+		if err != nil {
+			return err
+		}
+	}
+
+	auth := controllers.NewAuth(config.Auth, mailSender)
+	userController := controllers.New(serviceMetrics.Repository(repo), controllers.Options{
+		Emails:       emailPolicy,
+		EmailChanges: emailChanges,
+		Auth:         auth,
+	})
+	err = serviceMetrics.RegisterUsers(userController)
+	if err != nil {
+		return err
+	}
+
+	userHandler := handlers.New(userController)
+	grpcHandler := grpchandlers.New(userController)
+	graphqlHandler, err := gql.New(userController)
+	if err != nil {
+		logger.Error("cannot build graphql schema", zap.Error(err))
+		return err
+	}
+
+	scimHandler := scim.New(userController, config.SCIM)
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.RateLimit.Storage == ratelimit.StoragePostgres {
+		rateLimitStore = repo.RateLimitStore()
+	}
+
+	limiter := ratelimit.New(config.RateLimit, rateLimitStore)
+	microservice := server.New(config.Server, logger, serviceMetrics, healthRegistry, server.Handlers{
+		HTTP:    userHandler,
+		GRPC:    grpcHandler,
+		GraphQL: graphqlHandler,
+		SCIM:    scimHandler,
+	}, server.Options{Limiter: limiter})
+
+	watchConfig(cmd, config, func(next Config) {
+		level, _ := zapcore.ParseLevel(next.Log.Level)
+		loggerLevel.SetLevel(level)
+		microservice.Reload(next.Server)
+		limiter.Reload(next.RateLimit)
+	})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- microservice.Start()
+	}()
+
+	select {
+	case err = <-errCh:
+		if err != nil {
+			logger.Error("while running server", zap.Error(err))
+		}
+
+		userController.Close()
+		return err
+	case sig := <-signals:
+		logger.Info("shutting down", zap.Stringer("signal", sig),
+			zap.Duration("drain_delay", config.Server.DrainDelay),
+			zap.Duration("shutdown_timeout", microservice.ShutdownTimeout()))
+	}
+
+	// A second signal skips the drain delay and the wait for in-flight
+	// requests.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-signals:
+			logger.Warn("forcing shutdown")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return shutdown(ctx, microservice, userController)
+}
+
+// shutdown stops the service in dependency order: readiness fails first so
+// load balancers move traffic away, watch streams are ended so they do not
+// block the gRPC server, in-flight requests finish and the deferred closing of
+// the repository and tracing exporter follows.
+func shutdown(ctx context.Context, microservice *server.Server, userController *controllers.Controller) error {
+	err := microservice.Drain(ctx)
+	if err != nil {
+		logger.Warn("drain interrupted", zap.Error(err))
+	}
+
+	userController.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, microservice.ShutdownTimeout())
+	defer cancel()
+
+	err = microservice.Shutdown(ctx)
+	if err != nil {
+		logger.Error("while shutting down server", zap.Error(err))
+		return err
+	}
+
+	logger.Info("server stopped")
+	return nil
+}
+
+// newMailSender builds the configured mailer and templates. With the queue
+// enabled, messages are stored in the database and sent in the background
+// until stop is called, which lets the messages being sent finish.
+func newMailSender(cfg mailer.Config, repo *postgres.Repository) (*mailer.Sender, func(), error) {
+	templates, err := mailer.LoadTemplates(cfg.TemplatesDir, cfg.DefaultLocale)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backend, err := mailer.New(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !cfg.Queue.Enabled {
+		return mailer.NewSender(templates, backend), func() {}, nil
+	}
+
+	queue := mailer.NewQueue(cfg.Queue, repo.MailQueueStore(), backend, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Run(ctx)
+	}()
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+
+	return mailer.NewSender(templates, queue), stop, nil
+}
+
+// migrationsCheck fails while the schema is behind this binary. Migrations
+// cannot be rolled back underneath a running server, so once the schema is
+// current the check stops querying the database.
+func migrationsCheck(repo *postgres.Repository) health.Check {
+	var upToDate atomic.Bool
+
+	return func(ctx context.Context) error {
+		if upToDate.Load() {
+			return nil
+		}
+
+		status, err := repo.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !status.UpToDate() {
+			return fmt.Errorf("%w: at version %d of %d", ErrMigrationsPending, status.Current, status.Latest)
+		}
+
+		upToDate.Store(true)
+		return nil
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"go-user-service/src/client"
+	"go-user-service/src/controllers"
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	serverURL = "server"
+	token     = "token"
+	output    = "output"
+	email     = "email"
+	name      = "name"
+	after     = "after"
+	limit     = "limit"
+
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var (
+	ErrBadUserIdArg  = errors.New("user id must be a positive integer")
+	ErrBadOutput     = errors.New("output must be one of table, json, yaml")
+	ErrNothingToDo   = errors.New("nothing to update, pass --email and/or --name")
+	ErrBothBackends  = errors.New("--config and --server are mutually exclusive")
+	ErrMissingFields = errors.New("--email and --name are required")
+)
+
+// userBackend is implemented by client.Client for remote access and by
+// localBackend for direct database access.
+type userBackend interface {
+	Create(ctx context.Context, email, name string) (int, error)
+	Get(ctx context.Context, id int) (*models.User, error)
+	Update(ctx context.Context, user models.User) (*models.User, error)
+	UpdateEmail(ctx context.Context, id int, email string) (*models.User, error)
+	Delete(ctx context.Context, id int) error
+	List(ctx context.Context, after, limit int) (*client.ListResult, error)
+}
+
+type localBackend struct {
+	controller *controllers.Controller
+}
+
+func (b *localBackend) Create(ctx context.Context, email, name string) (int, error) {
+	return b.controller.Create(ctx, &models.User{Email: email, Name: name})
+}
+
+func (b *localBackend) Get(ctx context.Context, id int) (*models.User, error) {
+	return b.controller.Get(ctx, id)
+}
+
+func (b *localBackend) Update(ctx context.Context, user models.User) (*models.User, error) {
+	err := b.controller.Update(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (b *localBackend) UpdateEmail(ctx context.Context, id int, email string) (*models.User, error) {
+	_, err := b.controller.UpdateEmail(ctx, id, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.controller.Get(ctx, id)
+}
+
+func (b *localBackend) Delete(ctx context.Context, id int) error {
+	return b.controller.Delete(ctx, id)
+}
+
+func (b *localBackend) List(ctx context.Context, after, limit int) (*client.ListResult, error) {
+	users, err := b.controller.List(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &client.ListResult{Users: make([]models.User, 0, len(users))}
+	for _, user := range users {
+		result.Users = append(result.Users, *user)
+	}
+
+	if len(users) > 0 && len(users) == controllers.PageSize(limit) {
+		result.NextAfter = users[len(users)-1].Id
+	}
+
+	return result, nil
+}
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "manage users",
+	Long:  "manage users directly in the configured database or through a running server (--server)",
+	// Check --output before any command touches the backend, so a typo
+	// cannot leave a mutation applied but unreported.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Cobra only runs the closest persistent hook.
+		err := rootCmd.PersistentPreRunE(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		format, _ := cmd.Flags().GetString(output)
+		switch format {
+		case outputTable, outputJSON, outputYAML:
+			return nil
+		default:
+			return ErrBadOutput
+		}
+	},
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "create a user",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		emailValue, _ := cmd.Flags().GetString(email)
+		nameValue, _ := cmd.Flags().GetString(name)
+		if emailValue == "" || nameValue == "" {
+			return ErrMissingFields
+		}
+
+		id, err := backend.Create(cmd.Context(), emailValue, nameValue)
+		if err != nil {
+			return err
+		}
+
+		return printUser(cmd, models.User{Id: id, Email: emailValue, Name: nameValue})
+	},
+}
+
+var userGetCmd = &cobra.Command{
+	Use:   "get ID",
+	Short: "show a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseUserId(args[0])
+		if err != nil {
+			return err
+		}
+
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		user, err := backend.Get(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return printUser(cmd, *user)
+	},
+}
+
+var userUpdateCmd = &cobra.Command{
+	Use:   "update ID",
+	Short: "update a user's email and/or name",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseUserId(args[0])
+		if err != nil {
+			return err
+		}
+
+		if !cmd.Flags().Changed(email) && !cmd.Flags().Changed(name) {
+			return ErrNothingToDo
+		}
+
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		user, err := backend.Get(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed(email) {
+			user.Email, _ = cmd.Flags().GetString(email)
+		}
+
+		if cmd.Flags().Changed(name) {
+			user.Name, _ = cmd.Flags().GetString(name)
+		}
+
+		user, err = backend.Update(cmd.Context(), *user)
+		if err != nil {
+			return err
+		}
+
+		return printUser(cmd, *user)
+	},
+}
+
+var userSetEmailCmd = &cobra.Command{
+	Use:   "set-email ID EMAIL",
+	Short: "change a user's email",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseUserId(args[0])
+		if err != nil {
+			return err
+		}
+
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		user, err := backend.UpdateEmail(cmd.Context(), id, args[1])
+		if err != nil {
+			return err
+		}
+
+		return printUser(cmd, *user)
+	},
+}
+
+var userDeleteCmd = &cobra.Command{
+	Use:   "delete ID",
+	Short: "delete a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseUserId(args[0])
+		if err != nil {
+			return err
+		}
+
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		err = backend.Delete(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "deleted user %d\n", id)
+		return nil
+	},
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list users ordered by id",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, closeBackend, err := newUserBackend(cmd)
+		if err != nil {
+			return err
+		}
+		defer closeBackend()
+
+		afterValue, _ := cmd.Flags().GetInt(after)
+		limitValue, _ := cmd.Flags().GetInt(limit)
+
+		page, err := backend.List(cmd.Context(), afterValue, limitValue)
+		if err != nil {
+			return err
+		}
+
+		err = printUsers(cmd, page.Users)
+		if err != nil {
+			return err
+		}
+
+		if page.NextAfter != 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "more users available, continue with --after %d\n", page.NextAfter)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	userCmd.PersistentFlags().String(serverURL, "", "base URL of a running server, e.g. http://localhost:8080")
+	userCmd.PersistentFlags().String(token, "", "bearer token sent to the server")
+	userCmd.PersistentFlags().StringP(output, "o", outputTable, "output format: table, json or yaml")
+
+	userCreateCmd.Flags().String(email, "", "email of the new user")
+	userCreateCmd.Flags().String(name, "", "name of the new user")
+
+	userUpdateCmd.Flags().String(email, "", "new email")
+	userUpdateCmd.Flags().String(name, "", "new name")
+
+	userListCmd.Flags().Int(after, 0, "only list users with a greater id")
+	userListCmd.Flags().Int(limit, controllers.DefaultPageSize, "maximum number of users to list")
+
+	userCmd.AddCommand(userCreateCmd, userGetCmd, userUpdateCmd, userSetEmailCmd, userDeleteCmd, userListCmd)
+}
+
+// newUserBackend returns the backend selected by the flags and a function
+// releasing it once the command is done.
+func newUserBackend(cmd *cobra.Command) (userBackend, func(), error) {
+	configFile, _ := cmd.Flags().GetString(config)
+	baseURL, _ := cmd.Flags().GetString(serverURL)
+
+	switch {
+	case configFile != "" && baseURL != "":
+		return nil, nil, ErrBothBackends
+	case baseURL != "":
+		tokenValue, _ := cmd.Flags().GetString(token)
+		return client.New(client.Config{BaseURL: baseURL, Token: tokenValue}), func() {}, nil
+	default:
+		config, err := resolveConfig(cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		emailPolicy, err := emailaddr.NewPolicy(config.Email)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		repo, err := openRepository(config.Database)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		controller := controllers.New(repo, controllers.Options{Emails: emailPolicy})
+		closeBackend := func() {
+			controller.Close()
+			repo.Close()
+		}
+
+		return &localBackend{controller: controller}, closeBackend, nil
+	}
+}
+
+func parseUserId(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id <= 0 {
+		return 0, ErrBadUserIdArg
+	}
+
+	return id, nil
+}
+
+func printUser(cmd *cobra.Command, user models.User) error {
+	return render(cmd, user, []models.User{user})
+}
+
+func printUsers(cmd *cobra.Command, users []models.User) error {
+	return render(cmd, users, users)
+}
+
+func render(cmd *cobra.Command, value interface{}, rows []models.User) error {
+	format, _ := cmd.Flags().GetString(output)
+
+	switch format {
+	case outputTable:
+		return printTable(cmd.OutOrStdout(), rows)
+	case outputJSON:
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	case outputYAML:
+		enc := yaml.NewEncoder(cmd.OutOrStdout())
+		defer enc.Close()
+		return enc.Encode(value)
+	default:
+		return ErrBadOutput
+	}
+}
+
+func printTable(w io.Writer, users []models.User) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME")
+	for _, user := range users {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", user.Id, user.Email, user.Name)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserOutput(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	rootCmd.SetArgs([]string{"user", "delete", "1", "--server", server.URL, "--output", "xml"})
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetArgs(nil)
+
+	err := rootCmd.Execute()
+	require.ErrorIs(t, err, ErrBadOutput)
+	require.Zero(t, requests.Load(), "the delete must not be sent")
+}
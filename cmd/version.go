@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "print build information",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		revision, modified, buildTime := "unknown", false, "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					revision = setting.Value
+				case "vcs.modified":
+					modified = setting.Value == "true"
+				case "vcs.time":
+					buildTime = setting.Value
+				}
+			}
+		}
+
+		if modified {
+			revision += "-dirty"
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "version:  %s\n", version)
+		fmt.Fprintf(out, "revision: %s\n", revision)
+		fmt.Fprintf(out, "built:    %s\n", buildTime)
+		fmt.Fprintf(out, "go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	},
+}
@@ -0,0 +1,41 @@
+// Package rest holds the request and response bodies of the HTTP API shared
+// by the server's handlers and the Go client. It only depends on models, so
+// clients do not pull in the server.
+package rest
+
+import "go-user-service/src/repository/models"
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type CreateRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type CreateResponse struct {
+	Id int `json:"id"`
+}
+
+type UpdateRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type UpdateEmailRequest struct {
+	Email *string `json:"email"`
+}
+
+// EmailTokenRequest carries a token mailed to the user; it may also be
+// passed as the token query parameter.
+type EmailTokenRequest struct {
+	Token string `json:"token"`
+}
+
+type ListResponse struct {
+	Users []*models.User `json:"users"`
+	// NextAfter is the value to pass as after to fetch the next page; zero
+	// when there are no more users.
+	NextAfter int `json:"next_after,omitempty"`
+}
@@ -0,0 +1,285 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	// NoRetries as Config.MaxRetries makes every call a single attempt.
+	NoRetries = -1
+)
+
+type Config struct {
+	// BaseURL is the service root, e.g. http://user-app:8080.
+	BaseURL string
+	// Token is sent as a bearer token when set.
+	Token string
+	// Timeout bounds each attempt; the caller's context bounds the whole call.
+	Timeout time.Duration
+	// MaxRetries is how often an idempotent call is retried: zero means
+	// DefaultMaxRetries and a negative value, such as NoRetries, disables
+	// retries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	HTTPClient   *http.Client
+}
+
+type Client struct {
+	baseURL      string
+	token        string
+	timeout      time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+	http         *http.Client
+}
+
+type ListResult struct {
+	Users []models.User
+	// NextAfter is passed as after to fetch the next page; zero on the last page.
+	NextAfter int
+}
+
+func New(cfg Config) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(cfg.BaseURL, "/"),
+		token:        cfg.Token,
+		timeout:      cfg.Timeout,
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+		http:         cfg.HTTPClient,
+	}
+
+	if c.timeout == 0 {
+		c.timeout = DefaultTimeout
+	}
+
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = DefaultMaxRetries
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+
+	if c.retryBackoff == 0 {
+		c.retryBackoff = DefaultRetryBackoff
+	}
+
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+
+	return c
+}
+
+func (c *Client) Create(ctx context.Context, email, name string) (int, error) {
+	resp := rest.CreateResponse{}
+	err := c.do(ctx, http.MethodPost, "/users", rest.CreateRequest{Email: email, Name: name}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Id, nil
+}
+
+func (c *Client) Get(ctx context.Context, id int) (*models.User, error) {
+	user := &models.User{}
+	err := c.do(ctx, http.MethodGet, userPath(id), nil, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (c *Client) Update(ctx context.Context, user models.User) (*models.User, error) {
+	updated := &models.User{}
+	req := rest.UpdateRequest{Email: user.Email, Name: user.Name}
+	err := c.do(ctx, http.MethodPut, userPath(user.Id), req, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// UpdateEmail changes a user's email. When the service verifies changes
+// the email stays as it was and PendingEmail is set until the new address
+// confirms with ConfirmEmail.
+func (c *Client) UpdateEmail(ctx context.Context, id int, email string) (*models.User, error) {
+	updated := &models.User{}
+	err := c.do(ctx, http.MethodPatch, userPath(id), rest.UpdateEmailRequest{Email: &email}, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// ConfirmEmail completes an email change with the token mailed to the new
+// address.
+func (c *Client) ConfirmEmail(ctx context.Context, id int, token string) (*models.User, error) {
+	user := &models.User{}
+	err := c.do(ctx, http.MethodPost, userPath(id)+"/email/confirm", rest.EmailTokenRequest{Token: token}, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// RevertEmail undoes an email change with the token mailed to the old
+// address.
+func (c *Client) RevertEmail(ctx context.Context, id int, token string) (*models.User, error) {
+	user := &models.User{}
+	err := c.do(ctx, http.MethodPost, userPath(id)+"/email/revert", rest.EmailTokenRequest{Token: token}, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (c *Client) Delete(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, userPath(id), nil, nil)
+}
+
+// List returns users with id greater than after. A zero limit uses the
+// server's default page size.
+func (c *Client) List(ctx context.Context, after, limit int) (*ListResult, error) {
+	query := url.Values{}
+	if after > 0 {
+		query.Set("after", strconv.Itoa(after))
+	}
+
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := "/users"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp := rest.ListResponse{}
+	err := c.do(ctx, http.MethodGet, path, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListResult{Users: make([]models.User, 0, len(resp.Users)), NextAfter: resp.NextAfter}
+	for _, user := range resp.Users {
+		result.Users = append(result.Users, *user)
+	}
+
+	return result, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = c.attempt(ctx, method, path, payload, out)
+		if !retry || attempt >= c.maxRetries || !idempotent(method) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+}
+
+// attempt performs a single request and reports whether a failure is worth
+// retrying.
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, out interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		errResp := rest.ErrorResponse{}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(raw, &errResp) != nil {
+			errResp.Error = strings.TrimSpace(string(raw))
+		}
+
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, newAPIError(resp.StatusCode, errResp.Error)
+	}
+
+	if out == nil {
+		return false, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrUnexpected, err)
+	}
+
+	return false, nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retryBackoff << attempt
+	return d/2 + rand.N(d/2+1)
+}
+
+func idempotent(method string) bool {
+	return method != http.MethodPost
+}
+
+func userPath(id int) string {
+	return "/users/" + strconv.Itoa(id)
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+	"go.uber.org/zap"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/gql"
+	"go-user-service/src/grpchandlers"
+	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/mailer"
+	"go-user-service/src/metrics"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+	"go-user-service/src/repository/postgres"
+	"go-user-service/src/scim"
+	"go-user-service/src/server"
+)
+
+// setupServer serves a fresh database; the returned repository lets tests
+// create sessions directly.
+func setupServer(t *testing.T) (*httptest.Server, *postgres.Repository) {
+	ctx := context.Background()
+
+	testUser := "user"
+	testPass := "pass"
+	testDb := "db"
+
+	pgContainer, err := testpostgres.Run(
+		ctx,
+		"postgres:alpine",
+		testpostgres.WithDatabase(testDb),
+		testpostgres.WithUsername(testUser),
+		testpostgres.WithPassword(testPass),
+		testpostgres.BasicWaitStrategies(),
+		testcontainers.WithLogger(testcontainers.Logger),
+	)
+	require.NoError(t, err, err)
+	t.Cleanup(func() {
+		dur := time.Second
+		pgContainer.Stop(ctx, &dur)
+	})
+
+	dbHost, err := pgContainer.Host(ctx)
+	require.NoError(t, err, err)
+
+	dbPort, err := pgContainer.MappedPort(ctx, "5432")
+	require.NoError(t, err, err)
+
+	repo, err := postgres.NewRepository(repository.Config{
+		Host:     dbHost,
+		Port:     dbPort.Int(),
+		User:     testUser,
+		Password: testPass,
+		DbName:   testDb,
+		SslMode:  "disable",
+	})
+	require.NoError(t, err, err)
+
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err, err)
+
+	auth := controllers.NewAuth(controllers.AuthConfig{SessionTTL: time.Hour, RefreshTTL: time.Hour}, mailer.NewSender(templates, mailer.NewLogMailer(zap.NewNop())))
+	userController := controllers.New(repo, controllers.Options{Auth: auth})
+	graphqlHandler, err := gql.New(userController)
+	require.NoError(t, err, err)
+
+	microservice := server.New(
+		server.Config{},
+		zap.NewNop(),
+		metrics.New(),
+		health.NewRegistry(0),
+		server.Handlers{
+			HTTP:    handlers.New(userController),
+			GRPC:    grpchandlers.New(userController),
+			GraphQL: graphqlHandler,
+			SCIM:    scim.New(userController, scim.Config{}),
+		},
+		server.Options{},
+	)
+
+	ts := httptest.NewServer(microservice.HTTPHandler())
+	t.Cleanup(ts.Close)
+
+	return ts, repo
+}
+
+func TestClient(t *testing.T) {
+	ts, repo := setupServer(t)
+	ctx := context.Background()
+	c := New(Config{BaseURL: ts.URL, Token: "token"})
+
+	id := 0
+	t.Run("Create", func(t *testing.T) {
+		var err error
+		id, err = c.Create(ctx, "client@example.com", "Client")
+		require.NoError(t, err, err)
+		require.Greater(t, id, 0)
+	})
+
+	t.Run("UpdateWithoutSession", func(t *testing.T) {
+		_, err := c.Update(ctx, models.User{Id: id, Email: "updated@example.com", Name: "Updated"})
+		require.ErrorIs(t, err, ErrUnauthorized)
+
+		// Make the client's token a session of the user.
+		tokenHash := sha256.Sum256([]byte("token"))
+		err = repo.CreateSession(ctx, &repository.Session{
+			UserId:           id,
+			TokenHash:        tokenHash[:],
+			RefreshHash:      []byte("refresh"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err, err)
+	})
+
+	t.Run("CreateConflict", func(t *testing.T) {
+		_, err := c.Create(ctx, "client@example.com", "Client")
+		require.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("CreateInvalid", func(t *testing.T) {
+		_, err := c.Create(ctx, "", "Client")
+		require.ErrorIs(t, err, ErrValidation)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		user, err := c.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.Equal(t, models.User{Id: id, Email: "client@example.com", Name: "Client"}, *user)
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		_, err := c.Get(ctx, id+1000)
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		user, err := c.Update(ctx, models.User{Id: id, Email: "updated@example.com", Name: "Updated"})
+		require.NoError(t, err, err)
+		require.Equal(t, "Updated", user.Name)
+	})
+
+	t.Run("UpdateEmail", func(t *testing.T) {
+		user, err := c.UpdateEmail(ctx, id, "email@example.com")
+		require.NoError(t, err, err)
+		require.Equal(t, "email@example.com", user.Email)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		_, err := c.Create(ctx, "second@example.com", "Second")
+		require.NoError(t, err, err)
+
+		page, err := c.List(ctx, 0, 1)
+		require.NoError(t, err, err)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, id, page.Users[0].Id)
+
+		page, err = c.List(ctx, page.NextAfter, 10)
+		require.NoError(t, err, err)
+		require.Len(t, page.Users, 1)
+		require.Zero(t, page.NextAfter)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		err := c.Delete(ctx, id)
+		require.NoError(t, err, err)
+
+		// Deleting the user ended the session.
+		err = c.Delete(ctx, id)
+		require.ErrorIs(t, err, ErrUnauthorized)
+	})
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Id":7,"Email":"a@example.com","Name":"A"}`))
+	}))
+	defer ts.Close()
+
+	c := New(Config{BaseURL: ts.URL, Token: "secret", RetryBackoff: time.Millisecond})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		user, err := c.Get(context.Background(), 7)
+		require.NoError(t, err, err)
+		require.Equal(t, 7, user.Id)
+		require.EqualValues(t, 3, calls.Load())
+	})
+
+	t.Run("CreateNotRetried", func(t *testing.T) {
+		calls.Store(0)
+		_, err := c.Create(context.Background(), "a@example.com", "A")
+		require.ErrorIs(t, err, ErrServer)
+		require.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		calls.Store(-10)
+		_, err := c.Get(context.Background(), 7)
+		require.ErrorIs(t, err, ErrServer)
+		require.EqualValues(t, -10+DefaultMaxRetries+1, calls.Load())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		c := New(Config{BaseURL: ts.URL, Token: "secret", MaxRetries: NoRetries})
+
+		calls.Store(0)
+		_, err := c.Get(context.Background(), 7)
+		require.ErrorIs(t, err, ErrServer)
+		require.EqualValues(t, 1, calls.Load())
+	})
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNotFound     = errors.New("user not found")
+	ErrConflict     = errors.New("user already exists")
+	ErrValidation   = errors.New("invalid request")
+	ErrUnauthorized = errors.New("not authorized")
+	ErrServer       = errors.New("server error")
+	ErrUnexpected   = errors.New("unexpected response")
+	ErrRateLimited  = errors.New("rate limited")
+)
+
+// APIError is returned for non-2xx responses. It unwraps to one of the
+// package errors, so callers can use errors.Is(err, client.ErrNotFound).
+type APIError struct {
+	StatusCode int
+	Message    string
+
+	kind error
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s: status %d", e.kind, e.StatusCode)
+	}
+
+	return fmt.Sprintf("%s: status %d: %s", e.kind, e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+func newAPIError(status int, message string) *APIError {
+	kind := ErrUnexpected
+	switch {
+	case status == http.StatusNotFound:
+		kind = ErrNotFound
+	case status == http.StatusConflict:
+		kind = ErrConflict
+	case status == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case status >= 400 && status < 500:
+		kind = ErrValidation
+	case status >= 500:
+		kind = ErrServer
+	}
+
+	return &APIError{StatusCode: status, Message: message, kind: kind}
+}
@@ -0,0 +1,401 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"go-user-service/src/logging"
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	DefaultResetTokenTTL = time.Hour
+	DefaultSessionTTL    = time.Hour
+	DefaultRefreshTTL    = 30 * 24 * time.Hour
+
+	// MinPasswordLength is the lowest AuthConfig.MinPasswordLength allowed.
+	MinPasswordLength = 8
+	// MaxPasswordSize bounds the bytes hashed per login attempt.
+	MaxPasswordSize = 1024
+
+	templateResetPassword   = "reset_password"
+	templatePasswordChanged = "password_changed"
+
+	tokenSize = 32
+)
+
+var (
+	ErrBadPassword    = errors.New("bad password")
+	ErrBadCredentials = errors.New("invalid email or password")
+	ErrBadSession     = errors.New("invalid or expired session")
+)
+
+type AuthConfig struct {
+	// ResetTokenTTL is how long a password reset link works.
+	ResetTokenTTL time.Duration `mapstructure:"reset_token_ttl" yaml:"reset_token_ttl"`
+	// ResetURL is the link mailed to reset a password, with {token}
+	// replaced. Like the email change links it should lead to a page that
+	// POSTs the token along with the new password.
+	ResetURL string `mapstructure:"reset_url" yaml:"reset_url"`
+	// SessionTTL is how long an access token works and RefreshTTL how long
+	// its refresh token can replace it.
+	SessionTTL time.Duration `mapstructure:"session_ttl" yaml:"session_ttl"`
+	RefreshTTL time.Duration `mapstructure:"refresh_ttl" yaml:"refresh_ttl"`
+	// MinPasswordLength is counted in characters.
+	MinPasswordLength int `mapstructure:"min_password_length" yaml:"min_password_length"`
+	// MFAIssuer names the service in authenticator apps.
+	MFAIssuer string `mapstructure:"mfa_issuer" yaml:"mfa_issuer"`
+	// MFAChallengeTTL is how long a login can wait for its second factor.
+	MFAChallengeTTL time.Duration `mapstructure:"mfa_challenge_ttl" yaml:"mfa_challenge_ttl"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c AuthConfig) Validate() error {
+	var errs []error
+	if c.ResetTokenTTL <= 0 {
+		errs = append(errs, fmt.Errorf("reset_token_ttl: must be positive, got %s", c.ResetTokenTTL))
+	}
+
+	if !strings.Contains(c.ResetURL, PlaceholderToken) {
+		errs = append(errs, fmt.Errorf("reset_url: must contain %s", PlaceholderToken))
+	}
+
+	if c.SessionTTL <= 0 {
+		errs = append(errs, fmt.Errorf("session_ttl: must be positive, got %s", c.SessionTTL))
+	}
+
+	if c.RefreshTTL < c.SessionTTL {
+		errs = append(errs, fmt.Errorf("refresh_ttl: must be at least session_ttl, got %s", c.RefreshTTL))
+	}
+
+	if c.MinPasswordLength < MinPasswordLength {
+		errs = append(errs, fmt.Errorf("min_password_length: must be at least %d, got %d", MinPasswordLength, c.MinPasswordLength))
+	}
+
+	if c.MFAIssuer == "" || strings.Contains(c.MFAIssuer, ":") {
+		errs = append(errs, fmt.Errorf("mfa_issuer: must be set and contain no colon, got %q", c.MFAIssuer))
+	}
+
+	if c.MFAChallengeTTL <= 0 {
+		errs = append(errs, fmt.Errorf("mfa_challenge_ttl: must be positive, got %s", c.MFAChallengeTTL))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Auth issues password reset tokens and sessions, and mails users about
+// their password.
+type Auth struct {
+	cfg    AuthConfig
+	mailer *mailer.Sender
+	now    func() time.Time
+}
+
+func NewAuth(cfg AuthConfig, m *mailer.Sender) *Auth {
+	return &Auth{cfg: cfg, mailer: m, now: time.Now}
+}
+
+// Session is a login: AccessToken authenticates requests until ExpiresAt,
+// and RefreshToken gets a new session for as long as the refresh TTL.
+type Session struct {
+	UserId       int
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// newToken returns a random token and the hash it is stored by.
+func newToken() (string, []byte, error) {
+	raw := make([]byte, tokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// newSession returns the tokens of a new session and their stored form.
+func (a *Auth) newSession(userId int) (*Session, *repository.Session, error) {
+	accessToken, tokenHash, err := newToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, refreshHash, err := newToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := a.now()
+	session := &Session{
+		UserId:       userId,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    now.Add(a.cfg.SessionTTL),
+	}
+
+	stored := &repository.Session{
+		UserId:           userId,
+		TokenHash:        tokenHash,
+		RefreshHash:      refreshHash,
+		ExpiresAt:        session.ExpiresAt,
+		RefreshExpiresAt: now.Add(a.cfg.RefreshTTL),
+	}
+
+	return session, stored, nil
+}
+
+func (a *Auth) validatePassword(password string) error {
+	if len(password) > MaxPasswordSize {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrBadPassword, MaxPasswordSize)
+	}
+
+	if utf8.RuneCountInString(password) < a.cfg.MinPasswordLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrBadPassword, a.cfg.MinPasswordLength)
+	}
+
+	return nil
+}
+
+// ForgotPassword mails a password reset link to the user owning email, if
+// any. It only fails when the users cannot be looked up, so callers cannot
+// tell whether an account exists.
+func (c *Controller) ForgotPassword(ctx context.Context, email string) error {
+	ctx, span := tracer.Start(ctx, "Controller.ForgotPassword")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil
+	}
+
+	user, err := c.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Whoever confirmed a new email must not reset the password through it
+	// before the old address had its chance to revert the change.
+	if c.changes != nil {
+		changedAt, err := c.repo.EmailChangedAt(ctx, user.Id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !changedAt.IsZero() && c.auth.now().Before(changedAt.Add(c.changes.cfg.RevertTTL)) {
+			logging.FromContext(ctx).Info("password reset refused within the email revert window", zap.Int("user_id", user.Id))
+			return nil
+		}
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := c.auth.now().Add(c.auth.cfg.ResetTokenTTL).Truncate(time.Second)
+	err = c.repo.SetPasswordReset(ctx, user.Id, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	// Failing here would reveal the account exists.
+	err = c.auth.mailer.Send(ctx, user.Email, templateResetPassword, map[string]any{
+		"Link":      strings.ReplaceAll(c.auth.cfg.ResetURL, PlaceholderToken, url.QueryEscape(token)),
+		"ExpiresAt": expiresAt,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot mail password reset", zap.Int("user_id", user.Id), zap.Error(err))
+	}
+
+	return nil
+}
+
+// ResetPassword sets the password of the user the reset token was mailed
+// to, ends all their sessions and lets them know.
+func (c *Controller) ResetPassword(ctx context.Context, token, password string) error {
+	ctx, span := tracer.Start(ctx, "Controller.ResetPassword")
+	defer span.End()
+
+	if c.auth == nil {
+		return ErrBadToken
+	}
+
+	if err := c.auth.validatePassword(password); err != nil {
+		return err
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, err := c.repo.ResetPassword(ctx, hashToken(token), passwordHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Already used, superseded by a later request or expired.
+		return ErrBadToken
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The password is changed; a failed notice must not undo it.
+	err = c.auth.mailer.Send(ctx, user.Email, templatePasswordChanged, map[string]any{"Email": user.Email})
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot notify password change", zap.Int("user_id", user.Id), zap.Error(err))
+	}
+
+	return nil
+}
+
+// Login starts a session for the user owning email when password is theirs.
+// Users with MFA get a challenge instead, which CompleteLogin turns into a
+// session given their second factor.
+func (c *Controller) Login(ctx context.Context, email, password string) (*Session, *MFAChallenge, error) {
+	ctx, span := tracer.Start(ctx, "Controller.Login")
+	defer span.End()
+
+	if c.auth == nil || len(password) > MaxPasswordSize {
+		return nil, nil, ErrBadCredentials
+	}
+
+	user, err := c.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, err
+	}
+
+	passwordHash := ""
+	if user != nil {
+		passwordHash, err = c.repo.GetPasswordHash(ctx, user.Id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	if passwordHash == "" {
+		_, _ = checkPassword(password, dummyPasswordHash())
+		return nil, nil, ErrBadCredentials
+	}
+
+	ok, err := checkPassword(password, passwordHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !ok {
+		return nil, nil, ErrBadCredentials
+	}
+
+	mfa, err := c.repo.GetTOTP(ctx, user.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mfa.Secret != "" {
+		challenge, err := c.newMFAChallenge(ctx, user.Id)
+		return nil, challenge, err
+	}
+
+	session, err := c.startSession(ctx, user.Id)
+	return session, nil, err
+}
+
+func (c *Controller) startSession(ctx context.Context, userId int) (*Session, error) {
+	session, stored, err := c.auth.newSession(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.repo.CreateSession(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// RefreshSession replaces the session refreshToken belongs to with a new
+// one; the old tokens stop working.
+func (c *Controller) RefreshSession(ctx context.Context, refreshToken string) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "Controller.RefreshSession")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil, ErrBadSession
+	}
+
+	session, stored, err := c.auth.newSession(0)
+	if err != nil {
+		return nil, err
+	}
+
+	session.UserId, err = c.repo.RefreshSession(ctx, hashToken(refreshToken), stored)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadSession
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Authenticate returns the user whose session accessToken belongs to.
+func (c *Controller) Authenticate(ctx context.Context, accessToken string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.Authenticate")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil, ErrBadSession
+	}
+
+	user, err := c.repo.GetSessionUser(ctx, hashToken(accessToken))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadSession
+	}
+
+	return user, err
+}
+
+// Logout ends the session accessToken belongs to.
+func (c *Controller) Logout(ctx context.Context, accessToken string) error {
+	ctx, span := tracer.Start(ctx, "Controller.Logout")
+	defer span.End()
+
+	if c.auth == nil {
+		return ErrBadSession
+	}
+
+	err := c.repo.DeleteSession(ctx, hashToken(accessToken))
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrBadSession
+	}
+
+	return err
+}
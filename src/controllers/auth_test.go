@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+func (r *stubRepository) SetPasswordReset(_ context.Context, id int, tokenHash []byte, _ time.Time) error {
+	if id != r.user.Id {
+		return repository.ErrNotFound
+	}
+
+	r.resetHash = tokenHash
+	return nil
+}
+
+func (r *stubRepository) ResetPassword(_ context.Context, tokenHash []byte, passwordHash string) (*models.User, error) {
+	if r.resetHash == nil || !bytes.Equal(tokenHash, r.resetHash) {
+		return nil, repository.ErrNotFound
+	}
+
+	r.passwordHash, r.resetHash, r.sessions = passwordHash, nil, nil
+	user := r.user
+	return &user, nil
+}
+
+func (r *stubRepository) GetPasswordHash(_ context.Context, id int) (string, error) {
+	if id != r.user.Id {
+		return "", repository.ErrNotFound
+	}
+
+	return r.passwordHash, nil
+}
+
+func (r *stubRepository) CreateSession(_ context.Context, session *repository.Session) error {
+	r.sessions = append(r.sessions, *session)
+	return nil
+}
+
+func (r *stubRepository) RefreshSession(_ context.Context, refreshHash []byte, next *repository.Session) (int, error) {
+	i := slices.IndexFunc(r.sessions, func(s repository.Session) bool { return bytes.Equal(s.RefreshHash, refreshHash) })
+	if i < 0 {
+		return 0, repository.ErrNotFound
+	}
+
+	next.UserId = r.sessions[i].UserId
+	r.sessions[i] = *next
+	return next.UserId, nil
+}
+
+func (r *stubRepository) GetSessionUser(_ context.Context, tokenHash []byte) (*models.User, error) {
+	if !slices.ContainsFunc(r.sessions, func(s repository.Session) bool { return bytes.Equal(s.TokenHash, tokenHash) }) {
+		return nil, repository.ErrNotFound
+	}
+
+	user := r.user
+	return &user, nil
+}
+
+func (r *stubRepository) DeleteSession(_ context.Context, tokenHash []byte) error {
+	n := len(r.sessions)
+	r.sessions = slices.DeleteFunc(r.sessions, func(s repository.Session) bool { return bytes.Equal(s.TokenHash, tokenHash) })
+	if len(r.sessions) == n {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("correct horse")
+	require.NoError(t, err)
+
+	ok, err := checkPassword("correct horse", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = checkPassword("wrong horse", hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = checkPassword("correct horse", "$2a$10$bcrypt")
+	require.ErrorIs(t, err, errBadPasswordHash)
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	cfg := AuthConfig{
+		ResetTokenTTL:     time.Hour,
+		ResetURL:          "https://app.example.com/reset?token={token}",
+		SessionTTL:        time.Hour,
+		RefreshTTL:        24 * time.Hour,
+		MinPasswordLength: 10,
+		MFAIssuer:         "Example",
+		MFAChallengeTTL:   time.Minute,
+	}
+	require.NoError(t, cfg.Validate())
+
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	mails := &recordingMailer{}
+	repo := &stubRepository{user: models.User{Id: 7, Email: "Jane@Example.com", NormalizedEmail: "jane@example.com"}}
+	controller := New(repo, Options{Auth: NewAuth(cfg, mailer.NewSender(templates, mails))})
+
+	resetPassword := func(password string) error {
+		require.NoError(t, controller.ForgotPassword(ctx, "jane@example.com"))
+		return controller.ResetPassword(ctx, mailedToken(t, mails.sent[len(mails.sent)-1]), password)
+	}
+
+	t.Run("UnknownEmail", func(t *testing.T) {
+		require.NoError(t, controller.ForgotPassword(ctx, "john@example.com"))
+		require.NoError(t, controller.ForgotPassword(ctx, "not an email"))
+		require.Empty(t, mails.sent)
+	})
+
+	t.Run("NoPasswordYet", func(t *testing.T) {
+		_, _, err := controller.Login(ctx, "jane@example.com", "")
+		require.ErrorIs(t, err, ErrBadCredentials)
+	})
+
+	t.Run("ShortPassword", func(t *testing.T) {
+		require.ErrorIs(t, resetPassword("short"), ErrBadPassword)
+		require.Empty(t, repo.passwordHash)
+	})
+
+	var session *Session
+	t.Run("Reset", func(t *testing.T) {
+		require.NoError(t, controller.ForgotPassword(ctx, "JANE@example.com"))
+		require.Equal(t, "Jane@Example.com", mails.sent[len(mails.sent)-1].To)
+		require.Equal(t, "Reset your password", mails.sent[len(mails.sent)-1].Subject)
+
+		token := mailedToken(t, mails.sent[len(mails.sent)-1])
+		require.NoError(t, controller.ResetPassword(ctx, token, "correct horse"))
+		require.Equal(t, "Your password was changed", mails.sent[len(mails.sent)-1].Subject)
+
+		// Tokens work once.
+		require.ErrorIs(t, controller.ResetPassword(ctx, token, "correct horse"), ErrBadToken)
+		require.ErrorIs(t, controller.ResetPassword(ctx, "forged", "correct horse"), ErrBadToken)
+
+		session, _, err = controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+		require.Equal(t, 7, session.UserId)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		_, _, err := controller.Login(ctx, "jane@example.com", "wrong horse")
+		require.ErrorIs(t, err, ErrBadCredentials)
+
+		_, _, err = controller.Login(ctx, "john@example.com", "correct horse")
+		require.ErrorIs(t, err, ErrBadCredentials)
+
+		user, err := controller.Authenticate(ctx, session.AccessToken)
+		require.NoError(t, err)
+		require.Equal(t, 7, user.Id)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		next, err := controller.RefreshSession(ctx, session.RefreshToken)
+		require.NoError(t, err)
+		require.Equal(t, 7, next.UserId)
+
+		_, err = controller.Authenticate(ctx, session.AccessToken)
+		require.ErrorIs(t, err, ErrBadSession)
+
+		_, err = controller.RefreshSession(ctx, session.RefreshToken)
+		require.ErrorIs(t, err, ErrBadSession)
+
+		session = next
+	})
+
+	t.Run("ResetEndsSessions", func(t *testing.T) {
+		other, _, err := controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+
+		require.NoError(t, resetPassword("battery staple"))
+
+		for _, s := range []*Session{session, other} {
+			_, err = controller.Authenticate(ctx, s.AccessToken)
+			require.ErrorIs(t, err, ErrBadSession)
+		}
+
+		_, _, err = controller.Login(ctx, "jane@example.com", "correct horse")
+		require.ErrorIs(t, err, ErrBadCredentials)
+
+		session, _, err = controller.Login(ctx, "jane@example.com", "battery staple")
+		require.NoError(t, err)
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		require.NoError(t, controller.Logout(ctx, session.AccessToken))
+		require.ErrorIs(t, controller.Logout(ctx, session.AccessToken), ErrBadSession)
+	})
+}
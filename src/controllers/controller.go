@@ -3,66 +3,467 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
 
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/logging"
 	"go-user-service/src/repository"
 	"go-user-service/src/repository/models"
 )
 
 var (
-	ErrBadName  = errors.New("bad email")
-	ErrBadEmail = errors.New("bad name")
+	ErrBadName      = errors.New("bad name")
+	ErrBadEmail     = errors.New("bad email")
+	ErrBadBatchSize = fmt.Errorf("batch must contain between 1 and %d items", MaxBatchSize)
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 1000
+	MaxBatchSize    = 1000
 )
 
+var tracer = otel.Tracer("go-user-service/src/controllers")
+
 type Controller struct {
-	repo repository.Repository
+	repo    repository.Repository
+	emails  *emailaddr.Policy
+	changes *EmailChanges
+	auth    *Auth
+	events  *broker
+}
+
+// Options holds the optional dependencies of a Controller. The zero value
+// checks the syntax of emails only, applies email changes at once and lets
+// no one log in.
+type Options struct {
+	// Emails is the policy emails are validated with.
+	Emails *emailaddr.Policy
+	// EmailChanges makes email changes wait for confirmation.
+	EmailChanges *EmailChanges
+	// Auth handles passwords, sessions and MFA.
+	Auth *Auth
 }
 
-func New(repo repository.Repository) *Controller {
-	return &Controller{repo: repo}
+func New(repo repository.Repository, opts Options) *Controller {
+	emails := opts.Emails
+	if emails == nil {
+		emails = &emailaddr.Policy{}
+	}
+
+	return &Controller{
+		repo:    repo,
+		emails:  emails,
+		changes: opts.EmailChanges,
+		auth:    opts.Auth,
+		events:  newBroker(),
+	}
 }
 
 func (c *Controller) Create(ctx context.Context, user *models.User) (int, error) {
-	err := validateUser(user)
+	ctx, span := tracer.Start(ctx, "Controller.Create")
+	defer span.End()
+
+	err := c.Validate(user)
 	if err != nil {
 		return 0, err
 	}
 
-	return c.repo.Create(ctx, user)
+	id, err := c.repo.Create(ctx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	c.publish(ctx, Event{Type: EventCreated, User: models.User{Id: id, Email: user.Email, Name: user.Name}})
+
+	return id, nil
 }
 
 func (c *Controller) Get(ctx context.Context, id int) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.Get")
+	defer span.End()
+
 	return c.repo.Get(ctx, id)
 }
 
+// GetByEmail finds the user owning the mailbox of address, so it matches
+// regardless of case, or of dots and +tags for canonical Gmail addresses.
+func (c *Controller) GetByEmail(ctx context.Context, address string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.GetByEmail")
+	defer span.End()
+
+	normalized, err := c.emails.Normalize(address)
+	if err != nil {
+		// No user can have an invalid email.
+		return nil, repository.ErrNotFound
+	}
+
+	return c.repo.GetByEmail(ctx, normalized)
+}
+
+func (c *Controller) GetMany(ctx context.Context, ids []int) ([]*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.GetMany")
+	defer span.End()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return c.repo.GetMany(ctx, ids)
+}
+
+// List returns a page of users with id greater than afterId. A non-positive
+// limit selects DefaultPageSize; limits above MaxPageSize are capped.
+func (c *Controller) List(ctx context.Context, afterId int, limit int) ([]*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.List")
+	defer span.End()
+
+	return c.repo.List(ctx, afterId, PageSize(limit))
+}
+
+// ListOffset returns a page of users skipping the first offset, for clients
+// that can only paginate by position.
+func (c *Controller) ListOffset(ctx context.Context, offset int, limit int) ([]*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.ListOffset")
+	defer span.End()
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	return c.repo.ListOffset(ctx, offset, PageSize(limit))
+}
+
+func (c *Controller) Count(ctx context.Context) (int, error) {
+	ctx, span := tracer.Start(ctx, "Controller.Count")
+	defer span.End()
+
+	return c.repo.Count(ctx)
+}
+
+// PageSize returns the number of users List fetches for the requested limit.
+func PageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+
+	return limit
+}
+
+// Update replaces the user's email and name. When email changes are
+// verified a new email only becomes pending, see UpdateEmail, and
+// user.PendingEmail is set.
 func (c *Controller) Update(ctx context.Context, user *models.User) error {
-	err := validateUser(user)
+	ctx, span := tracer.Start(ctx, "Controller.Update")
+	defer span.End()
+
+	return c.update(ctx, user, c.changes != nil)
+}
+
+// UpdateProvisioned is Update for provisioning clients such as SCIM
+// identity providers, which own the user's email: a new email applies at
+// once, without confirmation, and replaces any pending change.
+func (c *Controller) UpdateProvisioned(ctx context.Context, user *models.User) error {
+	ctx, span := tracer.Start(ctx, "Controller.UpdateProvisioned")
+	defer span.End()
+
+	return c.update(ctx, user, false)
+}
+
+func (c *Controller) update(ctx context.Context, user *models.User, verify bool) error {
+	err := c.Validate(user)
+	if err != nil {
+		return err
+	}
+
+	var newEmail *emailaddr.Address
+	if verify {
+		current, err := c.repo.Get(ctx, user.Id)
+		if err != nil {
+			return err
+		}
+
+		if current.NormalizedEmail != user.NormalizedEmail {
+			// Checked before anything is written, so a taken email fails
+			// the whole update.
+			if err := c.checkEmailFree(ctx, user.NormalizedEmail); err != nil {
+				return err
+			}
+
+			newEmail = &emailaddr.Address{Email: user.Email, Normalized: user.NormalizedEmail}
+			user.Email, user.NormalizedEmail = current.Email, current.NormalizedEmail
+		}
+	}
+
+	err = c.repo.Update(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	return c.repo.Update(ctx, user)
+	c.publish(ctx, Event{Type: EventUpdated, User: *user})
+
+	if newEmail != nil {
+		change, err := c.requestEmailChange(ctx, user.Id, *newEmail)
+		if err != nil {
+			return err
+		}
+
+		user.PendingEmail = change.Email
+	}
+
+	return nil
+}
+
+// UpdateEmail changes the user's email. When email changes are verified the
+// new address only becomes pending and gets a confirmation link, and the
+// pending change is returned; see ConfirmEmail. Otherwise, or when the new
+// address is the same mailbox, the email changes at once and the returned
+// change is nil.
+func (c *Controller) UpdateEmail(ctx context.Context, id int, address string) (*EmailChange, error) {
+	ctx, span := tracer.Start(ctx, "Controller.UpdateEmail")
+	defer span.End()
+
+	parsed, err := c.emails.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadEmail, err)
+	}
+
+	if c.changes != nil {
+		current, err := c.repo.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if current.NormalizedEmail != parsed.Normalized {
+			return c.changeEmail(ctx, id, parsed)
+		}
+	}
+
+	err = c.repo.UpdateEmail(ctx, id, parsed.Email, parsed.Normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	if user, err := c.repo.Get(ctx, id); err == nil {
+		c.publish(ctx, Event{Type: EventUpdated, User: *user})
+	}
+
+	return nil, nil
 }
 
-func (c *Controller) UpdateEmail(ctx context.Context, id int, email string) error {
-	if email == "" {
-		return ErrBadEmail
+// changeEmail starts a verified change to address, failing early when
+// another user has it; confirming checks again.
+func (c *Controller) changeEmail(ctx context.Context, id int, address emailaddr.Address) (*EmailChange, error) {
+	if err := c.checkEmailFree(ctx, address.Normalized); err != nil {
+		return nil, err
 	}
 
-	return c.repo.UpdateEmail(ctx, id, email)
+	return c.requestEmailChange(ctx, id, address)
+}
+
+// checkEmailFree returns ErrAlreadyExists when a user has normalizedEmail.
+func (c *Controller) checkEmailFree(ctx context.Context, normalizedEmail string) error {
+	_, err := c.repo.GetByEmail(ctx, normalizedEmail)
+	if err == nil {
+		return repository.ErrAlreadyExists
+	}
+
+	if !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	return nil
 }
 
 func (c *Controller) Delete(ctx context.Context, id int) error {
-	return c.repo.Delete(ctx, id)
+	ctx, span := tracer.Start(ctx, "Controller.Delete")
+	defer span.End()
+
+	err := c.repo.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	c.publish(ctx, Event{Type: EventDeleted, User: models.User{Id: id}})
+
+	return nil
 }
 
-func validateUser(user *models.User) error {
-	if user.Email == "" {
-		return ErrBadEmail
+// BatchGet returns the users for ids in the same order, with nil for ids
+// that do not exist.
+func (c *Controller) BatchGet(ctx context.Context, ids []int) ([]*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.BatchGet")
+	defer span.End()
+
+	if err := checkBatchSize(len(ids)); err != nil {
+		return nil, err
+	}
+
+	users, err := c.repo.GetMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[int]*models.User, len(users))
+	for _, user := range users {
+		byId[user.Id] = user
+	}
+
+	result := make([]*models.User, len(ids))
+	for i, id := range ids {
+		result[i] = byId[id]
+	}
+
+	return result, nil
+}
+
+// BatchCreate validates and creates users, returning one result per user.
+// In atomic mode nothing is stored unless every user is created.
+func (c *Controller) BatchCreate(ctx context.Context, users []*models.User, atomic bool) ([]repository.BatchResult, error) {
+	ctx, span := tracer.Start(ctx, "Controller.BatchCreate")
+	defer span.End()
+
+	if err := checkBatchSize(len(users)); err != nil {
+		return nil, err
+	}
+
+	results := make([]repository.BatchResult, len(users))
+	valid := make([]*models.User, 0, len(users))
+	positions := make([]int, 0, len(users))
+	for i, user := range users {
+		if err := c.Validate(user); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		valid = append(valid, user)
+		positions = append(positions, i)
+	}
+
+	if len(valid) < len(users) && atomic {
+		for _, i := range positions {
+			results[i].Err = repository.ErrBatchAborted
+		}
+
+		return results, nil
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	created, err := c.repo.CreateMany(ctx, valid, atomic)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range created {
+		results[positions[j]] = result
+		if result.Err == nil {
+			user := valid[j]
+			c.publish(ctx, Event{Type: EventCreated, User: models.User{Id: result.Id, Email: user.Email, Name: user.Name}})
+		}
+	}
+
+	return results, nil
+}
+
+// BatchDelete deletes users by id, returning one result per id. In atomic
+// mode nothing is deleted unless every id exists.
+func (c *Controller) BatchDelete(ctx context.Context, ids []int, atomic bool) ([]repository.BatchResult, error) {
+	ctx, span := tracer.Start(ctx, "Controller.BatchDelete")
+	defer span.End()
+
+	if err := checkBatchSize(len(ids)); err != nil {
+		return nil, err
+	}
+
+	results, err := c.repo.DeleteMany(ctx, ids, atomic)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Err == nil {
+			c.publish(ctx, Event{Type: EventDeleted, User: models.User{Id: result.Id}})
+		}
+	}
+
+	return results, nil
+}
+
+// publish notifies watchers of a change and records it in the request log.
+func (c *Controller) publish(ctx context.Context, event Event) {
+	logging.FromContext(ctx).Debug("user changed",
+		zap.Stringer("event", event.Type),
+		zap.Int("user_id", event.User.Id),
+	)
+
+	c.events.publish(event)
+}
+
+func checkBatchSize(n int) error {
+	if n == 0 || n > MaxBatchSize {
+		return ErrBadBatchSize
+	}
+
+	return nil
+}
+
+// Watch streams user changes made through this controller until ctx is done.
+// Events are not persisted, so changes made by other replicas are not seen.
+func (c *Controller) Watch(ctx context.Context) <-chan Event {
+	return c.events.subscribe(ctx)
+}
+
+// Close ends every Watch stream, so that long lived subscribers do not hold
+// up a graceful shutdown. Changes made afterwards are no longer published.
+func (c *Controller) Close() {
+	c.events.close()
+}
+
+// Import stores a batch of users that already passed Validate. Imported
+// users are not published to watchers.
+func (c *Controller) Import(ctx context.Context, records []repository.ImportRecord, opts repository.ImportOptions) ([]repository.ImportOutcome, error) {
+	ctx, span := tracer.Start(ctx, "Controller.Import")
+	defer span.End()
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return c.repo.Import(ctx, records, opts)
+}
+
+// Export streams the users matching filter to fn, ordered by id.
+func (c *Controller) Export(ctx context.Context, filter repository.ExportFilter, fn func(*models.User) error) error {
+	ctx, span := tracer.Start(ctx, "Controller.Export")
+	defer span.End()
+
+	return c.repo.Export(ctx, filter, fn)
+}
+
+// Validate applies the rules Create and Update enforce. It trims the email
+// and sets NormalizedEmail, which the repository keeps unique.
+func (c *Controller) Validate(user *models.User) error {
+	parsed, err := c.emails.Parse(user.Email)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadEmail, err)
 	}
 
 	if user.Name == "" {
 		return ErrBadName
 	}
 
+	user.Email = parsed.Email
+	user.NormalizedEmail = parsed.Normalized
+
 	return nil
 }
@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/logging"
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	DefaultEmailTokenTTL  = 24 * time.Hour
+	DefaultEmailRevertTTL = 7 * 24 * time.Hour
+
+	// URL placeholders in EmailChangeConfig.ConfirmURL and RevertURL.
+	PlaceholderId    = "{id}"
+	PlaceholderToken = "{token}"
+
+	purposeConfirmEmail = "confirm_email"
+	purposeRevertEmail  = "revert_email"
+
+	// Mail templates, see the mailer package.
+	templateConfirmEmail = "confirm_email"
+	templateEmailChanged = "email_changed"
+
+	secretSize = 32
+)
+
+var ErrBadToken = errors.New("invalid or expired token")
+
+type EmailChangeConfig struct {
+	// Verify makes email changes wait until the new address is confirmed;
+	// when off they apply at once.
+	Verify bool `mapstructure:"verify" yaml:"verify"`
+	// Secret signs the tokens. When empty a random one is used, so tokens
+	// die with the process and only work on the replica that issued them.
+	Secret string `mapstructure:"secret" yaml:"secret"`
+	// TokenTTL is how long the new address has to confirm.
+	TokenTTL time.Duration `mapstructure:"token_ttl" yaml:"token_ttl"`
+	// RevertTTL is how long the old address can undo a change.
+	RevertTTL time.Duration `mapstructure:"revert_ttl" yaml:"revert_ttl"`
+	// ConfirmURL and RevertURL are the links mailed to users, with {id} and
+	// {token} replaced. They should lead to a page that POSTs the token, as
+	// mail scanners follow links.
+	ConfirmURL string `mapstructure:"confirm_url" yaml:"confirm_url"`
+	RevertURL  string `mapstructure:"revert_url" yaml:"revert_url"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c EmailChangeConfig) Validate() error {
+	var errs []error
+	if c.Secret != "" && len(c.Secret) < secretSize {
+		errs = append(errs, fmt.Errorf("secret: must be at least %d bytes", secretSize))
+	}
+
+	if c.TokenTTL <= 0 {
+		errs = append(errs, fmt.Errorf("token_ttl: must be positive, got %s", c.TokenTTL))
+	}
+
+	if c.RevertTTL <= 0 {
+		errs = append(errs, fmt.Errorf("revert_ttl: must be positive, got %s", c.RevertTTL))
+	}
+
+	links := []struct {
+		key, value string
+	}{
+		{"confirm_url", c.ConfirmURL},
+		{"revert_url", c.RevertURL},
+	}
+	for _, link := range links {
+		if _, err := url.Parse(link.value); err != nil || !strings.Contains(link.value, PlaceholderToken) {
+			errs = append(errs, fmt.Errorf("%s: must be a URL containing %s, got %q", link.key, PlaceholderToken, link.value))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// EmailChange is an email change waiting for the new address to confirm it.
+type EmailChange struct {
+	Email     string
+	ExpiresAt time.Time
+}
+
+// EmailChanges issues and checks the signed tokens that confirm and revert
+// email changes, and mails them.
+type EmailChanges struct {
+	cfg    EmailChangeConfig
+	key    []byte
+	mailer *mailer.Sender
+	now    func() time.Time
+}
+
+func NewEmailChanges(cfg EmailChangeConfig, m *mailer.Sender) (*EmailChanges, error) {
+	key := []byte(cfg.Secret)
+	if len(key) == 0 {
+		key = make([]byte, secretSize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &EmailChanges{cfg: cfg, key: key, mailer: m, now: time.Now}, nil
+}
+
+// emailClaims is the signed content of a token. Tokens are not encrypted;
+// they only go to the addresses they name.
+type emailClaims struct {
+	Purpose   string `json:"p"`
+	UserId    int    `json:"u"`
+	ExpiresAt int64  `json:"x"`
+	// Email is the normalized new address.
+	Email string `json:"e"`
+	// From and FromNormalized are the address a revert restores.
+	From           string `json:"f,omitempty"`
+	FromNormalized string `json:"fn,omitempty"`
+	// IssuedAt orders revert tokens, see RevertEmail.
+	IssuedAt int64 `json:"i,omitempty"`
+}
+
+func (e *EmailChanges) sign(claims emailClaims) string {
+	payload, _ := json.Marshal(claims)
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the token's signature, expiry, purpose and user.
+func (e *EmailChanges) verify(token, purpose string, id int) (emailClaims, error) {
+	encodedPayload, encodedSum, ok := strings.Cut(token, ".")
+	if !ok {
+		return emailClaims{}, ErrBadToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return emailClaims{}, ErrBadToken
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(encodedSum)
+	if err != nil {
+		return emailClaims{}, ErrBadToken
+	}
+
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return emailClaims{}, ErrBadToken
+	}
+
+	var claims emailClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return emailClaims{}, ErrBadToken
+	}
+
+	if claims.Purpose != purpose || claims.UserId != id || e.now().Unix() >= claims.ExpiresAt {
+		return emailClaims{}, ErrBadToken
+	}
+
+	return claims, nil
+}
+
+func (e *EmailChanges) link(template string, id int, token string) string {
+	return strings.NewReplacer(PlaceholderId, strconv.Itoa(id), PlaceholderToken, url.QueryEscape(token)).Replace(template)
+}
+
+// requestEmailChange records the new address as pending and mails it a
+// confirmation link.
+func (c *Controller) requestEmailChange(ctx context.Context, id int, address emailaddr.Address) (*EmailChange, error) {
+	change := &EmailChange{Email: address.Email, ExpiresAt: c.changes.now().Add(c.changes.cfg.TokenTTL).Truncate(time.Second)}
+	err := c.repo.RequestEmailChange(ctx, id, address.Email, address.Normalized, change.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token := c.changes.sign(emailClaims{
+		Purpose:   purposeConfirmEmail,
+		UserId:    id,
+		ExpiresAt: change.ExpiresAt.Unix(),
+		Email:     address.Normalized,
+	})
+
+	err = c.changes.mailer.Send(ctx, address.Email, templateConfirmEmail, map[string]any{
+		"Link":      c.changes.link(c.changes.cfg.ConfirmURL, id, token),
+		"ExpiresAt": change.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+// ConfirmEmail completes the email change the token was issued for and
+// mails the old address a link to revert it.
+func (c *Controller) ConfirmEmail(ctx context.Context, id int, token string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.ConfirmEmail")
+	defer span.End()
+
+	if c.changes == nil {
+		return nil, ErrBadToken
+	}
+
+	claims, err := c.changes.verify(token, purposeConfirmEmail, id)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := c.repo.ConfirmEmailChange(ctx, id, claims.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Already confirmed, superseded by a later request or expired.
+		return nil, ErrBadToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.publish(ctx, Event{Type: EventUpdated, User: *user})
+
+	now := c.changes.now()
+	revertToken := c.changes.sign(emailClaims{
+		Purpose:        purposeRevertEmail,
+		UserId:         id,
+		ExpiresAt:      now.Add(c.changes.cfg.RevertTTL).Unix(),
+		Email:          claims.Email,
+		From:           previous.Email,
+		FromNormalized: previous.NormalizedEmail,
+		IssuedAt:       now.Unix(),
+	})
+
+	// The change is done; a failed notice must not undo it.
+	err = c.changes.mailer.Send(ctx, previous.Email, templateEmailChanged, map[string]any{
+		"NewEmail":   user.Email,
+		"RevertLink": c.changes.link(c.changes.cfg.RevertURL, id, revertToken),
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot notify old email address", zap.Int("user_id", id), zap.Error(err))
+	}
+
+	return user, nil
+}
+
+// RevertEmail restores the address an email change replaced, even when the
+// email changed again since, and signs the user out everywhere. Using a
+// revert token voids it and every revert token issued after it, so the
+// addresses that followed cannot take the account back, while tokens mailed
+// to earlier addresses keep working.
+func (c *Controller) RevertEmail(ctx context.Context, id int, token string) (*models.User, error) {
+	ctx, span := tracer.Start(ctx, "Controller.RevertEmail")
+	defer span.End()
+
+	if c.changes == nil {
+		return nil, ErrBadToken
+	}
+
+	claims, err := c.changes.verify(token, purposeRevertEmail, id)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := &models.User{Email: claims.From, NormalizedEmail: claims.FromNormalized}
+	err = c.repo.RevertEmailChange(ctx, id, previous, time.Unix(claims.IssuedAt, 0))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := c.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.publish(ctx, Event{Type: EventUpdated, User: *user})
+
+	return user, nil
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// stubRepository holds a single user in memory. It ignores expiry times.
+type stubRepository struct {
+	repository.Repository
+
+	user    models.User
+	pending models.User
+	// reverts are the applied email reverts; now dates them.
+	reverts []stubRevert
+	now     func() time.Time
+	// changedAt is when an email change was last confirmed.
+	changedAt time.Time
+	// taken are the normalized emails of other users.
+	taken []string
+
+	passwordHash string
+	resetHash    []byte
+	sessions     []repository.Session
+
+	totp          repository.TOTP
+	recoveryCodes [][]byte
+	challenges    map[string]*stubChallenge
+}
+
+func (r *stubRepository) Get(_ context.Context, id int) (*models.User, error) {
+	if id != r.user.Id {
+		return nil, repository.ErrNotFound
+	}
+
+	user := r.user
+	user.PendingEmail = r.pending.Email
+	return &user, nil
+}
+
+func (r *stubRepository) GetByEmail(_ context.Context, normalizedEmail string) (*models.User, error) {
+	if slices.Contains(r.taken, normalizedEmail) {
+		return &models.User{Id: r.user.Id + 1, NormalizedEmail: normalizedEmail}, nil
+	}
+
+	if normalizedEmail != r.user.NormalizedEmail {
+		return nil, repository.ErrNotFound
+	}
+
+	user := r.user
+	return &user, nil
+}
+
+func (r *stubRepository) Update(_ context.Context, user *models.User) error {
+	if user.Id != r.user.Id {
+		return repository.ErrNotFound
+	}
+
+	if user.NormalizedEmail != r.user.NormalizedEmail {
+		r.pending = models.User{}
+	}
+
+	r.user.Email, r.user.NormalizedEmail, r.user.Name = user.Email, user.NormalizedEmail, user.Name
+	return nil
+}
+
+func (r *stubRepository) UpdateEmail(_ context.Context, _ int, email, normalizedEmail string) error {
+	r.user.Email, r.user.NormalizedEmail = email, normalizedEmail
+	return nil
+}
+
+func (r *stubRepository) RequestEmailChange(_ context.Context, _ int, email, normalizedEmail string, _ time.Time) error {
+	r.pending = models.User{Email: email, NormalizedEmail: normalizedEmail}
+	return nil
+}
+
+func (r *stubRepository) ConfirmEmailChange(_ context.Context, _ int, normalizedEmail string) (*models.User, error) {
+	if r.pending.NormalizedEmail != normalizedEmail {
+		return nil, repository.ErrNotFound
+	}
+
+	previous := r.user
+	r.user.Email, r.user.NormalizedEmail = r.pending.Email, r.pending.NormalizedEmail
+	r.pending = models.User{}
+	r.changedAt = r.now()
+	return &previous, nil
+}
+
+func (r *stubRepository) EmailChangedAt(context.Context, int) (time.Time, error) {
+	return r.changedAt, nil
+}
+
+type stubRevert struct {
+	issuedAt, revertedAt time.Time
+}
+
+func (r *stubRepository) RevertEmailChange(_ context.Context, _ int, previous *models.User, issuedAt time.Time) error {
+	if r.user.NormalizedEmail == previous.NormalizedEmail {
+		return repository.ErrNotFound
+	}
+
+	for _, revert := range r.reverts {
+		if !issuedAt.Before(revert.issuedAt) && !issuedAt.After(revert.revertedAt) {
+			return repository.ErrNotFound
+		}
+	}
+
+	r.reverts = append(r.reverts, stubRevert{issuedAt: issuedAt, revertedAt: r.now()})
+	r.user.Email, r.user.NormalizedEmail = previous.Email, previous.NormalizedEmail
+	r.pending = models.User{}
+	r.changedAt = time.Time{}
+	r.sessions, r.resetHash, r.challenges = nil, nil, nil
+	return nil
+}
+
+type recordingMailer struct {
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+func mailedToken(t *testing.T, msg mailer.Message) string {
+	match := tokenPattern.FindStringSubmatch(msg.Text)
+	require.NotNil(t, match, msg.Text)
+
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err, err)
+
+	return token
+}
+
+func TestEmailChange(t *testing.T) {
+	ctx := context.Background()
+	cfg := EmailChangeConfig{
+		Verify:     true,
+		TokenTTL:   time.Hour,
+		RevertTTL:  24 * time.Hour,
+		ConfirmURL: "https://app.example.com/confirm?user={id}&token={token}",
+		RevertURL:  "https://app.example.com/revert?user={id}&token={token}",
+	}
+	require.NoError(t, cfg.Validate())
+
+	now := time.Unix(1_700_000_000, 0)
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	mails := &recordingMailer{}
+	changes, err := NewEmailChanges(cfg, mailer.NewSender(templates, mails))
+	require.NoError(t, err, err)
+	changes.now = func() time.Time { return now }
+
+	repo := &stubRepository{user: models.User{Id: 1, Email: "old@example.com", NormalizedEmail: "old@example.com", Name: "A"}}
+	repo.now = func() time.Time { return now }
+	controller := New(repo, Options{EmailChanges: changes})
+
+	change, err := controller.UpdateEmail(ctx, 1, "New@Example.com")
+	require.NoError(t, err, err)
+	require.Equal(t, &EmailChange{Email: "New@Example.com", ExpiresAt: now.Add(time.Hour)}, change)
+	require.Equal(t, "old@example.com", repo.user.Email, "email changed before confirmation")
+
+	require.Len(t, mails.sent, 1)
+	require.Equal(t, "New@Example.com", mails.sent[0].To)
+	confirmToken := mailedToken(t, mails.sent[0])
+
+	// A token only works for its own user and purpose, and not once expired.
+	_, err = controller.ConfirmEmail(ctx, 2, confirmToken)
+	require.ErrorIs(t, err, ErrBadToken)
+	_, err = controller.RevertEmail(ctx, 1, confirmToken)
+	require.ErrorIs(t, err, ErrBadToken)
+	_, err = controller.ConfirmEmail(ctx, 1, confirmToken[:len(confirmToken)-2]+"xx")
+	require.ErrorIs(t, err, ErrBadToken)
+
+	now = now.Add(2 * time.Hour)
+	_, err = controller.ConfirmEmail(ctx, 1, confirmToken)
+	require.ErrorIs(t, err, ErrBadToken)
+	now = now.Add(-2 * time.Hour)
+
+	user, err := controller.ConfirmEmail(ctx, 1, confirmToken)
+	require.NoError(t, err, err)
+	require.Equal(t, "New@Example.com", user.Email)
+	require.Equal(t, "new@example.com", repo.user.NormalizedEmail)
+
+	// Tokens are single use.
+	_, err = controller.ConfirmEmail(ctx, 1, confirmToken)
+	require.ErrorIs(t, err, ErrBadToken)
+
+	require.Len(t, mails.sent, 2)
+	require.Equal(t, "old@example.com", mails.sent[1].To)
+	revertToken := mailedToken(t, mails.sent[1])
+
+	// A second change does not take the revert from the original address.
+	now = now.Add(time.Minute)
+	_, err = controller.UpdateEmail(ctx, 1, "third@example.com")
+	require.NoError(t, err, err)
+	_, err = controller.ConfirmEmail(ctx, 1, mailedToken(t, mails.sent[2]))
+	require.NoError(t, err, err)
+	require.Equal(t, "New@Example.com", mails.sent[3].To)
+	laterRevertToken := mailedToken(t, mails.sent[3])
+
+	repo.sessions = []repository.Session{{UserId: 1}}
+	repo.resetHash = []byte("reset")
+
+	now = now.Add(time.Minute)
+	user, err = controller.RevertEmail(ctx, 1, revertToken)
+	require.NoError(t, err, err)
+	require.Equal(t, "old@example.com", user.Email)
+	require.Empty(t, repo.sessions)
+	require.Nil(t, repo.resetHash)
+
+	// The revert voids itself and the links of the addresses that followed.
+	_, err = controller.RevertEmail(ctx, 1, revertToken)
+	require.ErrorIs(t, err, ErrBadToken)
+	_, err = controller.RevertEmail(ctx, 1, laterRevertToken)
+	require.ErrorIs(t, err, ErrBadToken)
+
+	// The same mailbox in other letters needs no confirmation.
+	change, err = controller.UpdateEmail(ctx, 1, "OLD@example.com")
+	require.NoError(t, err, err)
+	require.Nil(t, change)
+	require.Equal(t, "OLD@example.com", repo.user.Email)
+	require.Len(t, mails.sent, 4)
+
+	// Updating to another user's email changes nothing.
+	repo.taken = []string{"taken@example.com"}
+	err = controller.Update(ctx, &models.User{Id: 1, Email: "taken@example.com", Name: "B"})
+	require.ErrorIs(t, err, repository.ErrAlreadyExists)
+	require.Equal(t, "A", repo.user.Name)
+	require.Empty(t, repo.pending.Email)
+
+	user = &models.User{Id: 1, Email: "free@example.com", Name: "B"}
+	require.NoError(t, controller.Update(ctx, user))
+	require.Equal(t, "B", repo.user.Name)
+	require.Equal(t, "OLD@example.com", repo.user.Email)
+	require.Equal(t, "free@example.com", user.PendingEmail)
+	require.Len(t, mails.sent, 5)
+}
+
+func TestForgotPasswordAfterEmailChange(t *testing.T) {
+	ctx := context.Background()
+	changesCfg := EmailChangeConfig{
+		Verify:     true,
+		TokenTTL:   time.Hour,
+		RevertTTL:  24 * time.Hour,
+		ConfirmURL: "https://app.example.com/confirm?user={id}&token={token}",
+		RevertURL:  "https://app.example.com/revert?user={id}&token={token}",
+	}
+	authCfg := AuthConfig{
+		ResetTokenTTL: time.Hour,
+		ResetURL:      "https://app.example.com/reset?token={token}",
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	mails := &recordingMailer{}
+	sender := mailer.NewSender(templates, mails)
+	changes, err := NewEmailChanges(changesCfg, sender)
+	require.NoError(t, err, err)
+	changes.now = func() time.Time { return now }
+	auth := NewAuth(authCfg, sender)
+	auth.now = func() time.Time { return now }
+
+	repo := &stubRepository{user: models.User{Id: 1, Email: "old@example.com", NormalizedEmail: "old@example.com"}}
+	repo.now = func() time.Time { return now }
+	controller := New(repo, Options{EmailChanges: changes, Auth: auth})
+
+	_, err = controller.UpdateEmail(ctx, 1, "new@example.com")
+	require.NoError(t, err, err)
+	_, err = controller.ConfirmEmail(ctx, 1, mailedToken(t, mails.sent[0]))
+	require.NoError(t, err, err)
+	require.Len(t, mails.sent, 2)
+
+	// The new address gets no reset link while the old one can revert.
+	require.NoError(t, controller.ForgotPassword(ctx, "new@example.com"))
+	require.Len(t, mails.sent, 2)
+	require.Nil(t, repo.resetHash)
+
+	now = now.Add(changesCfg.RevertTTL)
+	require.NoError(t, controller.ForgotPassword(ctx, "new@example.com"))
+	require.Len(t, mails.sent, 3)
+	require.Equal(t, "new@example.com", mails.sent[2].To)
+}
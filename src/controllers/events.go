@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"go-user-service/src/repository/models"
+)
+
+const eventBufferSize = 64
+
+type EventType int
+
+const (
+	EventCreated EventType = iota + 1
+	EventUpdated
+	EventDeleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventUpdated:
+		return "updated"
+	case EventDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type EventType
+	User models.User
+}
+
+// broker fans out user change events to in-process subscribers.
+type broker struct {
+	mu     sync.Mutex
+	subs   map[chan Event]context.CancelFunc
+	closed bool
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan Event]context.CancelFunc)}
+}
+
+func (b *broker) subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	b.subs[ch] = cancel
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		delete(b.subs, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// close ends every subscription and refuses new ones.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, cancel := range b.subs {
+		cancel()
+	}
+}
+
+func (b *broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		// Slow subscribers miss events rather than blocking writers.
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
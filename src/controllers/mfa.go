@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"go.uber.org/zap"
+
+	"go-user-service/src/logging"
+	"go-user-service/src/repository"
+	"go-user-service/src/totp"
+)
+
+const (
+	DefaultMFAIssuer       = "User service"
+	DefaultMFAChallengeTTL = 5 * time.Minute
+
+	// RecoveryCodeCount is how many recovery codes enabling MFA hands out.
+	RecoveryCodeCount = 10
+	// MaxMFAAttempts is how many codes one login challenge may be answered
+	// with before the password has to be entered again.
+	MaxMFAAttempts = 5
+
+	templateMFAEnabled  = "mfa_enabled"
+	templateMFADisabled = "mfa_disabled"
+
+	recoveryCodeSize = 10
+	qrCodeSize       = 256
+)
+
+var (
+	ErrNoAuth       = errors.New("authentication is not configured")
+	ErrBadMFACode   = errors.New("invalid MFA code")
+	ErrBadChallenge = errors.New("invalid or expired MFA challenge or code")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is what an authenticator app needs: the secret, the
+// otpauth URI carrying it and that URI as a QR code PNG.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+	QRCode []byte
+}
+
+// MFAChallenge is a login waiting for its second factor until ExpiresAt.
+type MFAChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// newRecoveryCodes returns RecoveryCodeCount codes formatted for reading
+// them out, and the hashes they are stored by.
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([][]byte, RecoveryCodeCount)
+
+	raw := make([]byte, recoveryCodeSize)
+	for i := range codes {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, so codes typed either
+// way match.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// EnrollTOTP starts MFA enrollment with a new secret. It takes effect once
+// ConfirmTOTP is given a code generated from it; until then an enabled
+// secret keeps working. When MFA is enabled, replacing the secret takes code,
+// a current TOTP code or a recovery code, which is used up.
+func (c *Controller) EnrollTOTP(ctx context.Context, id int, code string) (*TOTPEnrollment, error) {
+	ctx, span := tracer.Start(ctx, "Controller.EnrollTOTP")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil, ErrNoAuth
+	}
+
+	user, err := c.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkMFACode(ctx, id, code); err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.repo.SetPendingTOTP(ctx, id, secret); err != nil {
+		return nil, err
+	}
+
+	uri := totp.URI(c.auth.cfg.MFAIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: uri, QRCode: png}, nil
+}
+
+// ConfirmTOTP enables MFA when code matches the pending secret, ends the
+// user's sessions and returns their recovery codes. The codes are only
+// stored hashed, so this is the one time they can be shown.
+func (c *Controller) ConfirmTOTP(ctx context.Context, id int, code string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "Controller.ConfirmTOTP")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil, ErrNoAuth
+	}
+
+	mfa, err := c.repo.GetTOTP(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.PendingSecret == "" {
+		return nil, ErrBadMFACode
+	}
+
+	step, ok, err := totp.Validate(mfa.PendingSecret, code, c.auth.now())
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrBadMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.repo.EnableTOTP(ctx, id, mfa.PendingSecret, step, hashes)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Enrolled again since the secret was read.
+		return nil, ErrBadMFACode
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.notifyMFA(ctx, id, templateMFAEnabled)
+
+	return codes, nil
+}
+
+// DisableTOTP turns MFA off and drops the recovery codes. When MFA is
+// enabled it takes code, as EnrollTOTP does.
+func (c *Controller) DisableTOTP(ctx context.Context, id int, code string) error {
+	ctx, span := tracer.Start(ctx, "Controller.DisableTOTP")
+	defer span.End()
+
+	if c.auth == nil {
+		return ErrNoAuth
+	}
+
+	mfa, err := c.repo.GetTOTP(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if mfa.Secret != "" {
+		if err := c.useMFACode(ctx, id, mfa.Secret, code); err != nil {
+			return err
+		}
+	}
+
+	if err := c.repo.DisableTOTP(ctx, id); err != nil {
+		return err
+	}
+
+	if mfa.Secret != "" {
+		c.notifyMFA(ctx, id, templateMFADisabled)
+	}
+
+	return nil
+}
+
+// checkMFACode uses up code when the user has MFA enabled, and accepts any
+// code otherwise.
+func (c *Controller) checkMFACode(ctx context.Context, id int, code string) error {
+	mfa, err := c.repo.GetTOTP(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if mfa.Secret == "" {
+		return nil
+	}
+
+	return c.useMFACode(ctx, id, mfa.Secret, code)
+}
+
+// useMFACode accepts a current TOTP code, each only once, or an unused
+// recovery code, which it uses up. Anything else is ErrBadMFACode.
+func (c *Controller) useMFACode(ctx context.Context, id int, secret, code string) error {
+	if code == "" {
+		return ErrBadMFACode
+	}
+
+	step, ok, err := totp.Validate(secret, code, c.auth.now())
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		err = c.repo.UseTOTPStep(ctx, id, step)
+	} else {
+		err = c.repo.UseRecoveryCode(ctx, id, hashRecoveryCode(code))
+	}
+
+	if errors.Is(err, repository.ErrNotFound) {
+		// A replayed TOTP code, or no recovery code matched.
+		return ErrBadMFACode
+	}
+
+	return err
+}
+
+// notifyMFA mails the user about an MFA change that has already been made,
+// so failing only gets logged.
+func (c *Controller) notifyMFA(ctx context.Context, id int, template string) {
+	if c.auth == nil {
+		return
+	}
+
+	user, err := c.repo.Get(ctx, id)
+	if err == nil {
+		err = c.auth.mailer.Send(ctx, user.Email, template, map[string]any{"Email": user.Email})
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot notify MFA change", zap.Int("user_id", id), zap.Error(err))
+	}
+}
+
+func (c *Controller) newMFAChallenge(ctx context.Context, userId int) (*MFAChallenge, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := c.auth.now().Add(c.auth.cfg.MFAChallengeTTL)
+	if err := c.repo.CreateMFAChallenge(ctx, tokenHash, userId, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &MFAChallenge{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// CompleteLogin starts a session for the login challengeToken belongs to
+// when code is a current TOTP code or an unused recovery code. Each code
+// works once, and a challenge takes at most MaxMFAAttempts codes.
+func (c *Controller) CompleteLogin(ctx context.Context, challengeToken, code string) (*Session, error) {
+	ctx, span := tracer.Start(ctx, "Controller.CompleteLogin")
+	defer span.End()
+
+	if c.auth == nil {
+		return nil, ErrBadChallenge
+	}
+
+	challengeHash := hashToken(challengeToken)
+	userId, err := c.repo.AttemptMFAChallenge(ctx, challengeHash, MaxMFAAttempts)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadChallenge
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := c.repo.GetTOTP(ctx, userId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadChallenge
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.Secret == "" {
+		// Disabled since the password was checked.
+		return nil, ErrBadChallenge
+	}
+
+	err = c.useMFACode(ctx, userId, mfa.Secret, code)
+	if errors.Is(err, ErrBadMFACode) {
+		return nil, ErrBadChallenge
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleting tells concurrent completions apart: only one gets a session.
+	err = c.repo.DeleteMFAChallenge(ctx, challengeHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrBadChallenge
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.startSession(ctx, userId)
+}
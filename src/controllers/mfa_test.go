@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+	"go-user-service/src/totp"
+)
+
+type stubChallenge struct {
+	userId   int
+	attempts int
+}
+
+func (r *stubRepository) GetTOTP(_ context.Context, id int) (*repository.TOTP, error) {
+	if id != r.user.Id {
+		return nil, repository.ErrNotFound
+	}
+
+	mfa := r.totp
+	return &mfa, nil
+}
+
+func (r *stubRepository) SetPendingTOTP(_ context.Context, id int, secret string) error {
+	if id != r.user.Id {
+		return repository.ErrNotFound
+	}
+
+	r.totp.PendingSecret = secret
+	return nil
+}
+
+func (r *stubRepository) EnableTOTP(_ context.Context, id int, pendingSecret string, step int64, recoveryCodeHashes [][]byte) error {
+	if id != r.user.Id || pendingSecret != r.totp.PendingSecret {
+		return repository.ErrNotFound
+	}
+
+	r.totp = repository.TOTP{Secret: pendingSecret, LastStep: step}
+	r.recoveryCodes, r.sessions = recoveryCodeHashes, nil
+	return nil
+}
+
+func (r *stubRepository) DisableTOTP(_ context.Context, id int) error {
+	if id != r.user.Id {
+		return repository.ErrNotFound
+	}
+
+	r.totp.Secret, r.totp.PendingSecret, r.recoveryCodes = "", "", nil
+	return nil
+}
+
+func (r *stubRepository) UseTOTPStep(_ context.Context, id int, step int64) error {
+	if id != r.user.Id || step <= r.totp.LastStep {
+		return repository.ErrNotFound
+	}
+
+	r.totp.LastStep = step
+	return nil
+}
+
+func (r *stubRepository) UseRecoveryCode(_ context.Context, id int, codeHash []byte) error {
+	n := len(r.recoveryCodes)
+	r.recoveryCodes = slices.DeleteFunc(r.recoveryCodes, func(h []byte) bool { return bytes.Equal(h, codeHash) })
+	if id != r.user.Id || len(r.recoveryCodes) == n {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *stubRepository) CreateMFAChallenge(_ context.Context, tokenHash []byte, id int, _ time.Time) error {
+	if r.challenges == nil {
+		r.challenges = map[string]*stubChallenge{}
+	}
+
+	r.challenges[string(tokenHash)] = &stubChallenge{userId: id}
+	return nil
+}
+
+func (r *stubRepository) AttemptMFAChallenge(_ context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	challenge, ok := r.challenges[string(tokenHash)]
+	if !ok || challenge.attempts >= maxAttempts {
+		return 0, repository.ErrNotFound
+	}
+
+	challenge.attempts++
+	return challenge.userId, nil
+}
+
+func (r *stubRepository) DeleteMFAChallenge(_ context.Context, tokenHash []byte) error {
+	if _, ok := r.challenges[string(tokenHash)]; !ok {
+		return repository.ErrNotFound
+	}
+
+	delete(r.challenges, string(tokenHash))
+	return nil
+}
+
+func TestTOTPLogin(t *testing.T) {
+	ctx := context.Background()
+	cfg := AuthConfig{
+		ResetTokenTTL:     time.Hour,
+		ResetURL:          "https://app.example.com/reset?token={token}",
+		SessionTTL:        time.Hour,
+		RefreshTTL:        24 * time.Hour,
+		MinPasswordLength: 10,
+		MFAIssuer:         "Example",
+		MFAChallengeTTL:   time.Minute,
+	}
+	require.NoError(t, cfg.Validate())
+
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err)
+
+	passwordHash, err := hashPassword("correct horse")
+	require.NoError(t, err)
+
+	now := time.Unix(1_700_000_000, 0)
+	mails := &recordingMailer{}
+	auth := NewAuth(cfg, mailer.NewSender(templates, mails))
+	auth.now = func() time.Time { return now }
+
+	repo := &stubRepository{
+		user:         models.User{Id: 7, Email: "jane@example.com", NormalizedEmail: "jane@example.com"},
+		passwordHash: passwordHash,
+	}
+	controller := New(repo, Options{Auth: auth})
+
+	code := func(t *testing.T, secret string) string {
+		c, err := totp.Code(secret, totp.Step(now))
+		require.NoError(t, err)
+		return c
+	}
+
+	login := func(t *testing.T) *MFAChallenge {
+		session, challenge, err := controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+		require.Nil(t, session)
+		require.NotNil(t, challenge)
+		return challenge
+	}
+
+	var secret string
+	var recoveryCodes []string
+	t.Run("Enroll", func(t *testing.T) {
+		session, _, err := controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+
+		enrollment, err := controller.EnrollTOTP(ctx, 7, "")
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Example:jane@example.com?"), enrollment.URI)
+		require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		require.Equal(t, []byte("\x89PNG"), enrollment.QRCode[:4])
+		secret = enrollment.Secret
+
+		_, err = controller.EnrollTOTP(ctx, 8, "")
+		require.ErrorIs(t, err, repository.ErrNotFound)
+
+		// Not enabled until confirmed.
+		_, challenge, err := controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+		require.Nil(t, challenge)
+
+		_, err = controller.ConfirmTOTP(ctx, 7, "000000")
+		require.ErrorIs(t, err, ErrBadMFACode)
+
+		recoveryCodes, err = controller.ConfirmTOTP(ctx, 7, code(t, secret))
+		require.NoError(t, err)
+		require.Len(t, recoveryCodes, RecoveryCodeCount)
+		require.Regexp(t, `^[a-z2-7]{4}(-[a-z2-7]{4}){3}$`, recoveryCodes[0])
+		require.Equal(t, "Two-factor authentication was turned on", mails.sent[len(mails.sent)-1].Subject)
+
+		_, err = controller.Authenticate(ctx, session.AccessToken)
+		require.ErrorIs(t, err, ErrBadSession)
+
+		_, err = controller.ConfirmTOTP(ctx, 7, code(t, secret))
+		require.ErrorIs(t, err, ErrBadMFACode)
+	})
+
+	t.Run("Code", func(t *testing.T) {
+		// The confirming code cannot be replayed.
+		_, err := controller.CompleteLogin(ctx, login(t).Token, code(t, secret))
+		require.ErrorIs(t, err, ErrBadChallenge)
+
+		now = now.Add(totp.Period)
+		challenge := login(t)
+		session, err := controller.CompleteLogin(ctx, challenge.Token, code(t, secret))
+		require.NoError(t, err)
+		require.Equal(t, 7, session.UserId)
+
+		_, err = controller.CompleteLogin(ctx, challenge.Token, code(t, secret))
+		require.ErrorIs(t, err, ErrBadChallenge)
+	})
+
+	t.Run("RecoveryCode", func(t *testing.T) {
+		session, err := controller.CompleteLogin(ctx, login(t).Token, " "+strings.ToUpper(recoveryCodes[0]))
+		require.NoError(t, err)
+		require.Equal(t, 7, session.UserId)
+
+		_, err = controller.CompleteLogin(ctx, login(t).Token, recoveryCodes[0])
+		require.ErrorIs(t, err, ErrBadChallenge)
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		challenge := login(t)
+		for range MaxMFAAttempts {
+			_, err := controller.CompleteLogin(ctx, challenge.Token, "000000")
+			require.ErrorIs(t, err, ErrBadChallenge)
+		}
+
+		now = now.Add(totp.Period)
+		_, err := controller.CompleteLogin(ctx, challenge.Token, code(t, secret))
+		require.ErrorIs(t, err, ErrBadChallenge)
+	})
+
+	t.Run("Reenroll", func(t *testing.T) {
+		// With MFA enabled a new secret takes a code.
+		_, err := controller.EnrollTOTP(ctx, 7, "")
+		require.ErrorIs(t, err, ErrBadMFACode)
+		_, err = controller.EnrollTOTP(ctx, 7, "000000")
+		require.ErrorIs(t, err, ErrBadMFACode)
+
+		_, err = controller.EnrollTOTP(ctx, 7, recoveryCodes[1])
+		require.NoError(t, err)
+		_, err = controller.EnrollTOTP(ctx, 7, recoveryCodes[1])
+		require.ErrorIs(t, err, ErrBadMFACode)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		challenge := login(t)
+
+		require.ErrorIs(t, controller.DisableTOTP(ctx, 7, ""), ErrBadMFACode)
+		require.ErrorIs(t, controller.DisableTOTP(ctx, 7, recoveryCodes[0]), ErrBadMFACode)
+		require.ErrorIs(t, New(repo, Options{}).DisableTOTP(ctx, 7, code(t, secret)), ErrNoAuth)
+
+		// The enabled secret still works while a new one is pending.
+		require.NoError(t, controller.DisableTOTP(ctx, 7, code(t, secret)))
+		require.Equal(t, "Two-factor authentication was turned off", mails.sent[len(mails.sent)-1].Subject)
+
+		now = now.Add(totp.Period)
+		_, err := controller.CompleteLogin(ctx, challenge.Token, code(t, secret))
+		require.ErrorIs(t, err, ErrBadChallenge)
+
+		session, challenge, err := controller.Login(ctx, "jane@example.com", "correct horse")
+		require.NoError(t, err)
+		require.Nil(t, challenge)
+		require.NotNil(t, session)
+	})
+}
@@ -0,0 +1,220 @@
+package emailaddr
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxLength      = 254
+	maxLocalLength = 64
+	maxLabelLength = 63
+)
+
+var (
+	ErrSyntax  = errors.New("not an RFC 5322 address")
+	ErrDomain  = errors.New("invalid domain")
+	ErrBlocked = errors.New("domain not allowed")
+)
+
+// gmailDomains deliver to the same mailbox regardless of dots and +tags in
+// the local part.
+var gmailDomains = map[string]bool{"gmail.com": true, "googlemail.com": true}
+
+type Config struct {
+	// CanonicalGmail treats Gmail addresses differing only in dots or +tags,
+	// like j.doe+news@gmail.com and jdoe@googlemail.com, as the same.
+	CanonicalGmail bool `mapstructure:"canonical_gmail" yaml:"canonical_gmail"`
+	// BlockedDomains rejects addresses at these domains and their
+	// subdomains, e.g. disposable email providers.
+	BlockedDomains []string `mapstructure:"blocked_domains" yaml:"blocked_domains"`
+	// BlocklistFile adds the domains listed in a file, one per line; blank
+	// lines and lines starting with # are skipped.
+	BlocklistFile string `mapstructure:"blocklist_file" yaml:"blocklist_file"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	for _, domain := range c.BlockedDomains {
+		if _, err := normalizeDomain(domain); err != nil {
+			errs = append(errs, fmt.Errorf("blocked_domains: %w, got %q", err, domain))
+		}
+	}
+
+	if c.BlocklistFile != "" {
+		if _, err := os.Stat(c.BlocklistFile); err != nil {
+			errs = append(errs, fmt.Errorf("blocklist_file: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Address is a validated email address.
+type Address struct {
+	// Email is the address as given, without surrounding whitespace.
+	Email string
+	// Normalized identifies the mailbox: the local part lowercased, the
+	// domain in lowercase punycode and, with CanonicalGmail, Gmail addresses
+	// in their canonical form. Two addresses with the same Normalized belong
+	// to the same person.
+	Normalized string
+}
+
+// Policy validates and normalizes addresses. The zero value checks syntax
+// only.
+type Policy struct {
+	canonicalGmail bool
+	blocked        map[string]bool
+}
+
+// NewPolicy builds a policy, reading cfg.BlocklistFile if set.
+func NewPolicy(cfg Config) (*Policy, error) {
+	p := &Policy{canonicalGmail: cfg.CanonicalGmail, blocked: map[string]bool{}}
+
+	domains := cfg.BlockedDomains
+	if cfg.BlocklistFile != "" {
+		fileDomains, err := readBlocklist(cfg.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+
+		domains = append(domains, fileDomains...)
+	}
+
+	for _, domain := range domains {
+		normalized, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, fmt.Errorf("blocked domain %q: %w", domain, err)
+		}
+
+		p.blocked[normalized] = true
+	}
+
+	return p, nil
+}
+
+// Parse validates raw as a bare addr-spec, e.g. jane@example.com; display
+// names, comments and domain literals are rejected.
+func (p *Policy) Parse(raw string) (Address, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > maxLength {
+		return Address{}, ErrSyntax
+	}
+
+	parsed, err := mail.ParseAddress(raw)
+	if err != nil || parsed.Name != "" || strings.HasPrefix(raw, "<") || strings.HasSuffix(raw, ")") {
+		return Address{}, ErrSyntax
+	}
+
+	// String quotes the local part only where needed, so "jane"@example.com
+	// and jane@example.com normalize alike.
+	canonical := strings.TrimSuffix(strings.TrimPrefix(parsed.String(), "<"), ">")
+	at := strings.LastIndexByte(canonical, '@')
+	local, domain := canonical[:at], canonical[at+1:]
+	if len(local) > maxLocalLength {
+		return Address{}, ErrSyntax
+	}
+
+	asciiDomain, err := normalizeDomain(domain)
+	if err != nil {
+		return Address{}, err
+	}
+
+	if p.isBlocked(asciiDomain) {
+		return Address{}, ErrBlocked
+	}
+
+	normalizedLocal := strings.ToLower(local)
+	if p.canonicalGmail && gmailDomains[asciiDomain] {
+		normalizedLocal, _, _ = strings.Cut(normalizedLocal, "+")
+		normalizedLocal = strings.ReplaceAll(normalizedLocal, ".", "")
+		asciiDomain = "gmail.com"
+	}
+
+	return Address{Email: raw, Normalized: normalizedLocal + "@" + asciiDomain}, nil
+}
+
+// Normalize returns the normalized form of raw, see Address.Normalized.
+func (p *Policy) Normalize(raw string) (string, error) {
+	address, err := p.Parse(raw)
+	return address.Normalized, err
+}
+
+func (p *Policy) isBlocked(domain string) bool {
+	for domain != "" {
+		if p.blocked[domain] {
+			return true
+		}
+
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+
+		domain = parent
+	}
+
+	return false
+}
+
+// normalizeDomain converts an internationalized domain to lowercase
+// punycode and checks it is a plausible internet host name.
+func normalizeDomain(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDomain, err)
+	}
+
+	ascii = strings.ToLower(ascii)
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", ErrDomain
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", ErrDomain
+		}
+
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", ErrDomain
+			}
+		}
+	}
+
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", ErrDomain
+	}
+
+	return ascii, nil
+}
+
+func readBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		domains = append(domains, line)
+	}
+
+	return domains, scanner.Err()
+}
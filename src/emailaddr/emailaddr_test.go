@@ -0,0 +1,90 @@
+package emailaddr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	policy := &Policy{}
+
+	for raw, normalized := range map[string]string{
+		"jane@example.com":           "jane@example.com",
+		"  Jane.Doe@Example.COM ":    "jane.doe@example.com",
+		"jane+news@example.com":      "jane+news@example.com",
+		`"jane doe"@example.com`:     `"jane doe"@example.com`,
+		"jane@bücher.example":        "jane@xn--bcher-kva.example",
+		"jane@xn--bcher-kva.example": "jane@xn--bcher-kva.example",
+		`"jane"@example.com`:         "jane@example.com",
+	} {
+		got, err := policy.Normalize(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, normalized, got, raw)
+	}
+
+	for _, raw := range []string{
+		"",
+		"jane",
+		"jane@",
+		"@example.com",
+		"jane@@example.com",
+		"jane.@example.com",
+		"Jane <jane@example.com>",
+		"<jane@example.com>",
+		"jane@example.com (Jane)",
+		"jane@[192.0.2.1]",
+		"jane@localhost",
+		"jane@example..com",
+		"jane@-example.com",
+		"jane@example.123",
+		"jane@exa_mple.com",
+		string(make([]byte, 65)) + "@example.com",
+	} {
+		_, err := policy.Parse(raw)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestCanonicalGmail(t *testing.T) {
+	policy, err := NewPolicy(Config{CanonicalGmail: true})
+	require.NoError(t, err, err)
+
+	for _, raw := range []string{"jane.doe@gmail.com", "J.a.n.e.Doe+news@Gmail.com", "janedoe@googlemail.com"} {
+		address, err := policy.Parse(raw)
+		require.NoError(t, err, raw)
+		require.Equal(t, "janedoe@gmail.com", address.Normalized, raw)
+		require.Equal(t, raw, address.Email)
+	}
+
+	// Dots and tags matter elsewhere.
+	normalized, err := policy.Normalize("jane.doe+news@example.com")
+	require.NoError(t, err, err)
+	require.Equal(t, "jane.doe+news@example.com", normalized)
+}
+
+func TestBlocklist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(file, []byte("# disposable\n\nTrashMail.example\n"), 0o600))
+
+	cfg := Config{BlockedDomains: []string{"mailinator.example"}, BlocklistFile: file}
+	require.NoError(t, cfg.Validate())
+
+	policy, err := NewPolicy(cfg)
+	require.NoError(t, err, err)
+
+	for _, raw := range []string{"jane@mailinator.example", "jane@eu.mailinator.example", "jane@trashmail.example"} {
+		_, err := policy.Parse(raw)
+		require.ErrorIs(t, err, ErrBlocked, raw)
+	}
+
+	_, err = policy.Parse("jane@notmailinator.example")
+	require.NoError(t, err, err)
+
+	cfg = Config{BlockedDomains: []string{"not a domain"}, BlocklistFile: filepath.Join(t.TempDir(), "missing")}
+	err = cfg.Validate()
+	require.Contains(t, err.Error(), "blocked_domains: ")
+	require.Contains(t, err.Error(), "blocklist_file: ")
+}
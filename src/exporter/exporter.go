@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/parquet-go/parquet-go"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatNDJSON  Format = "ndjson"
+	FormatParquet Format = "parquet"
+)
+
+const (
+	// flushEvery bounds how many rows are buffered before they reach the writer.
+	flushEvery = 1000
+
+	parquetRowGroupSize = 64 * 1024
+)
+
+var ErrBadFormat = errors.New("format must be csv, ndjson or parquet")
+
+var csvHeader = []string{"id", "email", "name"}
+
+type Options struct {
+	Format Format
+	Filter repository.ExportFilter
+}
+
+// ContentType returns the media type of the format, or "" when it is unknown.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return ""
+	}
+}
+
+// Run writes every user matching opts.Filter to w and returns how many were
+// written. Users are streamed from the repository, so memory use does not
+// grow with the table. When w has a Flush method it is called periodically.
+func Run(ctx context.Context, controller *controllers.Controller, w io.Writer, opts Options) (int, error) {
+	enc, err := newEncoder(w, opts.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	flusher, _ := w.(interface{ Flush() error })
+
+	total := 0
+	err = controller.Export(ctx, opts.Filter, func(user *models.User) error {
+		if err := enc.encode(user); err != nil {
+			return err
+		}
+
+		total++
+		if total%flushEvery == 0 {
+			if err := enc.flush(); err != nil {
+				return err
+			}
+
+			if flusher != nil {
+				return flusher.Flush()
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+
+	if err := enc.close(); err != nil {
+		return total, err
+	}
+
+	if flusher != nil {
+		return total, flusher.Flush()
+	}
+
+	return total, nil
+}
+
+type encoder interface {
+	encode(user *models.User) error
+	flush() error
+	close() error
+}
+
+func newEncoder(w io.Writer, format Format) (encoder, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+
+		return &csvEncoder{w: cw}, nil
+	case FormatNDJSON:
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}, nil
+	case FormatParquet:
+		return &parquetEncoder{
+			w: parquet.NewGenericWriter[parquetRow](w, parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		}, nil
+	default:
+		return nil, ErrBadFormat
+	}
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) encode(user *models.User) error {
+	return e.w.Write([]string{strconv.Itoa(user.Id), user.Email, user.Name})
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	return e.flush()
+}
+
+type ndjsonRow struct {
+	Id    int    `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) encode(user *models.User) error {
+	return e.enc.Encode(ndjsonRow{Id: user.Id, Email: user.Email, Name: user.Name})
+}
+
+func (e *ndjsonEncoder) flush() error { return nil }
+
+func (e *ndjsonEncoder) close() error { return nil }
+
+type parquetRow struct {
+	Id    int64  `parquet:"id"`
+	Email string `parquet:"email"`
+	Name  string `parquet:"name"`
+}
+
+// parquetEncoder buffers rows and hands them to the writer in batches; the
+// writer itself emits a row group every parquetRowGroupSize rows.
+type parquetEncoder struct {
+	w    *parquet.GenericWriter[parquetRow]
+	rows []parquetRow
+}
+
+func (e *parquetEncoder) encode(user *models.User) error {
+	e.rows = append(e.rows, parquetRow{Id: int64(user.Id), Email: user.Email, Name: user.Name})
+	return nil
+}
+
+func (e *parquetEncoder) flush() error {
+	if len(e.rows) == 0 {
+		return nil
+	}
+
+	_, err := e.w.Write(e.rows)
+	e.rows = e.rows[:0]
+	return err
+}
+
+func (e *parquetEncoder) close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+
+	return e.w.Close()
+}
@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// stubRepository exports a fixed set of users, ignoring the filter.
+type stubRepository struct {
+	repository.Repository
+
+	users []*models.User
+}
+
+func (r *stubRepository) Export(_ context.Context, _ repository.ExportFilter, fn func(*models.User) error) error {
+	for _, user := range r.users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	controller := controllers.New(&stubRepository{users: []*models.User{
+		{Id: 1, Email: "a@example.com", Name: "A"},
+		{Id: 2, Email: "b@example.com", Name: "Last, First"},
+	}}, controllers.Options{})
+
+	t.Run("CSV", func(t *testing.T) {
+		var out bytes.Buffer
+		total, err := Run(context.Background(), controller, &out, Options{Format: FormatCSV})
+		require.NoError(t, err, err)
+		require.Equal(t, 2, total)
+		require.Equal(t, "id,email,name\n1,a@example.com,A\n2,b@example.com,\"Last, First\"\n", out.String())
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := Run(context.Background(), controller, &out, Options{Format: FormatNDJSON})
+		require.NoError(t, err, err)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Equal(t, []string{
+			`{"id":1,"email":"a@example.com","name":"A"}`,
+			`{"id":2,"email":"b@example.com","name":"Last, First"}`,
+		}, lines)
+	})
+
+	t.Run("Parquet", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := Run(context.Background(), controller, &out, Options{Format: FormatParquet})
+		require.NoError(t, err, err)
+
+		rows, err := parquet.Read[parquetRow](bytes.NewReader(out.Bytes()), int64(out.Len()))
+		require.NoError(t, err, err)
+		require.Equal(t, []parquetRow{
+			{Id: 1, Email: "a@example.com", Name: "A"},
+			{Id: 2, Email: "b@example.com", Name: "Last, First"},
+		}, rows)
+	})
+
+	t.Run("BadFormat", func(t *testing.T) {
+		_, err := Run(context.Background(), controller, &bytes.Buffer{}, Options{Format: "xml"})
+		require.ErrorIs(t, err, ErrBadFormat)
+	})
+}
@@ -0,0 +1,57 @@
+package gql
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+
+	"go-user-service/src/controllers"
+)
+
+var ErrBadRequest = errors.New("bad graphql request")
+
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type Handler struct {
+	controller *controllers.Controller
+	schema     graphql.Schema
+}
+
+func New(controller *controllers.Controller) (*Handler, error) {
+	schema, err := newSchema(controller)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		controller: controller,
+		schema:     schema,
+	}, nil
+}
+
+func (h *Handler) Serve(c *fiber.Ctx) error {
+	req := Request{}
+	if err := c.BodyParser(&req); err != nil || req.Query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadRequest.Error()})
+	}
+
+	ctx := withLoader(c.UserContext(), newUserLoader(c.UserContext(), h.controller))
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+
+	return c.Status(fiber.StatusOK).JSON(result)
+}
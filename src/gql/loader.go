@@ -0,0 +1,95 @@
+package gql
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository/models"
+)
+
+type loaderKey struct{}
+
+// userLoader collects the ids requested while a level of the query is being
+// resolved and fetches them with a single GetMany once the first result is
+// needed.
+type userLoader struct {
+	ctx        context.Context
+	controller *controllers.Controller
+
+	mu      sync.Mutex
+	pending []int
+	users   map[int]*models.User
+	err     error
+}
+
+func newUserLoader(ctx context.Context, controller *controllers.Controller) *userLoader {
+	return &userLoader{
+		ctx:        ctx,
+		controller: controller,
+		users:      make(map[int]*models.User),
+	}
+}
+
+func withLoader(ctx context.Context, loader *userLoader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, loader)
+}
+
+func loaderFrom(ctx context.Context) *userLoader {
+	return ctx.Value(loaderKey{}).(*userLoader)
+}
+
+func (l *userLoader) load(id int) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.users[id]; !ok && !slices.Contains(l.pending, id) {
+		l.pending = append(l.pending, id)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			l.dispatch()
+		}
+
+		if l.err != nil {
+			return nil, l.err
+		}
+
+		user := l.users[id]
+		if user == nil {
+			return nil, nil
+		}
+
+		return user, nil
+	}
+}
+
+func (l *userLoader) prime(user *models.User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.users[user.Id] = user
+}
+
+func (l *userLoader) dispatch() {
+	ids := l.pending
+	l.pending = nil
+
+	users, err := l.controller.GetMany(l.ctx, ids)
+	if err != nil {
+		l.err = toError(err)
+		return
+	}
+
+	for _, id := range ids {
+		l.users[id] = nil
+	}
+
+	for _, user := range users {
+		l.users[user.Id] = user
+	}
+}
@@ -0,0 +1,321 @@
+package gql
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+const cursorPrefix = "user:"
+
+var (
+	ErrBadId     = errors.New("bad user id")
+	ErrBadCursor = errors.New("bad cursor")
+	ErrInternal  = errors.New("internal error")
+)
+
+type connection struct {
+	users    []*models.User
+	pageSize int
+}
+
+func newSchema(controller *controllers.Controller) (graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return strconv.Itoa(p.Source.(*models.User).Id), nil
+				},
+			},
+			"email": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.User).Email, nil
+				},
+			},
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*models.User).Name, nil
+				},
+			},
+			"pendingEmail": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if pending := p.Source.(*models.User).PendingEmail; pending != "" {
+						return pending, nil
+					}
+
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return encodeCursor(p.Source.(*models.User).Id), nil
+				},
+			},
+			"node": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					conn := p.Source.(*connection)
+					return len(conn.users) == conn.pageSize, nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					conn := p.Source.(*connection)
+					if len(conn.users) == 0 {
+						return nil, nil
+					}
+
+					return encodeCursor(conn.users[len(conn.users)-1].Id), nil
+				},
+			},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*connection).users, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseId(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+
+					return loaderFrom(p.Context).load(id), nil
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{Type: graphql.Int},
+					"after": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					first, _ := p.Args["first"].(int)
+
+					afterId := 0
+					if after, ok := p.Args["after"].(string); ok {
+						var err error
+						afterId, err = decodeCursor(after)
+						if err != nil {
+							return nil, err
+						}
+					}
+
+					users, err := controller.List(p.Context, afterId, first)
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					loader := loaderFrom(p.Context)
+					for _, user := range users {
+						loader.prime(user)
+					}
+
+					return &connection{users: users, pageSize: controllers.PageSize(first)}, nil
+				},
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"email": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"name":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user := &models.User{
+						Email: p.Args["email"].(string),
+						Name:  p.Args["name"].(string),
+					}
+
+					id, err := controller.Create(p.Context, user)
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					user.Id = id
+					return user, nil
+				},
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"email": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"name":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseId(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+
+					user := &models.User{
+						Id:    id,
+						Email: p.Args["email"].(string),
+						Name:  p.Args["name"].(string),
+					}
+
+					err = controller.Update(p.Context, user)
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					return user, nil
+				},
+			},
+			"updateUserEmail": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"email": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseId(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+
+					_, err = controller.UpdateEmail(p.Context, id, p.Args["email"].(string))
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					user, err := controller.Get(p.Context, id)
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					return user, nil
+				},
+			},
+			"deleteUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := parseId(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+
+					err = controller.Delete(p.Context, id)
+					if err != nil {
+						return nil, toError(err)
+					}
+
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+}
+
+func parseId(arg interface{}) (int, error) {
+	s, _ := arg.(string)
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrBadId
+	}
+
+	return id, nil
+}
+
+func encodeCursor(id int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s%d", cursorPrefix, id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrBadCursor
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(string(raw), cursorPrefix))
+	if err != nil || !strings.HasPrefix(string(raw), cursorPrefix) {
+		return 0, ErrBadCursor
+	}
+
+	return id, nil
+}
+
+func toError(err error) error {
+	switch {
+	case errors.Is(err, controllers.ErrBadEmail):
+		return controllers.ErrBadEmail
+	case errors.Is(err, controllers.ErrBadName):
+		return controllers.ErrBadName
+	case errors.Is(err, repository.ErrNotFound):
+		return repository.ErrNotFound
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return repository.ErrAlreadyExists
+	default:
+		return ErrInternal
+	}
+}
@@ -0,0 +1,122 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// countingRepository serves a fixed set of users and records the ids of
+// every GetMany call.
+type countingRepository struct {
+	repository.Repository
+
+	users   []*models.User
+	getMany [][]int
+	lists   int
+}
+
+func (r *countingRepository) GetMany(_ context.Context, ids []int) ([]*models.User, error) {
+	r.getMany = append(r.getMany, ids)
+
+	var users []*models.User
+	for _, user := range r.users {
+		for _, id := range ids {
+			if user.Id == id {
+				users = append(users, user)
+			}
+		}
+	}
+
+	return users, nil
+}
+
+func (r *countingRepository) List(_ context.Context, afterId int, limit int) ([]*models.User, error) {
+	r.lists++
+
+	var users []*models.User
+	for _, user := range r.users {
+		if user.Id > afterId && len(users) < limit {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func query(t *testing.T, repo *countingRepository, q string) map[string]any {
+	handler, err := New(controllers.New(repo, controllers.Options{}))
+	require.NoError(t, err, err)
+
+	app := fiber.New()
+	app.Post("/graphql", handler.Serve)
+
+	body, err := json.Marshal(Request{Query: q})
+	require.NoError(t, err, err)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/graphql", strings.NewReader(string(body)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	require.NoError(t, err, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var result struct {
+		Data   map[string]any `json:"data"`
+		Errors []any          `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Empty(t, result.Errors)
+
+	return result.Data
+}
+
+func TestLoader(t *testing.T) {
+	newRepo := func() *countingRepository {
+		return &countingRepository{users: []*models.User{
+			{Id: 1, Email: "a@example.com", Name: "A"},
+			{Id: 2, Email: "b@example.com", Name: "B"},
+			{Id: 3, Email: "c@example.com", Name: "C", PendingEmail: "new@example.com"},
+		}}
+	}
+
+	t.Run("Batched", func(t *testing.T) {
+		repo := newRepo()
+		data := query(t, repo, `{
+			a: user(id: "1") { name }
+			b: user(id: "3") { name pendingEmail }
+			c: user(id: "1") { email }
+			missing: user(id: "9") { name }
+		}`)
+
+		require.Len(t, repo.getMany, 1)
+		require.ElementsMatch(t, []int{1, 3, 9}, repo.getMany[0])
+		require.Equal(t, map[string]any{"name": "A"}, data["a"])
+		require.Equal(t, map[string]any{"name": "C", "pendingEmail": "new@example.com"}, data["b"])
+		require.Equal(t, map[string]any{"email": "a@example.com"}, data["c"])
+		require.Nil(t, data["missing"])
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		repo := newRepo()
+		data := query(t, repo, `{
+			users(first: 2) { edges { node { id name } } pageInfo { hasNextPage } }
+		}`)
+
+		// Listed users come with the list, so nothing is loaded by id.
+		require.Equal(t, 1, repo.lists)
+		require.Empty(t, repo.getMany)
+
+		users := data["users"].(map[string]any)
+		require.Len(t, users["edges"], 2)
+		require.Equal(t, map[string]any{"hasNextPage": true}, users["pageInfo"])
+	})
+}
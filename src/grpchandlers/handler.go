@@ -0,0 +1,174 @@
+package grpchandlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go-user-service/src/api/userpb"
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+var (
+	ErrBadPageToken = errors.New("bad page token")
+	ErrInternal     = errors.New("internal error")
+)
+
+var _ userpb.UserServiceServer = (*Handler)(nil)
+
+type Handler struct {
+	userpb.UnimplementedUserServiceServer
+
+	controller *controllers.Controller
+}
+
+func New(controller *controllers.Controller) *Handler {
+	return &Handler{controller: controller}
+}
+
+func (h *Handler) Create(ctx context.Context, req *userpb.CreateRequest) (*userpb.CreateResponse, error) {
+	id, err := h.controller.Create(ctx, &models.User{Email: req.GetEmail(), Name: req.GetName()})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.CreateResponse{Id: int64(id)}, nil
+}
+
+func (h *Handler) Get(ctx context.Context, req *userpb.GetRequest) (*userpb.GetResponse, error) {
+	user, err := h.controller.Get(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.GetResponse{User: toProto(user)}, nil
+}
+
+func (h *Handler) Update(ctx context.Context, req *userpb.UpdateRequest) (*userpb.UpdateResponse, error) {
+	user := &models.User{
+		Id:    int(req.GetId()),
+		Email: req.GetEmail(),
+		Name:  req.GetName(),
+	}
+
+	err := h.controller.Update(ctx, user)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.UpdateResponse{User: toProto(user)}, nil
+}
+
+func (h *Handler) UpdateEmail(ctx context.Context, req *userpb.UpdateEmailRequest) (*userpb.UpdateEmailResponse, error) {
+	_, err := h.controller.UpdateEmail(ctx, int(req.GetId()), req.GetEmail())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	user, err := h.controller.Get(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.UpdateEmailResponse{User: toProto(user)}, nil
+}
+
+func (h *Handler) Delete(ctx context.Context, req *userpb.DeleteRequest) (*userpb.DeleteResponse, error) {
+	err := h.controller.Delete(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.DeleteResponse{}, nil
+}
+
+func (h *Handler) List(ctx context.Context, req *userpb.ListRequest) (*userpb.ListResponse, error) {
+	afterId := 0
+	if req.GetPageToken() != "" {
+		var err error
+		afterId, err = strconv.Atoi(req.GetPageToken())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, ErrBadPageToken.Error())
+		}
+	}
+
+	limit := int(req.GetPageSize())
+	users, err := h.controller.List(ctx, afterId, limit)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &userpb.ListResponse{Users: make([]*userpb.User, 0, len(users))}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toProto(user))
+	}
+
+	if len(users) > 0 && len(users) == controllers.PageSize(limit) {
+		resp.NextPageToken = strconv.Itoa(users[len(users)-1].Id)
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) Watch(_ *userpb.WatchRequest, stream userpb.UserService_WatchServer) error {
+	ctx := stream.Context()
+	events := h.controller.Watch(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			err := stream.Send(&userpb.WatchResponse{
+				Type: toProtoEventType(event.Type),
+				User: toProto(&event.User),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toProto(user *models.User) *userpb.User {
+	return &userpb.User{
+		Id:    int64(user.Id),
+		Email: user.Email,
+		Name:  user.Name,
+	}
+}
+
+func toProtoEventType(t controllers.EventType) userpb.EventType {
+	switch t {
+	case controllers.EventCreated:
+		return userpb.EventType_EVENT_TYPE_CREATED
+	case controllers.EventUpdated:
+		return userpb.EventType_EVENT_TYPE_UPDATED
+	case controllers.EventDeleted:
+		return userpb.EventType_EVENT_TYPE_DELETED
+	default:
+		return userpb.EventType_EVENT_TYPE_UNSPECIFIED
+	}
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, controllers.ErrBadEmail), errors.Is(err, controllers.ErrBadName):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Error(codes.NotFound, repository.ErrNotFound.Error())
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return status.Error(codes.AlreadyExists, repository.ErrAlreadyExists.Error())
+	default:
+		return status.Error(codes.Internal, ErrInternal.Error())
+	}
+}
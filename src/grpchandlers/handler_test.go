@@ -0,0 +1,243 @@
+package grpchandlers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-user-service/src/api/userpb"
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// stubRepository keeps users in memory, keyed by id.
+type stubRepository struct {
+	repository.Repository
+
+	users  map[int]models.User
+	nextId int
+}
+
+func (r *stubRepository) Create(_ context.Context, user *models.User) (int, error) {
+	for _, u := range r.users {
+		if u.NormalizedEmail == user.NormalizedEmail {
+			return 0, repository.ErrAlreadyExists
+		}
+	}
+
+	r.nextId++
+	stored := *user
+	stored.Id = r.nextId
+	r.users[stored.Id] = stored
+	return stored.Id, nil
+}
+
+func (r *stubRepository) Get(_ context.Context, id int) (*models.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	return &user, nil
+}
+
+func (r *stubRepository) Update(_ context.Context, user *models.User) error {
+	if _, ok := r.users[user.Id]; !ok {
+		return repository.ErrNotFound
+	}
+
+	r.users[user.Id] = *user
+	return nil
+}
+
+func (r *stubRepository) UpdateEmail(_ context.Context, id int, email, normalizedEmail string) error {
+	user, ok := r.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	user.Email, user.NormalizedEmail = email, normalizedEmail
+	r.users[id] = user
+	return nil
+}
+
+func (r *stubRepository) Delete(_ context.Context, id int) error {
+	if _, ok := r.users[id]; !ok {
+		return repository.ErrNotFound
+	}
+
+	delete(r.users, id)
+	return nil
+}
+
+func (r *stubRepository) List(_ context.Context, afterId int, limit int) ([]*models.User, error) {
+	ids := make([]int, 0, len(r.users))
+	for id := range r.users {
+		if id > afterId {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	users := []*models.User{}
+	for _, id := range ids {
+		if len(users) == limit {
+			break
+		}
+
+		user := r.users[id]
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+// newClient serves a handler over an in-memory connection.
+func newClient(t *testing.T) userpb.UserServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	controller := controllers.New(&stubRepository{users: map[int]models.User{}}, controllers.Options{})
+	userpb.RegisterUserServiceServer(server, New(controller))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return userpb.NewUserServiceClient(conn)
+}
+
+func requireCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err), err)
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	client := newClient(t)
+
+	created, err := client.Create(ctx, &userpb.CreateRequest{Email: " jane@example.com", Name: "Jane"})
+	require.NoError(t, err, err)
+	require.Equal(t, int64(1), created.GetId())
+
+	t.Run("Create", func(t *testing.T) {
+		_, err := client.Create(ctx, &userpb.CreateRequest{Email: "not an email", Name: "Jane"})
+		requireCode(t, err, codes.InvalidArgument)
+
+		_, err = client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com"})
+		requireCode(t, err, codes.InvalidArgument)
+
+		_, err = client.Create(ctx, &userpb.CreateRequest{Email: "JANE@example.com", Name: "Jane"})
+		requireCode(t, err, codes.AlreadyExists)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		resp, err := client.Get(ctx, &userpb.GetRequest{Id: 1})
+		require.NoError(t, err, err)
+		require.Equal(t, "jane@example.com", resp.GetUser().GetEmail())
+		require.Equal(t, "Jane", resp.GetUser().GetName())
+
+		_, err = client.Get(ctx, &userpb.GetRequest{Id: 99})
+		requireCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		resp, err := client.Update(ctx, &userpb.UpdateRequest{Id: 1, Email: "jane@example.com", Name: "Jane Doe"})
+		require.NoError(t, err, err)
+		require.Equal(t, "Jane Doe", resp.GetUser().GetName())
+
+		_, err = client.Update(ctx, &userpb.UpdateRequest{Id: 99, Email: "x@example.com", Name: "X"})
+		requireCode(t, err, codes.NotFound)
+	})
+
+	t.Run("UpdateEmail", func(t *testing.T) {
+		resp, err := client.UpdateEmail(ctx, &userpb.UpdateEmailRequest{Id: 1, Email: "jane.doe@example.com"})
+		require.NoError(t, err, err)
+		require.Equal(t, "jane.doe@example.com", resp.GetUser().GetEmail())
+
+		_, err = client.UpdateEmail(ctx, &userpb.UpdateEmailRequest{Id: 1, Email: "bad"})
+		requireCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		_, err := client.Create(ctx, &userpb.CreateRequest{Email: "john@example.com", Name: "John"})
+		require.NoError(t, err, err)
+
+		page, err := client.List(ctx, &userpb.ListRequest{PageSize: 1})
+		require.NoError(t, err, err)
+		require.Len(t, page.GetUsers(), 1)
+		require.Equal(t, "1", page.GetNextPageToken())
+
+		page, err = client.List(ctx, &userpb.ListRequest{PageSize: 1, PageToken: page.GetNextPageToken()})
+		require.NoError(t, err, err)
+		require.Equal(t, "John", page.GetUsers()[0].GetName())
+
+		_, err = client.List(ctx, &userpb.ListRequest{PageToken: "abc"})
+		requireCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream, err := client.Watch(watchCtx, &userpb.WatchRequest{})
+		require.NoError(t, err, err)
+
+		events := make(chan *userpb.WatchResponse)
+		go func() {
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					return
+				}
+
+				select {
+				case events <- event:
+				case <-watchCtx.Done():
+					return
+				}
+			}
+		}()
+
+		// Nothing tells when the server subscribed, so update until a
+		// change is seen.
+		var event *userpb.WatchResponse
+		for deadline := time.Now().Add(time.Second); event == nil; {
+			require.True(t, time.Now().Before(deadline), "no event received")
+
+			_, err := client.Update(ctx, &userpb.UpdateRequest{Id: 2, Email: "john@example.com", Name: "John"})
+			require.NoError(t, err, err)
+
+			select {
+			case event = <-events:
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+
+		require.Equal(t, userpb.EventType_EVENT_TYPE_UPDATED, event.GetType())
+		require.Equal(t, int64(2), event.GetUser().GetId())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := client.Delete(ctx, &userpb.DeleteRequest{Id: 2})
+		require.NoError(t, err, err)
+
+		_, err = client.Delete(ctx, &userpb.DeleteRequest{Id: 2})
+		requireCode(t, err, codes.NotFound)
+	})
+}
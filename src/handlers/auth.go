@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/controllers"
+)
+
+const bearerPrefix = "Bearer "
+
+var (
+	ErrNoSession = errors.New("no bearer token provided")
+	ErrOtherUser = errors.New("the session belongs to another user")
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionResponse follows the OAuth 2.0 token response: access_token goes in
+// the Authorization header as a bearer token for expires_in seconds.
+type SessionResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func newSessionResponse(session *controllers.Session) SessionResponse {
+	return SessionResponse{
+		AccessToken:  session.AccessToken,
+		RefreshToken: session.RefreshToken,
+		TokenType:    strings.TrimSpace(bearerPrefix),
+		ExpiresIn:    int(time.Until(session.ExpiresAt).Seconds()),
+	}
+}
+
+// ForgotPassword answers 202 whether or not the email belongs to a user.
+func (h *Handler) ForgotPassword(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.ForgotPassword").End()
+
+	req := ForgotPasswordRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	if req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoEmail.Error()})
+	}
+
+	err := h.controller.ForgotPassword(c.UserContext(), req.Email)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).Send(nil)
+}
+
+func (h *Handler) ResetPassword(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.ResetPassword").End()
+
+	req := ResetPasswordRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	if req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoToken.Error()})
+	}
+
+	err := h.controller.ResetPassword(c.UserContext(), req.Token, req.Password)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (h *Handler) Login(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Login").End()
+
+	req := LoginRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	session, challenge, err := h.controller.Login(c.UserContext(), req.Email, req.Password)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	if challenge != nil {
+		return c.Status(fiber.StatusAccepted).JSON(MFAChallengeResponse{
+			ChallengeToken: challenge.Token,
+			ExpiresIn:      int(time.Until(challenge.ExpiresAt).Seconds()),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(newSessionResponse(session))
+}
+
+func (h *Handler) Refresh(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Refresh").End()
+
+	req := RefreshRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	if req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoToken.Error()})
+	}
+
+	session, err := h.controller.RefreshSession(c.UserContext(), req.RefreshToken)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(newSessionResponse(session))
+}
+
+// Session returns the user the bearer token authenticates, so a gateway can
+// check tokens it forwards.
+func (h *Handler) Session(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Session").End()
+
+	token, ok := bearerToken(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(rest.ErrorResponse{Error: ErrNoSession.Error()})
+	}
+
+	user, err := h.controller.Authenticate(c.UserContext(), token)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+func (h *Handler) Logout(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Logout").End()
+
+	token, ok := bearerToken(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(rest.ErrorResponse{Error: ErrNoSession.Error()})
+	}
+
+	err := h.controller.Logout(c.UserContext(), token)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RequireSelf lets a request through only when its bearer token is a
+// session of the user in the :id parameter.
+func (h *Handler) RequireSelf(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.RequireSelf").End()
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
+	}
+
+	token, ok := bearerToken(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(rest.ErrorResponse{Error: ErrNoSession.Error()})
+	}
+
+	user, err := h.controller.Authenticate(c.UserContext(), token)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	if user.Id != id {
+		return c.Status(fiber.StatusForbidden).JSON(rest.ErrorResponse{Error: ErrOtherUser.Error()})
+	}
+
+	return c.Next()
+}
+
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+
+	return header[len(bearerPrefix):], true
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository/models"
+)
+
+func TestRequireSelf(t *testing.T) {
+	hash := sha256.Sum256([]byte("jane-token"))
+	repo := &stubRepository{sessions: map[string]*models.User{string(hash[:]): {Id: 7}}}
+	controller := controllers.New(repo, controllers.Options{Auth: controllers.NewAuth(controllers.AuthConfig{}, nil)})
+
+	app := fiber.New()
+	app.Delete("/users/:id/mfa/totp", New(controller).RequireSelf, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	request := func(path, token string) int {
+		req := httptest.NewRequest(fiber.MethodDelete, path, nil)
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, fiber.StatusNoContent, request("/users/7/mfa/totp", "jane-token"))
+	require.Equal(t, fiber.StatusForbidden, request("/users/8/mfa/totp", "jane-token"))
+	require.Equal(t, fiber.StatusUnauthorized, request("/users/7/mfa/totp", "other-token"))
+	require.Equal(t, fiber.StatusUnauthorized, request("/users/7/mfa/totp", ""))
+	require.Equal(t, fiber.StatusBadRequest, request("/users/x/mfa/totp", "jane-token"))
+}
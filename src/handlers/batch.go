@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+type BatchGetRequest struct {
+	Ids []int `json:"ids"`
+}
+
+type BatchGetResult struct {
+	Id     int          `json:"id"`
+	Status int          `json:"status"`
+	User   *models.User `json:"user,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+type BatchGetResponse struct {
+	Results []BatchGetResult `json:"results"`
+}
+
+type BatchCreateRequest struct {
+	Users []rest.CreateRequest `json:"users"`
+	// Atomic creates either every user or none of them.
+	Atomic bool `json:"atomic"`
+}
+
+type BatchDeleteRequest struct {
+	Ids []int `json:"ids"`
+	// Atomic deletes either every user or none of them.
+	Atomic bool `json:"atomic"`
+}
+
+// BatchResult reports one item of a batch write with the status the single
+// item endpoint would have returned. Items of a failed atomic batch that
+// would have succeeded report 424 Failed Dependency.
+type BatchResult struct {
+	Id     int    `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+func (h *Handler) BatchGet(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.BatchGet").End()
+
+	req := BatchGetRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	users, err := h.controller.BatchGet(c.UserContext(), req.Ids)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	resp := BatchGetResponse{Results: make([]BatchGetResult, len(req.Ids))}
+	for i, id := range req.Ids {
+		result := BatchGetResult{Id: id, Status: fiber.StatusOK, User: users[i]}
+		if users[i] == nil {
+			result.Status, result.Error = errorStatus(repository.ErrNotFound)
+		}
+
+		resp.Results[i] = result
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+func (h *Handler) BatchCreate(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.BatchCreate").End()
+
+	req := BatchCreateRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	users := make([]*models.User, len(req.Users))
+	for i, user := range req.Users {
+		users[i] = &models.User{Email: user.Email, Name: user.Name}
+	}
+
+	results, err := h.controller.BatchCreate(c.UserContext(), users, req.Atomic)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(batchResponse(results, fiber.StatusCreated))
+}
+
+func (h *Handler) BatchDelete(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.BatchDelete").End()
+
+	req := BatchDeleteRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	results, err := h.controller.BatchDelete(c.UserContext(), req.Ids, req.Atomic)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	resp := batchResponse(results, fiber.StatusOK)
+	for i := range resp.Results {
+		resp.Results[i].Id = req.Ids[i]
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+func batchResponse(results []repository.BatchResult, successStatus int) BatchResponse {
+	resp := BatchResponse{Results: make([]BatchResult, len(results))}
+	for i, result := range results {
+		resp.Results[i] = BatchResult{Id: result.Id, Status: successStatus}
+		if result.Err != nil {
+			resp.Results[i].Status, resp.Results[i].Error = errorStatus(result.Err)
+		}
+	}
+
+	return resp
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/exporter"
+	"go-user-service/src/logging"
+	"go-user-service/src/repository"
+)
+
+// ErrExportAborted is reported in place of the underlying error, which may
+// reveal internals.
+var ErrExportAborted = errors.New("export aborted, the data is incomplete")
+
+// ExportSummary ends every NDJSON export. The status and headers are sent
+// before the first row, so a failure mid-stream cannot change the response;
+// instead Error is set, and an export missing its summary was cut off.
+type ExportSummary struct {
+	Count int    `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// Export streams users as an attachment. Parquet files are unreadable without
+// their footer, and NDJSON ends with an ExportSummary, so both show when a
+// failure mid-stream truncated them; a CSV export does not.
+func (h *Handler) Export(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Export").End()
+
+	format := exporter.Format(c.Query("format", string(exporter.FormatCSV)))
+	contentType := format.ContentType()
+	if contentType == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: exporter.ErrBadFormat.Error()})
+	}
+
+	afterId := c.QueryInt("after", 0)
+	if afterId < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadListQuery.Error()})
+	}
+
+	opts := exporter.Options{
+		Format: format,
+		Filter: repository.ExportFilter{
+			EmailDomain: c.Query("email_domain"),
+			AfterId:     afterId,
+		},
+	}
+
+	// The fiber context is released once the handler returns, so everything
+	// the stream writer needs is captured here.
+	ctx := c.UserContext()
+	controller := h.controller
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users.%s"`, format))
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		count, err := exporter.Run(ctx, controller, w, opts)
+		if err != nil {
+			logging.FromContext(ctx).Error("export aborted", zap.Error(err))
+		}
+
+		if format == exporter.FormatNDJSON {
+			summary := ExportSummary{Count: count}
+			if err != nil {
+				summary.Error = ErrExportAborted.Error()
+			}
+
+			// Failing here means the client is gone.
+			_ = json.NewEncoder(w).Encode(summary)
+			_ = w.Flush()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// stubRepository serves and exports its users, failing exports with err when
+// set, and knows the sessions in sessions, keyed by token hash.
+type stubRepository struct {
+	repository.Repository
+
+	users    []*models.User
+	err      error
+	sessions map[string]*models.User
+}
+
+func (r *stubRepository) GetSessionUser(_ context.Context, tokenHash []byte) (*models.User, error) {
+	user, ok := r.sessions[string(tokenHash)]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (r *stubRepository) Get(_ context.Context, id int) (*models.User, error) {
+	for _, user := range r.users {
+		if user.Id == id {
+			return user, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func (r *stubRepository) Export(_ context.Context, _ repository.ExportFilter, fn func(*models.User) error) error {
+	for _, user := range r.users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return r.err
+}
+
+func TestExport(t *testing.T) {
+	export := func(t *testing.T, repo *stubRepository) []map[string]any {
+		app := fiber.New()
+		app.Get("/users\\:export", New(controllers.New(repo, controllers.Options{})).Export)
+
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/users:export?format=ndjson", nil))
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var lines []map[string]any
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		require.NoError(t, scanner.Err())
+
+		return lines
+	}
+
+	users := []*models.User{
+		{Id: 1, Email: "a@example.com", Name: "A"},
+		{Id: 2, Email: "b@example.com", Name: "B"},
+	}
+
+	t.Run("Complete", func(t *testing.T) {
+		lines := export(t, &stubRepository{users: users})
+		require.Len(t, lines, 3)
+		require.Equal(t, "b@example.com", lines[1]["email"])
+		require.Equal(t, map[string]any{"count": 2.0}, lines[2])
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		lines := export(t, &stubRepository{users: users, err: errors.New("connection reset")})
+		require.Len(t, lines, 3)
+		require.Equal(t, map[string]any{"count": 2.0, "error": ErrExportAborted.Error()}, lines[2])
+	})
+}
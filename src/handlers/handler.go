@@ -1,12 +1,20 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 
+	"go-user-service/src/api/rest"
 	"go-user-service/src/controllers"
+	"go-user-service/src/logging"
+	"go-user-service/src/repository"
 	"go-user-service/src/repository/models"
 )
 
@@ -15,29 +23,12 @@ var (
 	ErrInternal       = errors.New("internal error")
 	ErrBadUserPayload = errors.New("bad user payload")
 	ErrNoEmail        = errors.New("no email provided")
+	ErrBadListQuery   = errors.New("bad list query")
+	ErrNoToken        = errors.New("no token provided")
+	ErrNoCode         = errors.New("no code provided")
 )
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
-type CreateRequest struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
-}
-
-type CreateResponse struct {
-	Id int `json:"id"`
-}
-
-type UpdateRequest struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
-}
-
-type UpdateEmailRequest struct {
-	Email *string `json:"email"`
-}
+var tracer = otel.Tracer("go-user-service/src/handlers")
 
 type Handler struct {
 	controller *controllers.Controller
@@ -48,42 +39,74 @@ func New(controller *controllers.Controller) *Handler {
 }
 
 func (h *Handler) Create(c *fiber.Ctx) error {
-	req := CreateRequest{}
+	defer startSpan(c, "Handler.Create").End()
+
+	req := rest.CreateRequest{}
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(ErrBadUserPayload.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
 	}
 
 	id, err := h.controller.Create(c.UserContext(), &models.User{Email: req.Email, Name: req.Name})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString(ErrInternal.Error())
+		return sendError(c, err)
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(&CreateResponse{Id: id})
+	return c.Status(fiber.StatusCreated).JSON(&rest.CreateResponse{Id: id})
 }
 
 func (h *Handler) Get(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Get").End()
+
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserPayload.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
 	}
 
 	user, err := h.controller.Get(c.UserContext(), id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: ErrInternal.Error()})
+		return sendError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(&user)
 }
 
+func (h *Handler) List(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.List").End()
+
+	after, err := strconv.Atoi(c.Query("after", "0"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadListQuery.Error()})
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "0"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadListQuery.Error()})
+	}
+
+	users, err := h.controller.List(c.UserContext(), after, limit)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	resp := rest.ListResponse{Users: users}
+	if len(users) > 0 && len(users) == controllers.PageSize(limit) {
+		resp.NextAfter = users[len(users)-1].Id
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
 func (h *Handler) Update(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Update").End()
+
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserId.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
 	}
 
-	req := UpdateRequest{}
+	req := rest.UpdateRequest{}
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserPayload.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
 	}
 
 	user := &models.User{
@@ -93,50 +116,150 @@ func (h *Handler) Update(c *fiber.Ctx) error {
 	}
 	err = h.controller.Update(c.UserContext(), user)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: ErrInternal.Error()})
+		return sendError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(user)
 }
 
 func (h *Handler) UpdateEmail(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.UpdateEmail").End()
+
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserId.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
 	}
 
-	req := UpdateEmailRequest{}
+	req := rest.UpdateEmailRequest{}
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserPayload.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
 	}
 
 	if req.Email == nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrNoEmail.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoEmail.Error()})
 	}
 
-	err = h.controller.UpdateEmail(c.UserContext(), id, *req.Email)
+	change, err := h.controller.UpdateEmail(c.UserContext(), id, *req.Email)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: ErrInternal.Error()})
+		return sendError(c, err)
 	}
 
 	user, err := h.controller.Get(c.UserContext(), id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: ErrInternal.Error()})
+		return sendError(c, err)
+	}
+
+	// A pending change shows as pending_email until confirmed.
+	status := fiber.StatusOK
+	if change != nil {
+		status = fiber.StatusAccepted
+	}
+
+	return c.Status(status).JSON(user)
+}
+
+func (h *Handler) ConfirmEmail(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.ConfirmEmail").End()
+
+	return h.useEmailToken(c, h.controller.ConfirmEmail)
+}
+
+func (h *Handler) RevertEmail(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.RevertEmail").End()
+
+	return h.useEmailToken(c, h.controller.RevertEmail)
+}
+
+func (h *Handler) useEmailToken(c *fiber.Ctx, use func(ctx context.Context, id int, token string) (*models.User, error)) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
+	}
+
+	req := rest.EmailTokenRequest{Token: c.Query("token")}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+		}
+	}
+
+	if req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoToken.Error()})
+	}
+
+	user, err := use(c.UserContext(), id, req.Token)
+	if err != nil {
+		return sendError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(user)
 }
 
 func (h *Handler) Delete(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Delete").End()
+
 	id, err := strconv.Atoi(c.Params("id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: ErrBadUserId.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
 	}
 
 	err = h.controller.Delete(c.UserContext(), id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: ErrInternal.Error()})
+		return sendError(c, err)
 	}
 
 	return c.Status(fiber.StatusOK).Send(nil)
 }
+
+func sendError(c *fiber.Ctx, err error) error {
+	status, message := errorStatus(err)
+	if status >= fiber.StatusInternalServerError {
+		logInternalError(c, err)
+	}
+
+	return c.Status(status).JSON(rest.ErrorResponse{Error: message})
+}
+
+// logInternalError records the cause behind a 5xx response, which clients
+// only see as ErrInternal, in the request log and on the request span.
+func logInternalError(c *fiber.Ctx, err error) {
+	ctx := c.UserContext()
+	logging.FromContext(ctx).Error("request failed", zap.Error(err))
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// startSpan starts a span named after the handler and stores it in the user
+// context, so controller and repository spans become its children.
+func startSpan(c *fiber.Ctx, name string) trace.Span {
+	ctx, span := tracer.Start(c.UserContext(), name)
+	c.SetUserContext(ctx)
+
+	return span
+}
+
+// errorStatus maps a controller error to the HTTP status and message clients see.
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, controllers.ErrBadEmail), errors.Is(err, controllers.ErrBadName),
+		errors.Is(err, controllers.ErrBadBatchSize), errors.Is(err, controllers.ErrBadToken),
+		errors.Is(err, controllers.ErrBadPassword), errors.Is(err, controllers.ErrBadMFACode):
+		return fiber.StatusBadRequest, err.Error()
+	case errors.Is(err, controllers.ErrBadCredentials), errors.Is(err, controllers.ErrBadSession),
+		errors.Is(err, controllers.ErrBadChallenge):
+		return fiber.StatusUnauthorized, err.Error()
+	case errors.Is(err, repository.ErrNotFound):
+		return fiber.StatusNotFound, repository.ErrNotFound.Error()
+	case errors.Is(err, controllers.ErrNoAuth):
+		// As if the auth feature were disabled.
+		return fiber.StatusNotFound, err.Error()
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return fiber.StatusConflict, repository.ErrAlreadyExists.Error()
+	case errors.Is(err, repository.ErrBatchAborted):
+		return fiber.StatusFailedDependency, repository.ErrBatchAborted.Error()
+	default:
+		return fiber.StatusInternalServerError, ErrInternal.Error()
+	}
+}
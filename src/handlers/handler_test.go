@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository/models"
+)
+
+// TestUserJSON pins the keys users are served with; renaming one breaks
+// existing clients.
+func TestUserJSON(t *testing.T) {
+	repo := &stubRepository{users: []*models.User{
+		{Id: 1, Email: "a@example.com", Name: "A", NormalizedEmail: "a@example.com", PendingEmail: "b@example.com", MFAEnabled: true},
+	}}
+
+	app := fiber.New()
+	app.Get("/users/:id", New(controllers.New(repo, controllers.Options{})).Get)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/users/1", nil))
+	require.NoError(t, err, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, map[string]any{
+		"Id":            1.0,
+		"Email":         "a@example.com",
+		"Name":          "A",
+		"pending_email": "b@example.com",
+		"mfa_enabled":   true,
+	}, body)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/importer"
+)
+
+const (
+	importModeInsert = "insert"
+	importModeUpsert = "upsert"
+
+	maxReportedErrors = 1000
+)
+
+var ErrBadImportMode = errors.New("mode must be insert or upsert")
+
+type ImportResponse struct {
+	importer.Report
+	Errors []importer.RowError `json:"errors"`
+	// ErrorsTruncated is set when more than maxReportedErrors rows failed.
+	ErrorsTruncated bool `json:"errors_truncated,omitempty"`
+}
+
+func (h *Handler) Import(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.Import").End()
+
+	format := importer.Format(c.Query("format"))
+	if format == "" {
+		format = formatFromContentType(c.Get(fiber.HeaderContentType))
+	}
+
+	mode := c.Query("mode", importModeInsert)
+	if mode != importModeInsert && mode != importModeUpsert {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadImportMode.Error()})
+	}
+
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	resp := ImportResponse{Errors: []importer.RowError{}}
+	report, err := importer.Run(c.UserContext(), h.controller, body, importer.Options{
+		Format: format,
+		Upsert: mode == importModeUpsert,
+		DryRun: c.QueryBool("dry_run"),
+		OnError: func(rowErr importer.RowError) {
+			if len(resp.Errors) == maxReportedErrors {
+				resp.ErrorsTruncated = true
+				return
+			}
+
+			resp.Errors = append(resp.Errors, rowErr)
+		},
+	})
+
+	switch {
+	case errors.Is(err, importer.ErrBadFormat), errors.Is(err, importer.ErrBadCSVHeader):
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: err.Error()})
+	case err != nil:
+		logInternalError(c, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(rest.ErrorResponse{Error: ErrInternal.Error()})
+	}
+
+	resp.Report = *report
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+func formatFromContentType(contentType string) importer.Format {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return importer.FormatCSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"), strings.HasPrefix(contentType, "application/jsonl"):
+		return importer.FormatNDJSON
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"go-user-service/src/api/rest"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TOTPEnrollmentResponse carries the secret both as an otpauth URI and as
+// a base64 encoded QR code PNG of it.
+type TOTPEnrollmentResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCode     string `json:"qr_png"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAChallengeResponse answers a login whose user has MFA enabled; the
+// token goes to POST /auth/login/mfa along with a code.
+type MFAChallengeResponse struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+func (h *Handler) EnrollTOTP(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.EnrollTOTP").End()
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
+	}
+
+	// Only a user with MFA enabled has to send a code.
+	req := MFACodeRequest{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+		}
+	}
+
+	enrollment, err := h.controller.EnrollTOTP(c.UserContext(), id, req.Code)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(TOTPEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.URI,
+		QRCode:     base64.StdEncoding.EncodeToString(enrollment.QRCode),
+	})
+}
+
+func (h *Handler) ConfirmTOTP(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.ConfirmTOTP").End()
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
+	}
+
+	req := MFACodeRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	if req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoCode.Error()})
+	}
+
+	codes, err := h.controller.ConfirmTOTP(c.UserContext(), id, req.Code)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *Handler) DisableTOTP(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.DisableTOTP").End()
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserId.Error()})
+	}
+
+	req := MFACodeRequest{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+		}
+	}
+
+	err = h.controller.DisableTOTP(c.UserContext(), id, req.Code)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+func (h *Handler) LoginMFA(c *fiber.Ctx) error {
+	defer startSpan(c, "Handler.LoginMFA").End()
+
+	req := MFALoginRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrBadUserPayload.Error()})
+	}
+
+	if req.ChallengeToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoToken.Error()})
+	}
+
+	if req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(rest.ErrorResponse{Error: ErrNoCode.Error()})
+	}
+
+	session, err := h.controller.CompleteLogin(c.UserContext(), req.ChallengeToken, req.Code)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(newSessionResponse(session))
+}
@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"go-user-service/src/logging"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	ErrShuttingDown = errors.New("shutting down")
+	// ErrCheckFailed and ErrCheckTimeout replace a failing check's own error
+	// in reports, which unauthenticated probes can read; the full error is
+	// only logged.
+	ErrCheckFailed  = errors.New("check failed")
+	ErrCheckTimeout = errors.New("check timed out")
+)
+
+// Check reports whether a dependency is usable; a nil error means healthy.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// LatencyMs is how long the check took, in milliseconds.
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// Registry holds the readiness checks of the service's dependencies.
+type Registry struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]Check
+
+	shuttingDown atomic.Bool
+}
+
+// NewRegistry creates a registry whose checks each get timeout to finish; a
+// non-positive timeout selects DefaultTimeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Registry{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Register adds or replaces the check reported under name.
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = check
+}
+
+// SetShuttingDown makes readiness fail from now on, so load balancers stop
+// routing new requests while in-flight ones drain.
+func (r *Registry) SetShuttingDown() {
+	r.shuttingDown.Store(true)
+}
+
+func (r *Registry) ShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Check runs every registered check concurrently and reports them sorted by
+// name. The report is down when any check fails or the service is stopping.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+
+	checks := make([]Check, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make([]CheckResult, len(names))}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = r.run(ctx, names[i], checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+
+	if r.ShuttingDown() {
+		report.Status = StatusDown
+		report.Checks = append(report.Checks, CheckResult{
+			Name:   "shutdown",
+			Status: StatusDown,
+			Error:  ErrShuttingDown.Error(),
+		})
+	}
+
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, name string, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{
+		Name:      name,
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Warn("health check failed", zap.String("check", name), zap.Error(err))
+
+		result.Status = StatusDown
+		result.Error = ErrCheckFailed.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = ErrCheckTimeout.Error()
+		}
+	}
+
+	return result
+}
+
+// Liveness answers 200 as long as the process can serve HTTP at all. It
+// deliberately ignores dependencies so a database outage does not get every
+// replica restarted.
+func (r *Registry) Liveness(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(Report{Status: StatusUp, Checks: []CheckResult{}})
+}
+
+// Readiness runs the checks and answers 200 when all pass and 503 otherwise.
+func (r *Registry) Readiness(c *fiber.Ctx) error {
+	report := r.Check(c.UserContext())
+
+	status := fiber.StatusOK
+	if report.Status != StatusUp {
+		status = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(status).JSON(report)
+}
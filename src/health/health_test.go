@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"go-user-service/src/logging"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry(50 * time.Millisecond)
+	registry.Register("b", func(context.Context) error { return nil })
+	registry.Register("a", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	core, logs := observer.New(zap.WarnLevel)
+	report := registry.Check(logging.WithLogger(context.Background(), zap.New(core)))
+	require.Equal(t, StatusDown, report.Status)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, "a", report.Checks[0].Name)
+	require.Equal(t, StatusDown, report.Checks[0].Status)
+	require.Equal(t, ErrCheckTimeout.Error(), report.Checks[0].Error)
+	require.Equal(t, "b", report.Checks[1].Name)
+	require.Equal(t, StatusUp, report.Checks[1].Status)
+
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, context.DeadlineExceeded.Error(), logs.All()[0].ContextMap()["error"])
+
+	registry.Register("a", func(context.Context) error { return nil })
+	require.Equal(t, StatusUp, registry.Check(context.Background()).Status)
+
+	registry.SetShuttingDown()
+	report = registry.Check(context.Background())
+	require.Equal(t, StatusDown, report.Status)
+	require.Equal(t, "shutdown", report.Checks[len(report.Checks)-1].Name)
+}
+
+func TestHandlers(t *testing.T) {
+	registry := NewRegistry(0)
+	registry.Register("database", func(context.Context) error { return errors.New("connection refused") })
+
+	app := fiber.New()
+	app.Get("/healthz", registry.Liveness)
+	app.Get("/readyz", registry.Readiness)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.NoError(t, err, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, err, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	var report Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.Equal(t, StatusDown, report.Status)
+	require.Equal(t, ErrCheckFailed.Error(), report.Checks[0].Error)
+}
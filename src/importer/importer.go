@@ -0,0 +1,281 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+const (
+	DefaultBatchSize = 1000
+
+	maxLineSize = 1 << 20
+)
+
+var (
+	ErrBadFormat    = errors.New("format must be csv or ndjson")
+	ErrBadCSVHeader = errors.New("csv header must contain email and name columns")
+	ErrBadRecord    = errors.New("malformed record")
+	ErrConflict     = errors.New("email already exists")
+	ErrDuplicate    = errors.New("email repeated in import")
+)
+
+type Options struct {
+	Format    Format
+	Upsert    bool
+	DryRun    bool
+	BatchSize int
+	// OnError receives every rejected row, in input order.
+	OnError func(RowError)
+}
+
+type RowError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type Report struct {
+	Total    int  `json:"total"`
+	Inserted int  `json:"inserted"`
+	Updated  int  `json:"updated"`
+	Failed   int  `json:"failed"`
+	DryRun   bool `json:"dry_run"`
+}
+
+type record struct {
+	line  int
+	email string
+	name  string
+	err   error
+}
+
+// Run streams users from r, validates them with the controller rules and
+// stores them in batches. Row problems are reported through opts.OnError;
+// the returned error is only set when the import itself could not proceed.
+func Run(ctx context.Context, controller *controllers.Controller, r io.Reader, opts Options) (*Report, error) {
+	next, err := newReader(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	report := &Report{DryRun: opts.DryRun}
+	fail := func(line int, email string, err error) {
+		report.Failed++
+		if opts.OnError != nil {
+			opts.OnError(RowError{Line: line, Email: email, Error: err.Error()})
+		}
+	}
+
+	// A dry run rolls every batch back, so later batches cannot see the rows
+	// earlier ones would have written. written remembers them instead.
+	written := make(map[string]bool)
+
+	batch := make([]repository.ImportRecord, 0, opts.BatchSize)
+	flush := func() error {
+		outcomes, err := controller.Import(ctx, batch, repository.ImportOptions{Upsert: opts.Upsert, DryRun: opts.DryRun})
+		if err != nil {
+			return err
+		}
+
+		for i, outcome := range outcomes {
+			if opts.DryRun {
+				email := batch[i].User.NormalizedEmail
+				if outcome == repository.ImportInserted && written[email] {
+					outcome = repository.ImportConflict
+					if opts.Upsert {
+						outcome = repository.ImportUpdated
+					}
+				}
+
+				if outcome == repository.ImportInserted || outcome == repository.ImportUpdated {
+					written[email] = true
+				}
+			}
+
+			switch outcome {
+			case repository.ImportInserted:
+				report.Inserted++
+			case repository.ImportUpdated:
+				report.Updated++
+			case repository.ImportConflict:
+				fail(batch[i].Line, batch[i].User.Email, ErrConflict)
+			case repository.ImportDuplicate:
+				fail(batch[i].Line, batch[i].User.Email, ErrDuplicate)
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		rec, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return report, err
+		}
+
+		report.Total++
+		if rec.err != nil {
+			fail(rec.line, rec.email, rec.err)
+			continue
+		}
+
+		user := models.User{Email: rec.email, Name: rec.name}
+		if err := controller.Validate(&user); err != nil {
+			fail(rec.line, rec.email, err)
+			continue
+		}
+
+		batch = append(batch, repository.ImportRecord{Line: rec.line, User: user})
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+func newReader(r io.Reader, format Format) (func() (record, error), error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return newNDJSONReader(r), nil
+	default:
+		return nil, ErrBadFormat
+	}
+}
+
+func newCSVReader(r io.Reader) (func() (record, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Join(ErrBadCSVHeader, err)
+	}
+
+	emailCol, nameCol := -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "email":
+			emailCol = i
+		case "name":
+			nameCol = i
+		}
+	}
+
+	if emailCol < 0 || nameCol < 0 {
+		return nil, ErrBadCSVHeader
+	}
+
+	return func() (record, error) {
+		fields, err := reader.Read()
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return record{line: parseErr.StartLine, err: ErrBadRecord}, nil
+		}
+
+		if err != nil {
+			return record{}, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(fields) <= emailCol || len(fields) <= nameCol {
+			return record{line: line, err: ErrBadRecord}, nil
+		}
+
+		return record{
+			line:  line,
+			email: strings.TrimSpace(fields[emailCol]),
+			name:  strings.TrimSpace(fields[nameCol]),
+		}, nil
+	}, nil
+}
+
+func newNDJSONReader(r io.Reader) func() (record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	line := 0
+
+	return func() (record, error) {
+		for scanner.Scan() {
+			line++
+			raw := strings.TrimSpace(scanner.Text())
+			if raw == "" {
+				continue
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+				return record{line: line, err: fmt.Errorf("%w: %w", ErrBadRecord, err)}, nil
+			}
+
+			if _, ok := fields["count"]; ok {
+				if len(fields) == 1 {
+					// The summary ending an export served over HTTP.
+					continue
+				}
+
+				return record{line: line, err: ErrBadRecord}, nil
+			}
+
+			var row struct {
+				Email string `json:"email"`
+				Name  string `json:"name"`
+			}
+			if err := json.Unmarshal([]byte(raw), &row); err != nil {
+				return record{line: line, err: fmt.Errorf("%w: %w", ErrBadRecord, err)}, nil
+			}
+
+			return record{
+				line:  line,
+				email: strings.TrimSpace(row.Email),
+				name:  strings.TrimSpace(row.Name),
+			}, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return record{}, err
+		}
+
+		return record{}, io.EOF
+	}
+}
@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/emailaddr"
+	"go-user-service/src/repository"
+)
+
+// stubRepository records import batches and treats taken@example.com as existing.
+type stubRepository struct {
+	repository.Repository
+
+	batches [][]repository.ImportRecord
+}
+
+func (r *stubRepository) Import(_ context.Context, records []repository.ImportRecord, _ repository.ImportOptions) ([]repository.ImportOutcome, error) {
+	r.batches = append(r.batches, append([]repository.ImportRecord(nil), records...))
+
+	outcomes := make([]repository.ImportOutcome, len(records))
+	for i, record := range records {
+		outcomes[i] = repository.ImportInserted
+		if record.User.Email == "taken@example.com" {
+			outcomes[i] = repository.ImportConflict
+		}
+	}
+
+	return outcomes, nil
+}
+
+func TestRun(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		repo := &stubRepository{}
+		input := "name,email\n" +
+			"Ann,ann@example.com\n" +
+			"Bob,\n" +
+			"Taken,taken@example.com\n" +
+			"\"Multi\nLine\",multi@example.com\n" +
+			"Cid,cid@example.com\n"
+
+		var rowErrors []RowError
+		report, err := Run(context.Background(), controllers.New(repo, controllers.Options{}), strings.NewReader(input), Options{
+			Format:    FormatCSV,
+			BatchSize: 2,
+			OnError:   func(e RowError) { rowErrors = append(rowErrors, e) },
+		})
+		require.NoError(t, err, err)
+		require.Equal(t, Report{Total: 5, Inserted: 3, Failed: 2}, *report)
+
+		require.Len(t, repo.batches, 2)
+		require.Equal(t, 2, repo.batches[0][0].Line)
+		require.Equal(t, 5, repo.batches[1][0].Line)
+
+		require.Equal(t, []RowError{
+			{Line: 3, Error: controllers.ErrBadEmail.Error() + ": " + emailaddr.ErrSyntax.Error()},
+			{Line: 4, Email: "taken@example.com", Error: ErrConflict.Error()},
+		}, rowErrors)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		repo := &stubRepository{}
+		input := `{"email":"a@example.com","name":"A"}` + "\n\n" +
+			`{"email":"b@example.com"` + "\n" +
+			`{"email":"c@example.com","name":"C"}` + "\n" +
+			`{"count":1,"name":"x"}` + "\n" +
+			`{"count":3}` + "\n"
+
+		var rowErrors []RowError
+		report, err := Run(context.Background(), controllers.New(repo, controllers.Options{}), strings.NewReader(input), Options{
+			Format:  FormatNDJSON,
+			DryRun:  true,
+			OnError: func(e RowError) { rowErrors = append(rowErrors, e) },
+		})
+		require.NoError(t, err, err)
+		require.Equal(t, Report{Total: 4, Inserted: 2, Failed: 2, DryRun: true}, *report)
+		require.Len(t, rowErrors, 2)
+		require.Equal(t, 3, rowErrors[0].Line)
+		require.Equal(t, RowError{Line: 5, Error: ErrBadRecord.Error()}, rowErrors[1])
+	})
+
+	t.Run("DryRunAcrossBatches", func(t *testing.T) {
+		input := "email,name\n" +
+			"ann@example.com,Ann\n" +
+			"bob@example.com,Bob\n" +
+			"ANN@example.com,Ann\n"
+
+		for _, upsert := range []bool{false, true} {
+			var rowErrors []RowError
+			report, err := Run(context.Background(), controllers.New(&stubRepository{}, controllers.Options{}), strings.NewReader(input), Options{
+				Format:    FormatCSV,
+				Upsert:    upsert,
+				DryRun:    true,
+				BatchSize: 2,
+				OnError:   func(e RowError) { rowErrors = append(rowErrors, e) },
+			})
+			require.NoError(t, err, err)
+
+			if upsert {
+				require.Equal(t, Report{Total: 3, Inserted: 2, Updated: 1, DryRun: true}, *report)
+				require.Empty(t, rowErrors)
+			} else {
+				require.Equal(t, Report{Total: 3, Inserted: 2, Failed: 1, DryRun: true}, *report)
+				require.Equal(t, []RowError{{Line: 4, Email: "ANN@example.com", Error: ErrConflict.Error()}}, rowErrors)
+			}
+		}
+	})
+
+	t.Run("BadHeader", func(t *testing.T) {
+		_, err := Run(context.Background(), controllers.New(&stubRepository{}, controllers.Options{}), strings.NewReader("mail,name\n"), Options{Format: FormatCSV})
+		require.ErrorIs(t, err, ErrBadCSVHeader)
+	})
+
+	t.Run("BadFormat", func(t *testing.T) {
+		_, err := Run(context.Background(), controllers.New(&stubRepository{}, controllers.Options{}), strings.NewReader(""), Options{Format: "xml"})
+		require.ErrorIs(t, err, ErrBadFormat)
+	})
+}
@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	// maxRequestIDLength bounds client supplied ids so they cannot flood logs.
+	maxRequestIDLength = 128
+)
+
+type Config struct {
+	// Level is debug, info, warn or error.
+	Level string `mapstructure:"level" yaml:"level"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	_, err := zapcore.ParseLevel(c.Level)
+	if err != nil {
+		return fmt.Errorf("level: %w", err)
+	}
+
+	return nil
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// WithLogger returns a copy of ctx carrying logger.
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the global zap logger
+// when there is none, so callers outside a request can log unconditionally.
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*zap.Logger); ok {
+		return logger
+	}
+
+	return zap.L()
+}
+
+// RequestID returns the id of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Middleware assigns every request an id, taken from the X-Request-ID header
+// when the client sent a usable one, echoes it in the response and stores a
+// logger tagged with it (and the trace id, when tracing) in c.UserContext().
+// Each request is logged once it completes.
+func Middleware(logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		id := utils.CopyString(c.Get(HeaderRequestID))
+		if !validRequestID(id) {
+			id = utils.UUIDv4()
+		}
+
+		c.Set(HeaderRequestID, id)
+
+		ctx := c.UserContext()
+		fields := []zap.Field{zap.String("request_id", id)}
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			fields = append(fields, zap.String("trace_id", spanContext.TraceID().String()))
+		}
+
+		requestLogger := logger.With(fields...)
+		ctx = context.WithValue(ctx, requestIDKey, id)
+		c.SetUserContext(WithLogger(ctx, requestLogger))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			}
+		}
+
+		requestFields := []zap.Field{
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.String("route", c.Route().Path),
+			zap.Int("status", status),
+			zap.Duration("duration", time.Since(start)),
+		}
+		if size := responseSize(c); size >= 0 {
+			requestFields = append(requestFields, zap.Int("bytes", size))
+		}
+		requestFields = append(requestFields,
+			zap.String("ip", c.IP()),
+			zap.String("user_agent", c.Get(fiber.HeaderUserAgent)),
+		)
+
+		requestLogger.Info("request", requestFields...)
+
+		return err
+	}
+}
+
+// responseSize returns the body size, or -1 when it is unknown. Streamed
+// bodies only get written after the handlers return, and reading them here
+// would buffer the whole stream, so they count only when their length was
+// given up front.
+func responseSize(c *fiber.Ctx) int {
+	resp := c.Response()
+	if resp.IsBodyStream() {
+		return resp.Header.ContentLength()
+	}
+
+	return len(resp.Body())
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
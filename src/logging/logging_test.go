@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+
+	app := fiber.New()
+	app.Use(Middleware(zap.New(core)))
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		FromContext(c.UserContext()).Error("request failed")
+		return c.SendString(RequestID(c.UserContext()))
+	})
+
+	t.Run("Propagated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Header.Set(HeaderRequestID, "abc-123")
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+		require.Equal(t, "abc-123", resp.Header.Get(HeaderRequestID))
+
+		entries := logs.TakeAll()
+		require.Len(t, entries, 2)
+		require.Equal(t, "request failed", entries[0].Message)
+		require.Equal(t, "request", entries[1].Message)
+		for _, entry := range entries {
+			require.Equal(t, "abc-123", entry.ContextMap()["request_id"])
+		}
+
+		require.Equal(t, "/users/:id", entries[1].ContextMap()["route"])
+		require.EqualValues(t, fiber.StatusOK, entries[1].ContextMap()["status"])
+		require.EqualValues(t, len("abc-123"), entries[1].ContextMap()["bytes"])
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		var loggedFirst bool
+		app.Get("/stream", func(c *fiber.Ctx) error {
+			c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+				// The request is logged without waiting for the stream.
+				loggedFirst = logs.Len() == 1
+				for i := 0; i < 3; i++ {
+					w.WriteString("chunk\n")
+					w.Flush()
+				}
+			})
+			return nil
+		})
+
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/stream", nil))
+		require.NoError(t, err, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, err)
+		require.Equal(t, strings.Repeat("chunk\n", 3), string(body))
+		require.True(t, loggedFirst)
+
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		require.NotContains(t, entries[0].ContextMap(), "bytes")
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Header.Set(HeaderRequestID, "has spaces")
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+
+		id := resp.Header.Get(HeaderRequestID)
+		require.NotEmpty(t, id)
+		require.NotEqual(t, "has spaces", id)
+		require.Equal(t, id, logs.TakeAll()[1].ContextMap()["request_id"])
+	})
+}
@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BackendLog  = "log"
+	BackendFile = "file"
+	BackendSMTP = "smtp"
+)
+
+var Backends = []string{BackendLog, BackendFile, BackendSMTP}
+
+// Message is an email to a single recipient. HTML is optional.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Mailer delivers messages to users.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	// Backend is file (one .eml file per message in Dir), smtp or log (only
+	// recipients and subjects are logged, for tests and demos).
+	Backend string `mapstructure:"backend" yaml:"backend"`
+	// From is the sender, e.g. "Example <no-reply@example.com>".
+	From string `mapstructure:"from" yaml:"from"`
+	// Dir is where the file backend writes messages.
+	Dir  string     `mapstructure:"dir" yaml:"dir"`
+	SMTP SMTPConfig `mapstructure:"smtp" yaml:"smtp"`
+	// TemplatesDir holds templates overriding the built-in ones, laid out
+	// the same way: <locale>/<name>.tmpl.
+	TemplatesDir string `mapstructure:"templates_dir" yaml:"templates_dir"`
+	// DefaultLocale is used when the recipient's language is unknown or has
+	// no templates.
+	DefaultLocale string      `mapstructure:"default_locale" yaml:"default_locale"`
+	Queue         QueueConfig `mapstructure:"queue" yaml:"queue"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	if !slices.Contains(Backends, c.Backend) {
+		errs = append(errs, fmt.Errorf("backend: must be one of %s, got %q", strings.Join(Backends, ", "), c.Backend))
+	}
+
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		errs = append(errs, fmt.Errorf("from: must be an email address, got %q", c.From))
+	}
+
+	if c.Backend == BackendFile && c.Dir == "" {
+		errs = append(errs, errors.New("dir: must be set for the file backend"))
+	}
+
+	if c.Backend == BackendSMTP {
+		for _, err := range c.SMTP.validate() {
+			errs = append(errs, fmt.Errorf("smtp.%w", err))
+		}
+	}
+
+	if _, err := parseLocale(c.DefaultLocale); err != nil {
+		errs = append(errs, fmt.Errorf("default_locale: %w", err))
+	}
+
+	for _, err := range c.Queue.validate() {
+		errs = append(errs, fmt.Errorf("queue.%w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// New creates the mailer cfg.Backend selects.
+func New(cfg Config, logger *zap.Logger) (Mailer, error) {
+	switch cfg.Backend {
+	case BackendFile:
+		return NewFileMailer(cfg.From, cfg.Dir)
+	case BackendSMTP:
+		return NewSMTPMailer(cfg.From, cfg.SMTP), nil
+	default:
+		return NewLogMailer(logger), nil
+	}
+}
+
+// LogMailer logs the recipient and subject of messages instead of sending
+// them. Bodies are left out, as they hold confirmation and reset tokens that
+// must not end up in logs.
+type LogMailer struct {
+	logger *zap.Logger
+}
+
+func NewLogMailer(logger *zap.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.logger.Info("email",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+	)
+
+	return nil
+}
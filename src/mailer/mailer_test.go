@@ -0,0 +1,425 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testFrom = "Service <no-reply@example.com>"
+
+// smtpServer is a minimal SMTP server that records one transaction per
+// connection and answers RCPT with rcptReply.
+type smtpServer struct {
+	listener  net.Listener
+	rcptReply string
+
+	mu   sync.Mutex
+	from []string
+	to   []string
+	data []string
+}
+
+func newSMTPServer(t *testing.T, rcptReply string) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpServer{listener: listener, rcptReply: rcptReply}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(line string) { _ = text.PrintfLine("%s", line) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		s.mu.Lock()
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			s.from = append(s.from, arg)
+			reply("250 OK")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			reply(s.rcptReply)
+		case "DATA":
+			reply("354 go ahead")
+			s.mu.Unlock()
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.data = append(s.data, string(data))
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ctx := context.Background()
+	msg := Message{
+		To:      "Jane <jane@example.com>",
+		Subject: "Grüße",
+		Text:    "Hello Jane,\nbye\n",
+		HTML:    "<p>Hello Jane</p>",
+	}
+
+	t.Run("Delivers", func(t *testing.T) {
+		server := newSMTPServer(t, "250 OK")
+		m := NewSMTPMailer(testFrom, SMTPConfig{Host: "127.0.0.1", Port: server.port(), TLS: TLSNone, Timeout: time.Second})
+
+		require.NoError(t, m.Send(ctx, msg))
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		require.Equal(t, []string{"FROM:<no-reply@example.com>"}, server.from)
+		require.Equal(t, []string{"TO:<jane@example.com>"}, server.to)
+		require.Len(t, server.data, 1)
+
+		parsed, err := mail.ReadMessage(strings.NewReader(server.data[0]))
+		require.NoError(t, err)
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+		require.NoError(t, err)
+		require.Equal(t, "Grüße", subject)
+		require.Equal(t, `"Jane" <jane@example.com>`, parsed.Header.Get("To"))
+		require.True(t, strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>"))
+
+		mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		require.NoError(t, err)
+		require.Equal(t, "multipart/alternative", mediaType)
+
+		parts := multipart.NewReader(parsed.Body, params["boundary"])
+		var bodies []string
+		for {
+			part, err := parts.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(part)
+			require.NoError(t, err)
+			bodies = append(bodies, string(body))
+		}
+		require.Equal(t, []string{"Hello Jane,\nbye\n", "<p>Hello Jane</p>"}, bodies)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		server := newSMTPServer(t, "550 no such user")
+		m := NewSMTPMailer(testFrom, SMTPConfig{Host: "127.0.0.1", Port: server.port(), TLS: TLSNone, Timeout: time.Second})
+
+		err := m.Send(ctx, msg)
+		require.ErrorContains(t, err, "no such user")
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		server := newSMTPServer(t, "250 OK")
+		port := server.port()
+		server.listener.Close()
+
+		m := NewSMTPMailer(testFrom, SMTPConfig{Host: "127.0.0.1", Port: port, TLS: TLSNone, Timeout: time.Second})
+		require.Error(t, m.Send(ctx, msg))
+	})
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := NewFileMailer(testFrom, dir)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "Hello\n"}))
+	require.Error(t, m.Send(context.Background(), Message{To: "not an address", Subject: "Hi", Text: "Hello\n"}))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, ".eml", filepath.Ext(files[0].Name()))
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	require.Equal(t, "Hi", parsed.Header.Get("Subject"))
+	require.Equal(t, "text/plain; charset=utf-8", parsed.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(parsed.Body)
+	require.NoError(t, err)
+	require.Equal(t, "Hello\r\n", string(body))
+}
+
+func TestLogMailer(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	m := NewLogMailer(zap.New(core))
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "jane@example.com", Subject: "Hi", Text: "token=secret", HTML: "token=secret"}))
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, map[string]any{"to": "jane@example.com", "subject": "Hi"}, logs.All()[0].ContextMap())
+}
+
+func TestConfig(t *testing.T) {
+	valid := Config{
+		Backend:       BackendSMTP,
+		From:          testFrom,
+		SMTP:          SMTPConfig{Host: "smtp.example.com", Port: 587, TLS: TLSStartTLS},
+		DefaultLocale: "en",
+		Queue:         QueueConfig{MaxAttempts: 1, Backoff: time.Second, MaxBackoff: time.Second, PollInterval: time.Second, BatchSize: 1, Retention: time.Hour},
+	}
+	require.NoError(t, valid.Validate())
+
+	invalid := valid
+	invalid.From = "nobody"
+	invalid.SMTP.TLS = "ssl"
+	invalid.DefaultLocale = "not a locale"
+	invalid.Queue.MaxBackoff = 0
+	err := invalid.Validate()
+	require.ErrorContains(t, err, "from:")
+	require.ErrorContains(t, err, "smtp.tls:")
+	require.ErrorContains(t, err, "default_locale:")
+	require.ErrorContains(t, err, "queue.max_backoff:")
+}
+
+func TestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fr"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fr", "email_changed.tmpl"),
+		[]byte(`{{define "subject"}}Adresse modifiée{{end}}{{define "text"}}Nouvelle adresse : {{.NewEmail}}{{end}}`), 0o600))
+
+	templates, err := LoadTemplates(dir, "en")
+	require.NoError(t, err)
+
+	data := map[string]any{"NewEmail": "<jane@example.com>", "RevertLink": "http://localhost/revert?token=a&b"}
+
+	for _, tt := range []struct {
+		acceptLanguage string
+		subject        string
+	}{
+		{"", "Your email address was changed"},
+		{"ja", "Your email address was changed"},
+		{"de-AT, en;q=0.5", "Ihre E-Mail-Adresse wurde geändert"},
+		{"fr-CH", "Adresse modifiée"},
+	} {
+		msg, err := templates.Render(WithLanguage(context.Background(), tt.acceptLanguage), "email_changed", data)
+		require.NoError(t, err, tt.acceptLanguage)
+		require.Equal(t, tt.subject, msg.Subject, tt.acceptLanguage)
+	}
+
+	msg, err := templates.Render(context.Background(), "email_changed", data)
+	require.NoError(t, err)
+	require.Contains(t, msg.Text, "changed to <jane@example.com>.")
+	require.Contains(t, msg.HTML, "&lt;jane@example.com&gt;")
+	require.Contains(t, msg.HTML, `href="http://localhost/revert?token=a&amp;b"`)
+
+	// French has no confirm_email, so the default locale is used.
+	msg, err = templates.Render(WithLanguage(context.Background(), "fr"), "confirm_email",
+		map[string]any{"Link": "http://localhost/confirm", "ExpiresAt": time.Unix(0, 0)})
+	require.NoError(t, err)
+	require.Equal(t, "Confirm your new email address", msg.Subject)
+
+	_, err = templates.Render(context.Background(), "missing", nil)
+	require.ErrorIs(t, err, ErrNoTemplate)
+
+	_, err = LoadTemplates("", "fr")
+	require.Error(t, err)
+}
+
+// memoryQueueStore keeps queued messages in memory, ignoring leases.
+type memoryQueueStore struct {
+	messages map[int64]*QueuedMessage
+	due      map[int64]time.Time
+	failed   map[int64]string
+	failedAt map[int64]time.Time
+	nextId   int64
+	now      *time.Time
+}
+
+func newMemoryQueueStore(now *time.Time) *memoryQueueStore {
+	return &memoryQueueStore{
+		messages: make(map[int64]*QueuedMessage),
+		due:      make(map[int64]time.Time),
+		failed:   make(map[int64]string),
+		failedAt: make(map[int64]time.Time),
+		now:      now,
+	}
+}
+
+func (s *memoryQueueStore) Enqueue(_ context.Context, msg Message) error {
+	s.nextId++
+	s.messages[s.nextId] = &QueuedMessage{Id: s.nextId, Message: msg}
+	s.due[s.nextId] = *s.now
+	return nil
+}
+
+func (s *memoryQueueStore) Claim(_ context.Context, limit int, lease time.Duration) ([]QueuedMessage, error) {
+	var claimed []QueuedMessage
+	for id := int64(1); id <= s.nextId && len(claimed) < limit; id++ {
+		queued, ok := s.messages[id]
+		if !ok || s.failed[id] != "" || s.due[id].After(*s.now) {
+			continue
+		}
+
+		queued.Attempts++
+		s.due[id] = s.now.Add(lease)
+		claimed = append(claimed, *queued)
+	}
+
+	return claimed, nil
+}
+
+func (s *memoryQueueStore) Delete(_ context.Context, id int64) error {
+	delete(s.messages, id)
+	return nil
+}
+
+func (s *memoryQueueStore) Retry(_ context.Context, id int64, at time.Time, _ string) error {
+	s.due[id] = at
+	return nil
+}
+
+func (s *memoryQueueStore) Fail(_ context.Context, id int64, lastErr string) error {
+	s.failed[id] = lastErr
+	s.failedAt[id] = *s.now
+	s.messages[id].Message.Text, s.messages[id].Message.HTML = "", ""
+	return nil
+}
+
+func (s *memoryQueueStore) Purge(_ context.Context, failedBefore time.Time) (int64, error) {
+	var n int64
+	for id, at := range s.failedAt {
+		if at.Before(failedBefore) {
+			delete(s.messages, id)
+			delete(s.failedAt, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// flakyMailer fails the first failures sends.
+type flakyMailer struct {
+	failures int
+	sent     []Message
+}
+
+func (m *flakyMailer) Send(_ context.Context, msg Message) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	store := newMemoryQueueStore(&now)
+	backend := &flakyMailer{failures: 2}
+
+	cfg := QueueConfig{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 90 * time.Second, PollInterval: time.Second, BatchSize: 10, Retention: time.Hour}
+	queue := NewQueue(cfg, store, backend, zap.NewNop())
+	queue.now = func() time.Time { return now }
+
+	require.Equal(t, time.Minute, queue.backoff(1))
+	require.Equal(t, 90*time.Second, queue.backoff(2))
+	require.Equal(t, 90*time.Second, queue.backoff(10))
+
+	msg := Message{To: "jane@example.com", Subject: "Hi", Text: "Hello\n"}
+	require.NoError(t, queue.Send(ctx, msg))
+
+	// The first attempt fails and is retried after the backoff.
+	n, err := queue.flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, now.Add(time.Minute), store.due[1])
+
+	n, err = queue.flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	now = now.Add(time.Minute)
+	_, err = queue.flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(90*time.Second), store.due[1])
+
+	now = now.Add(90 * time.Second)
+	_, err = queue.flush(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Message{msg}, backend.sent)
+	require.Empty(t, store.messages)
+
+	// A message failing every attempt is given up.
+	backend.failures = 3
+	require.NoError(t, queue.Send(ctx, msg))
+	for range cfg.MaxAttempts {
+		_, err = queue.flush(ctx)
+		require.NoError(t, err)
+		now = now.Add(cfg.MaxBackoff)
+	}
+	require.Equal(t, "connection refused", store.failed[2])
+	require.Equal(t, Message{To: "jane@example.com", Subject: "Hi"}, store.messages[2].Message, "body kept")
+
+	n, err = queue.flush(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// Failed messages are purged after the retention.
+	queue.purge(ctx)
+	require.Contains(t, store.messages, int64(2))
+	now = now.Add(cfg.Retention)
+	queue.purge(ctx)
+	require.Empty(t, store.messages)
+}
@@ -0,0 +1,229 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// claimLease hides a claimed message from other workers while it is
+	// sent. A worker that dies mid-send leaves the message to be retried
+	// afterwards, so a message may rarely be delivered twice.
+	claimLease = 5 * time.Minute
+
+	// purgeInterval is how often failed messages past their retention are
+	// deleted.
+	purgeInterval = time.Hour
+)
+
+type QueueConfig struct {
+	// Enabled stores messages in the database and sends them in the
+	// background, retrying failures; otherwise they are sent while the
+	// request waits and failures are returned.
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// MaxAttempts is how many times a message is tried before giving up.
+	MaxAttempts int `mapstructure:"max_attempts" yaml:"max_attempts"`
+	// Backoff is the wait before the first retry; it doubles up to
+	// MaxBackoff with each failure.
+	Backoff    time.Duration `mapstructure:"backoff" yaml:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff" yaml:"max_backoff"`
+	// PollInterval is how often the queue is checked for due messages, as
+	// messages queued by other replicas or due for a retry are not announced.
+	PollInterval time.Duration `mapstructure:"poll_interval" yaml:"poll_interval"`
+	// BatchSize is how many messages a poll claims at most.
+	BatchSize int `mapstructure:"batch_size" yaml:"batch_size"`
+	// Retention is how long failed messages are kept for inspection.
+	Retention time.Duration `mapstructure:"retention" yaml:"retention"`
+}
+
+func (c QueueConfig) validate() []error {
+	var errs []error
+	if c.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("max_attempts: must be positive, got %d", c.MaxAttempts))
+	}
+
+	if c.Backoff <= 0 {
+		errs = append(errs, fmt.Errorf("backoff: must be positive, got %s", c.Backoff))
+	}
+
+	if c.MaxBackoff < c.Backoff {
+		errs = append(errs, fmt.Errorf("max_backoff: must be at least backoff, got %s", c.MaxBackoff))
+	}
+
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("poll_interval: must be positive, got %s", c.PollInterval))
+	}
+
+	if c.BatchSize < 1 {
+		errs = append(errs, fmt.Errorf("batch_size: must be positive, got %d", c.BatchSize))
+	}
+
+	if c.Retention <= 0 {
+		errs = append(errs, fmt.Errorf("retention: must be positive, got %s", c.Retention))
+	}
+
+	return errs
+}
+
+// QueuedMessage is a message claimed from a QueueStore.
+type QueuedMessage struct {
+	Id      int64
+	Message Message
+	// Attempts counts the tries so far, including the current one.
+	Attempts int
+}
+
+// QueueStore keeps messages until they are sent. Queued messages hold the
+// tokens of the links they carry, so stores must be guarded like secrets.
+type QueueStore interface {
+	Enqueue(ctx context.Context, msg Message) error
+	// Claim returns up to limit messages that are due, counting an attempt
+	// and hiding them from other claims for lease.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]QueuedMessage, error)
+	// Delete removes a sent message.
+	Delete(ctx context.Context, id int64) error
+	// Retry makes a message due again at the given time.
+	Retry(ctx context.Context, id int64, at time.Time, lastErr string) error
+	// Fail gives up on a message. Its recipient and subject are kept for
+	// inspection, its body is dropped.
+	Fail(ctx context.Context, id int64, lastErr string) error
+	// Purge deletes the messages given up before the given time and
+	// returns how many there were.
+	Purge(ctx context.Context, failedBefore time.Time) (int64, error)
+}
+
+// Queue is a Mailer that stores messages and has Run send them through
+// another Mailer, retrying with exponential backoff.
+type Queue struct {
+	cfg    QueueConfig
+	store  QueueStore
+	mailer Mailer
+	logger *zap.Logger
+	now    func() time.Time
+	wake   chan struct{}
+}
+
+func NewQueue(cfg QueueConfig, store QueueStore, m Mailer, logger *zap.Logger) *Queue {
+	return &Queue{
+		cfg:    cfg,
+		store:  store,
+		mailer: m,
+		logger: logger,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Send stores msg; it is sent in the background.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	if err := q.store.Enqueue(ctx, msg); err != nil {
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run sends due messages until ctx is done, when the messages being sent
+// are finished first.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var purged time.Time
+	for {
+		if now := q.now(); now.Sub(purged) >= purgeInterval {
+			purged = now
+			q.purge(ctx)
+		}
+
+		for {
+			n, err := q.flush(ctx)
+			if err != nil {
+				q.logger.Error("cannot claim queued emails", zap.Error(err))
+			}
+
+			// A full batch suggests more are due.
+			if err != nil || n < q.cfg.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// flush sends one batch of due messages and returns its size.
+func (q *Queue) flush(ctx context.Context) (int, error) {
+	claimed, err := q.store.Claim(ctx, q.cfg.BatchSize, claimLease)
+	if err != nil {
+		return 0, err
+	}
+
+	// Claimed messages are sent even when shutting down, as they would
+	// otherwise wait for the lease to expire.
+	ctx = context.WithoutCancel(ctx)
+	for _, queued := range claimed {
+		q.deliver(ctx, queued)
+	}
+
+	return len(claimed), nil
+}
+
+func (q *Queue) deliver(ctx context.Context, queued QueuedMessage) {
+	logger := q.logger.With(zap.Int64("email_id", queued.Id), zap.Int("attempt", queued.Attempts))
+
+	sendErr := q.mailer.Send(ctx, queued.Message)
+
+	var err error
+	switch {
+	case sendErr == nil:
+		err = q.store.Delete(ctx, queued.Id)
+	case queued.Attempts >= q.cfg.MaxAttempts:
+		logger.Error("giving up on email", zap.Error(sendErr))
+		err = q.store.Fail(ctx, queued.Id, sendErr.Error())
+	default:
+		retryAt := q.now().Add(q.backoff(queued.Attempts))
+		logger.Warn("cannot send email, will retry", zap.Time("retry_at", retryAt), zap.Error(sendErr))
+		err = q.store.Retry(ctx, queued.Id, retryAt, sendErr.Error())
+	}
+
+	if err != nil {
+		logger.Error("cannot update queued email", zap.Error(err))
+	}
+}
+
+// purge deletes the failed messages past their retention.
+func (q *Queue) purge(ctx context.Context) {
+	n, err := q.store.Purge(ctx, q.now().Add(-q.cfg.Retention))
+	if err != nil {
+		q.logger.Error("cannot purge failed emails", zap.Error(err))
+		return
+	}
+
+	if n > 0 {
+		q.logger.Info("purged failed emails", zap.Int64("count", n))
+	}
+}
+
+// backoff is the wait after the given failed attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.cfg.Backoff
+	for i := 1; i < attempts && d < q.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, q.cfg.MaxBackoff)
+}
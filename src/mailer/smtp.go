@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TLSStartTLS upgrades a plain connection, usually on port 587.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects over TLS, usually on port 465.
+	TLSImplicit = "tls"
+	// TLSNone sends in clear, e.g. to a local relay.
+	TLSNone = "none"
+
+	defaultSMTPTimeout = 10 * time.Second
+)
+
+var TLSModes = []string{TLSStartTLS, TLSImplicit, TLSNone}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host" yaml:"host"`
+	Port     int    `mapstructure:"port" yaml:"port"`
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
+	// TLS is starttls, tls or none.
+	TLS string `mapstructure:"tls" yaml:"tls"`
+	// Timeout bounds a whole delivery.
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"`
+}
+
+func (c SMTPConfig) validate() []error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("host: must not be empty"))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port: must be between 1 and 65535, got %d", c.Port))
+	}
+
+	if !slices.Contains(TLSModes, c.TLS) {
+		errs = append(errs, fmt.Errorf("tls: must be one of %s, got %q", strings.Join(TLSModes, ", "), c.TLS))
+	}
+
+	if c.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout: must not be negative, got %s", c.Timeout))
+	}
+
+	return errs
+}
+
+// SMTPMailer delivers each message over a new connection to a relay.
+type SMTPMailer struct {
+	from string
+	cfg  SMTPConfig
+	// tlsConfig is replaced in tests to trust their certificate.
+	tlsConfig *tls.Config
+}
+
+func NewSMTPMailer(from string, cfg SMTPConfig) *SMTPMailer {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultSMTPTimeout
+	}
+
+	return &SMTPMailer{from: from, cfg: cfg, tlsConfig: &tls.Config{ServerName: cfg.Host}}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := compose(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	sender, _ := mail.ParseAddress(m.from)
+	recipient, _ := mail.ParseAddress(msg.To)
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if m.cfg.TLS == TLSImplicit {
+		dialer = &tls.Dialer{Config: m.tlsConfig}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.cfg.TLS == TLSStartTLS {
+		if err := client.StartTLS(m.tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+
+	if m.cfg.Username != "" {
+		err := client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host))
+		if err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(recipient.Address); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,227 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"golang.org/x/text/language"
+)
+
+// A template file defines the blocks subject, text and, optionally, html.
+const (
+	blockSubject = "subject"
+	blockText    = "text"
+	blockHTML    = "html"
+
+	templateExt = ".tmpl"
+)
+
+var ErrNoTemplate = errors.New("no such email template")
+
+//go:embed templates
+var builtinTemplates embed.FS
+
+type languageKey struct{}
+
+// WithLanguage stores the languages the recipient reads, as an
+// Accept-Language header value, for templates rendered under ctx.
+func WithLanguage(ctx context.Context, acceptLanguage string) context.Context {
+	return context.WithValue(ctx, languageKey{}, acceptLanguage)
+}
+
+// Middleware records the Accept-Language of each request, so mail sent while
+// handling it is in the requester's language.
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if acceptLanguage := c.Get(fiber.HeaderAcceptLanguage); acceptLanguage != "" {
+			c.SetUserContext(WithLanguage(c.UserContext(), utils.CopyString(acceptLanguage)))
+		}
+
+		return c.Next()
+	}
+}
+
+func languageFrom(ctx context.Context) string {
+	acceptLanguage, _ := ctx.Value(languageKey{}).(string)
+	return acceptLanguage
+}
+
+func parseLocale(locale string) (language.Tag, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, fmt.Errorf("must be a BCP 47 language tag, got %q", locale)
+	}
+
+	return tag, nil
+}
+
+type localized struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Templates renders messages from templates named <locale>/<name>.tmpl, in
+// the locale that best matches the recipient's language.
+type Templates struct {
+	locales   []string
+	matcher   language.Matcher
+	templates map[string]map[string]localized
+}
+
+// LoadTemplates loads the built-in templates and, when dir is set, the ones
+// in dir, which replace built-in templates of the same locale and name.
+func LoadTemplates(dir, defaultLocale string) (*Templates, error) {
+	defaultTag, err := parseLocale(defaultLocale)
+	if err != nil {
+		return nil, err
+	}
+
+	builtin, err := fs.Sub(builtinTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{templates: make(map[string]map[string]localized)}
+	sources := []fs.FS{builtin}
+	if dir != "" {
+		sources = append(sources, os.DirFS(dir))
+	}
+
+	for _, source := range sources {
+		if err := t.load(source); err != nil {
+			return nil, err
+		}
+	}
+
+	// The default locale goes first, so the matcher falls back to it.
+	tags := []language.Tag{defaultTag}
+	t.locales = []string{defaultTag.String()}
+	for _, locale := range slices.Sorted(maps.Keys(t.templates)) {
+		if locale != defaultTag.String() {
+			tags = append(tags, language.Make(locale))
+			t.locales = append(t.locales, locale)
+		}
+	}
+
+	if _, ok := t.templates[t.locales[0]]; !ok {
+		return nil, fmt.Errorf("no templates for the default locale %s", t.locales[0])
+	}
+
+	t.matcher = language.NewMatcher(tags)
+
+	return t, nil
+}
+
+func (t *Templates) load(source fs.FS) error {
+	files, err := fs.Glob(source, "*/*"+templateExt)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		tag, err := parseLocale(path.Dir(file))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		raw, err := fs.ReadFile(source, file)
+		if err != nil {
+			return err
+		}
+
+		// The same file is parsed twice so only the html block is escaped.
+		text, err := texttemplate.New(file).Parse(string(raw))
+		if err != nil {
+			return err
+		}
+
+		html, err := htmltemplate.New(file).Parse(string(raw))
+		if err != nil {
+			return err
+		}
+
+		if text.Lookup(blockSubject) == nil || text.Lookup(blockText) == nil {
+			return fmt.Errorf("%s: must define %q and %q", file, blockSubject, blockText)
+		}
+
+		locale := tag.String()
+		if t.templates[locale] == nil {
+			t.templates[locale] = make(map[string]localized)
+		}
+
+		t.templates[locale][strings.TrimSuffix(path.Base(file), templateExt)] = localized{text: text, html: html}
+	}
+
+	return nil
+}
+
+// Render executes the template name with data in the language stored in ctx
+// by WithLanguage, falling back to the default locale.
+func (t *Templates) Render(ctx context.Context, name string, data any) (Message, error) {
+	_, index := language.MatchStrings(t.matcher, languageFrom(ctx))
+
+	tmpl, ok := t.templates[t.locales[index]][name]
+	if !ok {
+		tmpl, ok = t.templates[t.locales[0]][name]
+	}
+
+	if !ok {
+		return Message{}, fmt.Errorf("%w: %s", ErrNoTemplate, name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, blockSubject, data); err != nil {
+		return Message{}, err
+	}
+
+	if err := tmpl.text.ExecuteTemplate(&text, blockText, data); err != nil {
+		return Message{}, err
+	}
+
+	if tmpl.html.Lookup(blockHTML) != nil {
+		if err := tmpl.html.ExecuteTemplate(&html, blockHTML, data); err != nil {
+			return Message{}, err
+		}
+	}
+
+	return Message{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    strings.TrimSpace(html.String()),
+	}, nil
+}
+
+// Sender renders templates for a recipient and sends the result.
+type Sender struct {
+	templates *Templates
+	mailer    Mailer
+}
+
+func NewSender(templates *Templates, m Mailer) *Sender {
+	return &Sender{templates: templates, mailer: m}
+}
+
+// Send renders the template name with data and sends it to the address to.
+func (s *Sender) Send(ctx context.Context, to, name string, data any) error {
+	msg, err := s.templates.Render(ctx, name, data)
+	if err != nil {
+		return err
+	}
+
+	msg.To = to
+
+	return s.mailer.Send(ctx, msg)
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "userservice"
+
+	// unmatchedRoute labels requests no route handled, so scanners probing
+	// random paths cannot blow up label cardinality.
+	unmatchedRoute = "unmatched"
+
+	countTimeout = 5 * time.Second
+)
+
+// Counter reports the total number of users for the users_total gauge.
+type Counter interface {
+	Count(ctx context.Context) (int, error)
+}
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	repoDuration *prometheus.HistogramVec
+	repoErrors   *prometheus.CounterVec
+}
+
+// New creates a registry with the Go runtime and process collectors and the
+// service's HTTP and repository metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		repoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "operation_duration_seconds",
+			Help:      "Repository operation latency by method and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "outcome"}),
+		repoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "operation_errors_total",
+			Help:      "Repository operations that failed with an unexpected error, by method.",
+		}, []string{"method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.repoDuration,
+		m.repoErrors,
+	)
+
+	return m
+}
+
+// Register adds collectors such as collectors.NewDBStatsCollector.
+func (m *Metrics) Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := m.registry.Register(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterUsers exports the total number of users, counted on every scrape.
+func (m *Metrics) RegisterUsers(counter Counter) error {
+	return m.Register(&usersCollector{
+		counter: counter,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "users_total"),
+			"Number of stored users.",
+			nil, nil,
+		),
+	})
+}
+
+// Middleware records RED metrics for every request, labelled with the route
+// pattern rather than the raw path.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		err := c.Next()
+
+		// Errors returned by handlers are turned into responses by the app
+		// error handler after the middleware returns.
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			}
+		}
+
+		route := unmatchedRoute
+		if status != fiber.StatusNotFound || c.Route().Path != "/" {
+			route = strings.ReplaceAll(c.Route().Path, `\:`, ":")
+		}
+
+		// Fiber reuses the request buffers, so the method must be copied
+		// before it outlives the request as a label value.
+		labels := prometheus.Labels{
+			"method": utils.CopyString(c.Method()),
+			"route":  route,
+			"status": strconv.Itoa(status),
+		}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+type usersCollector struct {
+	counter Counter
+	desc    *prometheus.Desc
+}
+
+func (c *usersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *usersCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+	defer cancel()
+
+	// A failed count omits the gauge instead of failing the whole scrape;
+	// the repository error counter already records the failure.
+	count, err := c.counter.Count(ctx)
+	if err != nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+type stubRepository struct {
+	repository.Repository
+}
+
+func (stubRepository) Get(_ context.Context, id int) (*models.User, error) {
+	switch id {
+	case 1:
+		return &models.User{Id: 1}, nil
+	case 2:
+		return nil, repository.ErrNotFound
+	default:
+		return nil, errors.New("connection refused")
+	}
+}
+
+func (stubRepository) Count(context.Context) (int, error) {
+	return 42, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New()
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/users/:id", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/users\\:export", func(c *fiber.Ctx) error { return fiber.ErrBadRequest })
+
+	for _, path := range []string{"/users/1", "/users/2", "/users:export", "/unknown"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err, err)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "/users/:id", "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", "/users:export", "400")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("GET", unmatchedRoute, "404")))
+	require.Equal(t, 0.0, testutil.ToFloat64(m.requestsInFlight))
+}
+
+func TestRepository(t *testing.T) {
+	m := New()
+	repo := m.Repository(stubRepository{})
+	require.NoError(t, m.RegisterUsers(repo))
+
+	for _, id := range []int{1, 2, 3} {
+		_, _ = repo.Get(context.Background(), id)
+	}
+
+	require.Equal(t, 3, testutil.CollectAndCount(m.repoDuration))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.repoErrors.WithLabelValues("Get")))
+
+	count, err := testutil.GatherAndCount(m.registry, "userservice_users_total")
+	require.NoError(t, err, err)
+	require.Equal(t, 1, count)
+}
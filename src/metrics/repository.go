@@ -0,0 +1,286 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	outcomeOK       = "ok"
+	outcomeNotFound = "not_found"
+	outcomeConflict = "conflict"
+	outcomeError    = "error"
+)
+
+var _ repository.Repository = (*instrumentedRepository)(nil)
+
+type instrumentedRepository struct {
+	repo    repository.Repository
+	metrics *Metrics
+}
+
+// Repository wraps repo so every call is timed by method. Not found and
+// conflict results are expected outcomes and do not count as errors.
+func (m *Metrics) Repository(repo repository.Repository) repository.Repository {
+	return &instrumentedRepository{repo: repo, metrics: m}
+}
+
+func (r *instrumentedRepository) observe(method string, start time.Time, err error) {
+	outcome := outcomeOK
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrNotFound):
+		outcome = outcomeNotFound
+	case errors.Is(err, repository.ErrAlreadyExists):
+		outcome = outcomeConflict
+	default:
+		outcome = outcomeError
+		r.metrics.repoErrors.WithLabelValues(method).Inc()
+	}
+
+	r.metrics.repoDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (r *instrumentedRepository) Create(ctx context.Context, user *models.User) (int, error) {
+	start := time.Now()
+	id, err := r.repo.Create(ctx, user)
+	r.observe("Create", start, err)
+	return id, err
+}
+
+func (r *instrumentedRepository) Get(ctx context.Context, id int) (*models.User, error) {
+	start := time.Now()
+	user, err := r.repo.Get(ctx, id)
+	r.observe("Get", start, err)
+	return user, err
+}
+
+func (r *instrumentedRepository) GetByEmail(ctx context.Context, normalizedEmail string) (*models.User, error) {
+	start := time.Now()
+	user, err := r.repo.GetByEmail(ctx, normalizedEmail)
+	r.observe("GetByEmail", start, err)
+	return user, err
+}
+
+func (r *instrumentedRepository) GetMany(ctx context.Context, ids []int) ([]*models.User, error) {
+	start := time.Now()
+	users, err := r.repo.GetMany(ctx, ids)
+	r.observe("GetMany", start, err)
+	return users, err
+}
+
+func (r *instrumentedRepository) List(ctx context.Context, afterId int, limit int) ([]*models.User, error) {
+	start := time.Now()
+	users, err := r.repo.List(ctx, afterId, limit)
+	r.observe("List", start, err)
+	return users, err
+}
+
+func (r *instrumentedRepository) ListOffset(ctx context.Context, offset int, limit int) ([]*models.User, error) {
+	start := time.Now()
+	users, err := r.repo.ListOffset(ctx, offset, limit)
+	r.observe("ListOffset", start, err)
+	return users, err
+}
+
+func (r *instrumentedRepository) Count(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := r.repo.Count(ctx)
+	r.observe("Count", start, err)
+	return count, err
+}
+
+func (r *instrumentedRepository) UpdateEmail(ctx context.Context, id int, email, normalizedEmail string) error {
+	start := time.Now()
+	err := r.repo.UpdateEmail(ctx, id, email, normalizedEmail)
+	r.observe("UpdateEmail", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) RequestEmailChange(ctx context.Context, id int, email, normalizedEmail string, expiresAt time.Time) error {
+	start := time.Now()
+	err := r.repo.RequestEmailChange(ctx, id, email, normalizedEmail, expiresAt)
+	r.observe("RequestEmailChange", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) ConfirmEmailChange(ctx context.Context, id int, normalizedEmail string) (*models.User, error) {
+	start := time.Now()
+	previous, err := r.repo.ConfirmEmailChange(ctx, id, normalizedEmail)
+	r.observe("ConfirmEmailChange", start, err)
+	return previous, err
+}
+
+func (r *instrumentedRepository) RevertEmailChange(ctx context.Context, id int, previous *models.User, issuedAt time.Time) error {
+	start := time.Now()
+	err := r.repo.RevertEmailChange(ctx, id, previous, issuedAt)
+	r.observe("RevertEmailChange", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) SetPasswordReset(ctx context.Context, id int, tokenHash []byte, expiresAt time.Time) error {
+	start := time.Now()
+	err := r.repo.SetPasswordReset(ctx, id, tokenHash, expiresAt)
+	r.observe("SetPasswordReset", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (*models.User, error) {
+	start := time.Now()
+	user, err := r.repo.ResetPassword(ctx, tokenHash, passwordHash)
+	r.observe("ResetPassword", start, err)
+	return user, err
+}
+
+func (r *instrumentedRepository) GetPasswordHash(ctx context.Context, id int) (string, error) {
+	start := time.Now()
+	hash, err := r.repo.GetPasswordHash(ctx, id)
+	r.observe("GetPasswordHash", start, err)
+	return hash, err
+}
+
+func (r *instrumentedRepository) EmailChangedAt(ctx context.Context, id int) (time.Time, error) {
+	start := time.Now()
+	changedAt, err := r.repo.EmailChangedAt(ctx, id)
+	r.observe("EmailChangedAt", start, err)
+	return changedAt, err
+}
+
+func (r *instrumentedRepository) CreateSession(ctx context.Context, session *repository.Session) error {
+	start := time.Now()
+	err := r.repo.CreateSession(ctx, session)
+	r.observe("CreateSession", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) RefreshSession(ctx context.Context, refreshHash []byte, next *repository.Session) (int, error) {
+	start := time.Now()
+	userId, err := r.repo.RefreshSession(ctx, refreshHash, next)
+	r.observe("RefreshSession", start, err)
+	return userId, err
+}
+
+func (r *instrumentedRepository) GetSessionUser(ctx context.Context, tokenHash []byte) (*models.User, error) {
+	start := time.Now()
+	user, err := r.repo.GetSessionUser(ctx, tokenHash)
+	r.observe("GetSessionUser", start, err)
+	return user, err
+}
+
+func (r *instrumentedRepository) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	start := time.Now()
+	err := r.repo.DeleteSession(ctx, tokenHash)
+	r.observe("DeleteSession", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) GetTOTP(ctx context.Context, id int) (*repository.TOTP, error) {
+	start := time.Now()
+	totp, err := r.repo.GetTOTP(ctx, id)
+	r.observe("GetTOTP", start, err)
+	return totp, err
+}
+
+func (r *instrumentedRepository) SetPendingTOTP(ctx context.Context, id int, secret string) error {
+	start := time.Now()
+	err := r.repo.SetPendingTOTP(ctx, id, secret)
+	r.observe("SetPendingTOTP", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) EnableTOTP(ctx context.Context, id int, pendingSecret string, step int64, recoveryCodeHashes [][]byte) error {
+	start := time.Now()
+	err := r.repo.EnableTOTP(ctx, id, pendingSecret, step, recoveryCodeHashes)
+	r.observe("EnableTOTP", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) DisableTOTP(ctx context.Context, id int) error {
+	start := time.Now()
+	err := r.repo.DisableTOTP(ctx, id)
+	r.observe("DisableTOTP", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) UseTOTPStep(ctx context.Context, id int, step int64) error {
+	start := time.Now()
+	err := r.repo.UseTOTPStep(ctx, id, step)
+	r.observe("UseTOTPStep", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) UseRecoveryCode(ctx context.Context, id int, codeHash []byte) error {
+	start := time.Now()
+	err := r.repo.UseRecoveryCode(ctx, id, codeHash)
+	r.observe("UseRecoveryCode", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) CreateMFAChallenge(ctx context.Context, tokenHash []byte, id int, expiresAt time.Time) error {
+	start := time.Now()
+	err := r.repo.CreateMFAChallenge(ctx, tokenHash, id, expiresAt)
+	r.observe("CreateMFAChallenge", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) AttemptMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	start := time.Now()
+	id, err := r.repo.AttemptMFAChallenge(ctx, tokenHash, maxAttempts)
+	r.observe("AttemptMFAChallenge", start, err)
+	return id, err
+}
+
+func (r *instrumentedRepository) DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error {
+	start := time.Now()
+	err := r.repo.DeleteMFAChallenge(ctx, tokenHash)
+	r.observe("DeleteMFAChallenge", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) Update(ctx context.Context, user *models.User) error {
+	start := time.Now()
+	err := r.repo.Update(ctx, user)
+	r.observe("Update", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) Delete(ctx context.Context, id int) error {
+	start := time.Now()
+	err := r.repo.Delete(ctx, id)
+	r.observe("Delete", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) CreateMany(ctx context.Context, users []*models.User, atomic bool) ([]repository.BatchResult, error) {
+	start := time.Now()
+	results, err := r.repo.CreateMany(ctx, users, atomic)
+	r.observe("CreateMany", start, err)
+	return results, err
+}
+
+func (r *instrumentedRepository) DeleteMany(ctx context.Context, ids []int, atomic bool) ([]repository.BatchResult, error) {
+	start := time.Now()
+	results, err := r.repo.DeleteMany(ctx, ids, atomic)
+	r.observe("DeleteMany", start, err)
+	return results, err
+}
+
+func (r *instrumentedRepository) Import(ctx context.Context, records []repository.ImportRecord, opts repository.ImportOptions) ([]repository.ImportOutcome, error) {
+	start := time.Now()
+	outcomes, err := r.repo.Import(ctx, records, opts)
+	r.observe("Import", start, err)
+	return outcomes, err
+}
+
+// Export is timed for the whole stream, including the time fn spends
+// writing to the client.
+func (r *instrumentedRepository) Export(ctx context.Context, filter repository.ExportFilter, fn func(*models.User) error) error {
+	start := time.Now()
+	err := r.repo.Export(ctx, filter, fn)
+	r.observe("Export", start, err)
+	return err
+}
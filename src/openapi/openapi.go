@@ -0,0 +1,195 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version = "3.1.0"
+
+	jsonContentType = "application/json"
+)
+
+// pathParamPattern matches Fiber parameters; a backslash before the colon
+// makes it literal, as in "/users\\:import".
+var pathParamPattern = regexp.MustCompile(`(^|[^\\]):([A-Za-z0-9_]+)`)
+
+// Route documents one HTTP route. Path uses Fiber syntax ("/users/:id") so
+// it can be compared with the routes registered on the app.
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Tag         string
+	Request     interface{}
+	ContentType string
+	// Params overrides the schema type of path parameters, which default to string.
+	Params    map[string]string
+	Responses []Response
+	// OptionalRequest marks Request as one that may be left out.
+	OptionalRequest bool
+}
+
+type Response struct {
+	Status      int
+	Description string
+	Body        interface{}
+	ContentType string
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	OperationId string           `json:"operationId,omitempty"`
+	Summary     string           `json:"summary,omitempty"`
+	Tags        []string         `json:"tags,omitempty"`
+	Parameters  []Parameter      `json:"parameters,omitempty"`
+	RequestBody *RequestBody     `json:"requestBody,omitempty"`
+	Responses   map[string]*Body `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Body struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Build generates a document describing routes, deriving body schemas from
+// the Go types used by the handlers.
+func Build(info Info, routes []Route) *Document {
+	registry := newSchemaRegistry()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]map[string]*Operation),
+	}
+
+	for _, route := range routes {
+		path := PathOf(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+
+		doc.Paths[path][strings.ToLower(route.Method)] = buildOperation(registry, route)
+	}
+
+	doc.Components.Schemas = registry.schemas
+
+	return doc
+}
+
+// PathOf converts a Fiber route path to an OpenAPI path template.
+func PathOf(fiberPath string) string {
+	path := pathParamPattern.ReplaceAllString(fiberPath, "$1{$2}")
+	return strings.ReplaceAll(path, `\:`, ":")
+}
+
+func buildOperation(registry *schemaRegistry, route Route) *Operation {
+	op := &Operation{
+		OperationId: operationId(route),
+		Summary:     route.Summary,
+		Responses:   make(map[string]*Body),
+	}
+
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		paramType := route.Params[match[2]]
+		if paramType == "" {
+			paramType = "string"
+		}
+
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     match[2],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: paramType},
+		})
+	}
+
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: !route.OptionalRequest,
+			Content:  mediaTypes(registry, route.ContentType, route.Request),
+		}
+	}
+
+	for _, resp := range route.Responses {
+		desc := resp.Description
+		if desc == "" {
+			desc = http.StatusText(resp.Status)
+		}
+
+		body := &Body{Description: desc}
+		if resp.Body != nil {
+			contentType := resp.ContentType
+			if contentType == "" {
+				contentType = route.ContentType
+			}
+
+			body.Content = mediaTypes(registry, contentType, resp.Body)
+		}
+
+		op.Responses[strconv.Itoa(resp.Status)] = body
+	}
+
+	return op
+}
+
+func mediaTypes(registry *schemaRegistry, contentType string, body interface{}) map[string]*MediaType {
+	if contentType == "" {
+		contentType = jsonContentType
+	}
+
+	return map[string]*MediaType{
+		contentType: {Schema: registry.schemaOf(reflect.TypeOf(body))},
+	}
+}
+
+func operationId(route Route) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+
+	for _, part := range strings.FieldsFunc(route.Path, func(r rune) bool {
+		return r == '/' || r == ':' || r == '\\' || r == '.' || r == '-' || r == '_'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the memory store drops buckets that refilled.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	updated  time.Time
+}
+
+// MemoryStore keeps buckets in process, so every replica counts on its own.
+type MemoryStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	capacity := float64(limit.Capacity())
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+
+	b.capacity = capacity
+	b.rate = limit.Rate()
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return NewResult(allowed, b.tokens, limit), nil
+}
+
+// sweep forgets the buckets that are full by now, as a new bucket starts
+// full anyway.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < sweepInterval {
+		return
+	}
+
+	s.swept = now
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.rate >= b.capacity {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.uber.org/zap"
+
+	"go-user-service/src/logging"
+)
+
+const (
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+
+	KeyPrincipal = "principal"
+	KeyAPIKey    = "api_key"
+	KeyIP        = "ip"
+
+	HeaderAPIKey    = "X-API-Key"
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+	HeaderPolicy    = "RateLimit-Policy"
+
+	defaultRule = "default"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+	ErrBadStorage  = errors.New("rate limit storage must be memory or postgres")
+)
+
+// Limit is a token bucket holding up to Burst tokens, refilled at Requests
+// per Period. Every request takes a token.
+type Limit struct {
+	Requests int           `mapstructure:"requests" yaml:"requests"`
+	Period   time.Duration `mapstructure:"period" yaml:"period"`
+	// Burst is the bucket size; Requests when zero.
+	Burst int `mapstructure:"burst" yaml:"burst"`
+}
+
+// Capacity is the number of tokens a full bucket holds.
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return l.Requests
+}
+
+// Rate is the number of tokens added per second.
+func (l Limit) Rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) validate() []error {
+	var errs []error
+	if l.Requests <= 0 {
+		errs = append(errs, fmt.Errorf("requests: must be positive, got %d", l.Requests))
+	}
+
+	if l.Period <= 0 {
+		errs = append(errs, fmt.Errorf("period: must be positive, got %s", l.Period))
+	}
+
+	if l.Burst < 0 {
+		errs = append(errs, fmt.Errorf("burst: must not be negative, got %d", l.Burst))
+	}
+
+	return errs
+}
+
+// Rule applies its own limit to the requests matching Method and Path.
+type Rule struct {
+	// Method is an HTTP method; empty matches any.
+	Method string `mapstructure:"method" yaml:"method"`
+	// Path is a path.Match pattern, e.g. /users or /users/*.
+	Path     string        `mapstructure:"path" yaml:"path"`
+	Requests int           `mapstructure:"requests" yaml:"requests"`
+	Period   time.Duration `mapstructure:"period" yaml:"period"`
+	Burst    int           `mapstructure:"burst" yaml:"burst"`
+}
+
+func (r Rule) Limit() Limit {
+	return Limit{Requests: r.Requests, Period: r.Period, Burst: r.Burst}
+}
+
+func (r Rule) name() string {
+	return strings.TrimSpace(r.Method + " " + r.Path)
+}
+
+func (r Rule) matches(method, requestPath string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+
+	matched, _ := path.Match(r.Path, requestPath)
+	return matched
+}
+
+type Config struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Storage is memory (per replica) or postgres (shared by all replicas).
+	Storage string `mapstructure:"storage" yaml:"storage"`
+	// KeyBy lists, in order of preference, what identifies a client:
+	// principal (the mTLS client certificate's common name), api_key (the
+	// X-API-Key header or bearer token, unverified) and ip.
+	KeyBy []string `mapstructure:"key_by" yaml:"key_by"`
+	// Default applies to requests no rule in Routes matches.
+	Default Limit  `mapstructure:"default" yaml:"default"`
+	Routes  []Rule `mapstructure:"routes" yaml:"routes"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Storage {
+	case StorageMemory, StoragePostgres:
+	default:
+		errs = append(errs, fmt.Errorf("storage: %w, got %q", ErrBadStorage, c.Storage))
+	}
+
+	for _, key := range c.KeyBy {
+		if !slices.Contains([]string{KeyPrincipal, KeyAPIKey, KeyIP}, key) {
+			errs = append(errs, fmt.Errorf("key_by: must be %s, %s or %s, got %q", KeyPrincipal, KeyAPIKey, KeyIP, key))
+		}
+	}
+
+	for _, err := range c.Default.validate() {
+		errs = append(errs, fmt.Errorf("default.%w", err))
+	}
+
+	for i, rule := range c.Routes {
+		if _, err := path.Match(rule.Path, ""); err != nil || !strings.HasPrefix(rule.Path, "/") {
+			errs = append(errs, fmt.Errorf("routes[%d].path: must be a pattern starting with /, got %q", i, rule.Path))
+		}
+
+		for _, err := range rule.Limit().validate() {
+			errs = append(errs, fmt.Errorf("routes[%d].%w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left.
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take refills the bucket under key for the time passed since its last
+	// use and takes a token if there is one.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// NewResult derives a Result from the tokens left in a bucket.
+func NewResult(allowed bool, tokens float64, limit Limit) Result {
+	rate := limit.Rate()
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((float64(limit.Capacity()) - tokens) / rate),
+	}
+
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(0, s) * float64(time.Second))
+}
+
+// Limiter applies the configured limits; its configuration can be replaced
+// at runtime, the store cannot.
+type Limiter struct {
+	store Store
+	cfg   atomic.Pointer[Config]
+}
+
+func New(cfg Config, store Store) *Limiter {
+	l := &Limiter{store: store}
+	l.cfg.Store(&cfg)
+
+	return l
+}
+
+// Reload replaces the limits. Buckets keep their tokens, so a stricter limit
+// applies as soon as the bucket drains.
+func (l *Limiter) Reload(cfg Config) {
+	l.cfg.Store(&cfg)
+}
+
+// Middleware takes a token for every request and answers 429 once the
+// client's bucket is empty. When the store fails the request is let through
+// rather than failing the whole API.
+func (l *Limiter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		cfg := l.cfg.Load()
+		if !cfg.Enabled {
+			return c.Next()
+		}
+
+		name, limit := cfg.match(c.Method(), c.Path())
+		key := name + "|" + clientKey(c, cfg.KeyBy)
+
+		res, err := l.store.Take(c.UserContext(), key, limit)
+		if err != nil {
+			logging.FromContext(c.UserContext()).Error("rate limit store failed", zap.Error(err))
+			return c.Next()
+		}
+
+		c.Set(HeaderLimit, strconv.Itoa(limit.Capacity()))
+		c.Set(HeaderRemaining, strconv.Itoa(res.Remaining))
+		c.Set(HeaderReset, strconv.Itoa(ceilSeconds(res.Reset)))
+		c.Set(HeaderPolicy, fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, ceilSeconds(limit.Period), limit.Capacity()))
+
+		if !res.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			return fiber.NewError(fiber.StatusTooManyRequests, ErrRateLimited.Error())
+		}
+
+		return c.Next()
+	}
+}
+
+func (c *Config) match(method, requestPath string) (string, Limit) {
+	for _, rule := range c.Routes {
+		if rule.matches(method, requestPath) {
+			return rule.name(), rule.Limit()
+		}
+	}
+
+	return defaultRule, c.Default
+}
+
+// clientKey identifies the client by the first source in keyBy the request
+// carries; the IP is always available.
+func clientKey(c *fiber.Ctx, keyBy []string) string {
+	for _, source := range keyBy {
+		switch source {
+		case KeyPrincipal:
+			state := c.Context().TLSConnectionState()
+			if state != nil && len(state.VerifiedChains) > 0 {
+				return KeyPrincipal + ":" + state.PeerCertificates[0].Subject.CommonName
+			}
+		case KeyAPIKey:
+			apiKey := c.Get(HeaderAPIKey)
+			if apiKey == "" {
+				apiKey, _ = strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+			}
+
+			if apiKey != "" {
+				// Keys are hashed so they never reach the store in clear.
+				sum := sha256.Sum256([]byte(apiKey))
+				return KeyAPIKey + ":" + hex.EncodeToString(sum[:16])
+			}
+		case KeyIP:
+			return KeyIP + ":" + utils.CopyString(c.IP())
+		}
+	}
+
+	return KeyIP + ":" + utils.CopyString(c.IP())
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
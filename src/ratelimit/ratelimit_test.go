@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Requests: 60, Period: time.Minute, Burst: 2}
+
+	for i, allowed := range []bool{true, true, false} {
+		res, err := store.Take(context.Background(), "a", limit)
+		require.NoError(t, err, err)
+		require.Equal(t, allowed, res.Allowed, i)
+	}
+
+	res, err := store.Take(context.Background(), "a", limit)
+	require.NoError(t, err, err)
+	require.Equal(t, time.Second, res.RetryAfter)
+	require.Equal(t, 2*time.Second, res.Reset)
+
+	// Other keys have their own bucket.
+	res, err = store.Take(context.Background(), "b", limit)
+	require.NoError(t, err, err)
+	require.True(t, res.Allowed)
+
+	now = now.Add(1500 * time.Millisecond)
+	res, err = store.Take(context.Background(), "a", limit)
+	require.NoError(t, err, err)
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+
+	// Refilled buckets are swept.
+	now = now.Add(sweepInterval)
+	_, err = store.Take(context.Background(), "c", limit)
+	require.NoError(t, err, err)
+	require.Len(t, store.buckets, 1)
+}
+
+func TestMiddleware(t *testing.T) {
+	cfg := Config{
+		Enabled: true,
+		Storage: StorageMemory,
+		KeyBy:   []string{KeyAPIKey, KeyIP},
+		Default: Limit{Requests: 100, Period: time.Minute},
+		Routes: []Rule{
+			{Method: http.MethodPost, Path: "/users", Requests: 1, Period: time.Minute},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	limiter := New(cfg, NewMemoryStore())
+
+	app := fiber.New()
+	app.Use(limiter.Middleware())
+	app.All("/users", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	request := func(method, apiKey string) *http.Response {
+		req := httptest.NewRequest(method, "/users", nil)
+		if apiKey != "" {
+			req.Header.Set(HeaderAPIKey, apiKey)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+
+		return resp
+	}
+
+	resp := request(http.MethodPost, "")
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get(HeaderLimit))
+	require.Equal(t, "0", resp.Header.Get(HeaderRemaining))
+	require.Equal(t, "60", resp.Header.Get(HeaderReset))
+	require.Equal(t, "1;w=60;burst=1", resp.Header.Get(HeaderPolicy))
+
+	resp = request(http.MethodPost, "")
+	require.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// The default limit applies to other routes, and API keys are counted
+	// apart from the IP they come from.
+	require.Equal(t, fiber.StatusOK, request(http.MethodGet, "").StatusCode)
+	require.Equal(t, fiber.StatusOK, request(http.MethodPost, "key-1").StatusCode)
+
+	limiter.Reload(Config{Enabled: false})
+	require.Equal(t, fiber.StatusOK, request(http.MethodPost, "").StatusCode)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{
+		Storage: "redis",
+		KeyBy:   []string{"user"},
+		Routes:  []Rule{{Path: "users", Requests: 1, Period: time.Second}},
+	}
+
+	err := cfg.Validate()
+	require.ErrorIs(t, err, ErrBadStorage)
+	require.Contains(t, err.Error(), "key_by: ")
+	require.Contains(t, err.Error(), "default.requests: ")
+	require.Contains(t, err.Error(), "routes[0].path: ")
+}
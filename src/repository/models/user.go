@@ -1,7 +1,18 @@
 package models
 
+// User is served as JSON with the keys Id, Email and Name, which predate
+// the snake_case ones; clients depend on them, so they are left untagged.
 type User struct {
 	Id    int
 	Email string
 	Name  string
+	// NormalizedEmail identifies the mailbox Email delivers to; no two users
+	// share one. It is set by validation and never exposed.
+	NormalizedEmail string `json:"-"`
+	// PendingEmail is the address the user asked to change to, until it is
+	// confirmed or expires. GetByEmail leaves it unset.
+	PendingEmail string `json:"pending_email,omitempty"`
+	// MFAEnabled is set when logins need a second factor. Only Get and
+	// session lookups set it.
+	MFAEnabled bool `json:"mfa_enabled,omitempty"`
 }
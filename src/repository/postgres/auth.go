@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+func (r *Repository) SetPasswordReset(ctx context.Context, id int, tokenHash []byte, expiresAt time.Time) error {
+	query := "UPDATE users SET password_reset_hash = $1, password_reset_expires_at = $2 WHERE id = $3"
+	ctx, span := startSpan(ctx, "SetPasswordReset", query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, tokenHash, expiresAt, id)
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if affected == 0 {
+		return errors.Join(ErrDatabase, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (*models.User, error) {
+	// One statement, so the token cannot be used twice and no session
+	// survives the new password.
+	query := `
+	WITH reset AS (
+		UPDATE users SET password_hash = $2, password_reset_hash = NULL, password_reset_expires_at = NULL
+		WHERE password_reset_hash = $1 AND password_reset_expires_at > now()
+		RETURNING id, email, name
+	), revoked AS (
+		DELETE FROM sessions s USING reset WHERE s.user_id = reset.id
+	)
+	SELECT id, email, name FROM reset`
+	ctx, span := startSpan(ctx, "ResetPassword", query)
+	defer span.End()
+
+	user := &models.User{}
+
+	row := r.conn.QueryRowContext(ctx, query, tokenHash, passwordHash)
+	if err := row.Scan(&user.Id, &user.Email, &user.Name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return user, nil
+}
+
+func (r *Repository) GetPasswordHash(ctx context.Context, id int) (string, error) {
+	query := "SELECT COALESCE(password_hash, '') FROM users WHERE id = $1"
+	ctx, span := startSpan(ctx, "GetPasswordHash", query)
+	defer span.End()
+
+	var hash string
+	if err := r.conn.QueryRowContext(ctx, query, id).Scan(&hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return "", recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return hash, nil
+}
+
+func (r *Repository) EmailChangedAt(ctx context.Context, id int) (time.Time, error) {
+	query := "SELECT email_changed_at FROM users WHERE id = $1"
+	ctx, span := startSpan(ctx, "EmailChangedAt", query)
+	defer span.End()
+
+	var changedAt sql.NullTime
+	if err := r.conn.QueryRowContext(ctx, query, id).Scan(&changedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return time.Time{}, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return changedAt.Time, nil
+}
+
+func (r *Repository) CreateSession(ctx context.Context, session *repository.Session) error {
+	// The user's sessions that can no longer be refreshed are dropped on the
+	// way, so the table does not grow with every login.
+	query := `
+	WITH expired AS (
+		DELETE FROM sessions WHERE user_id = $1 AND refresh_expires_at <= now()
+	)
+	INSERT INTO sessions (user_id, token_hash, refresh_hash, expires_at, refresh_expires_at)
+	VALUES ($1, $2, $3, $4, $5)`
+	ctx, span := startSpan(ctx, "CreateSession", query)
+	defer span.End()
+
+	_, err := r.conn.ExecContext(ctx, query,
+		session.UserId, session.TokenHash, session.RefreshHash, session.ExpiresAt, session.RefreshExpiresAt)
+	if err != nil {
+		return recordError(ctx, span, wrapError(err))
+	}
+
+	return nil
+}
+
+func (r *Repository) RefreshSession(ctx context.Context, refreshHash []byte, next *repository.Session) (int, error) {
+	query := `
+	UPDATE sessions SET token_hash = $2, refresh_hash = $3, expires_at = $4, refresh_expires_at = $5
+	WHERE refresh_hash = $1 AND refresh_expires_at > now()
+	RETURNING user_id`
+	ctx, span := startSpan(ctx, "RefreshSession", query)
+	defer span.End()
+
+	var userId int
+	row := r.conn.QueryRowContext(ctx, query,
+		refreshHash, next.TokenHash, next.RefreshHash, next.ExpiresAt, next.RefreshExpiresAt)
+	if err := row.Scan(&userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return 0, recordError(ctx, span, wrapError(err))
+	}
+
+	return userId, nil
+}
+
+func (r *Repository) GetSessionUser(ctx context.Context, tokenHash []byte) (*models.User, error) {
+	query := `
+	SELECT u.id, u.email, u.name, u.totp_secret IS NOT NULL FROM sessions s JOIN users u ON u.id = s.user_id
+	WHERE s.token_hash = $1 AND s.expires_at > now()`
+	ctx, span := startSpan(ctx, "GetSessionUser", query)
+	defer span.End()
+
+	user := &models.User{}
+
+	row := r.conn.QueryRowContext(ctx, query, tokenHash)
+	if err := row.Scan(&user.Id, &user.Email, &user.Name, &user.MFAEnabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return user, nil
+}
+
+func (r *Repository) DeleteSession(ctx context.Context, tokenHash []byte) error {
+	query := "DELETE FROM sessions WHERE token_hash = $1"
+	ctx, span := startSpan(ctx, "DeleteSession", query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, tokenHash)
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if affected == 0 {
+		return errors.Join(ErrDatabase, ErrNotFound)
+	}
+
+	return nil
+}
@@ -5,23 +5,33 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"go-user-service/src/repository"
 	"go-user-service/src/repository/models"
 )
 
-const (
-	dbName = "users_db"
-)
-
 var _ repository.Repository = (*Repository)(nil)
 
 var (
 	ErrDatabase = errors.New("database error")
 
-	ErrNotFound = errors.New("not found")
+	ErrNotFound = repository.ErrNotFound
+)
+
+const (
+	uniqueViolation = "23505"
+
+	exportFetchSize = 1000
+
+	// userColumns are the columns scanUsers reads. A pending email shows
+	// until it expires.
+	userColumns = "id, email, name, CASE WHEN pending_email_expires_at > now() THEN pending_email ELSE '' END"
 )
 
 type Repository struct {
@@ -44,53 +54,269 @@ func NewRepository(cfg repository.Config) (*Repository, error) {
 		return nil, errors.Join(ErrDatabase, err)
 	}
 
-	// TODO maybe add migrations.
-	err = createDatabase(db)
-	if err != nil {
-		return nil, errors.Join(ErrDatabase, err)
+	repo := &Repository{conn: db}
+	if !cfg.SkipMigrations {
+		_, err = repo.Migrate(context.Background())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return repo, nil
+}
+
+// DB exposes the connection pool, e.g. for pool statistics.
+func (r *Repository) DB() *sql.DB {
+	return r.conn
+}
+
+// Close waits for running queries to finish and closes the connection pool.
+func (r *Repository) Close() error {
+	return r.conn.Close()
+}
+
+// Ping checks that the database is reachable.
+func (r *Repository) Ping(ctx context.Context) error {
+	if err := r.conn.PingContext(ctx); err != nil {
+		return errors.Join(ErrDatabase, err)
 	}
 
-	return &Repository{conn: db}, nil
+	return nil
 }
 
 func (r *Repository) Create(ctx context.Context, user *models.User) (int, error) {
-	query := `INSERT INTO users(email, name) VALUES ($1, $2) RETURNING id`
+	query := `INSERT INTO users(email, email_normalized, name) VALUES ($1, $2, $3) RETURNING id`
+	ctx, span := startSpan(ctx, "Create", query)
+	defer span.End()
 
 	id := 0
-	err := r.conn.QueryRowContext(ctx, query, user.Email, user.Name).Scan(&id)
+	err := r.conn.QueryRowContext(ctx, query, user.Email, normalizedEmail(user), user.Name).Scan(&id)
 	if err != nil {
-		return 0, errors.Join(ErrDatabase, err)
+		return 0, recordError(ctx, span, wrapError(err))
 	}
 
 	return id, nil
 }
 
 func (r *Repository) Get(ctx context.Context, id int) (*models.User, error) {
-	query := "SELECT email, name FROM users WHERE id = $1"
+	query := `
+	SELECT email, COALESCE(email_normalized, ''), name,
+		CASE WHEN pending_email_expires_at > now() THEN pending_email ELSE '' END,
+		totp_secret IS NOT NULL
+	FROM users WHERE id = $1`
+	ctx, span := startSpan(ctx, "Get", query)
+	defer span.End()
+
 	user := &models.User{Id: id}
 
 	row := r.conn.QueryRowContext(ctx, query, id)
-	if err := row.Scan(&user.Email, &user.Name); err != nil {
+	if err := row.Scan(&user.Email, &user.NormalizedEmail, &user.Name, &user.PendingEmail, &user.MFAEnabled); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.Join(ErrDatabase, ErrNotFound)
 		}
 
-		return nil, errors.Join(ErrDatabase, err)
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return user, nil
+}
+
+func (r *Repository) GetByEmail(ctx context.Context, normalizedEmail string) (*models.User, error) {
+	query := "SELECT id, email, name FROM users WHERE email_normalized = $1"
+	ctx, span := startSpan(ctx, "GetByEmail", query)
+	defer span.End()
+
+	user := &models.User{}
+
+	row := r.conn.QueryRowContext(ctx, query, normalizedEmail)
+	if err := row.Scan(&user.Id, &user.Email, &user.Name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
 	}
 
 	return user, nil
 }
 
-func (r *Repository) UpdateEmail(ctx context.Context, id int, email string) error {
-	query := "UPDATE users SET email = $1 WHERE id = $2"
-	res, err := r.conn.ExecContext(ctx, query, email, id)
+func (r *Repository) GetMany(ctx context.Context, ids []int) ([]*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id = ANY($1)"
+	ctx, span := startSpan(ctx, "GetMany", query)
+	defer span.End()
+
+	rows, err := r.conn.QueryContext(ctx, query, pq.Array(ids))
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows, len(ids))
+	return users, recordError(ctx, span, err)
+}
+
+func (r *Repository) List(ctx context.Context, afterId int, limit int) ([]*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE id > $1 ORDER BY id LIMIT $2"
+	ctx, span := startSpan(ctx, "List", query)
+	defer span.End()
+
+	rows, err := r.conn.QueryContext(ctx, query, afterId, limit)
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows, limit)
+	return users, recordError(ctx, span, err)
+}
+
+func (r *Repository) ListOffset(ctx context.Context, offset int, limit int) ([]*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users ORDER BY id OFFSET $1 LIMIT $2"
+	ctx, span := startSpan(ctx, "ListOffset", query)
+	defer span.End()
+
+	rows, err := r.conn.QueryContext(ctx, query, offset, limit)
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows, limit)
+	return users, recordError(ctx, span, err)
+}
+
+func (r *Repository) Count(ctx context.Context) (int, error) {
+	query := "SELECT COUNT(*) FROM users"
+	ctx, span := startSpan(ctx, "Count", query)
+	defer span.End()
+
+	count := 0
+	err := r.conn.QueryRowContext(ctx, query).Scan(&count)
+	if err != nil {
+		return 0, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return count, nil
+}
+
+func (r *Repository) UpdateEmail(ctx context.Context, id int, email, normalizedEmail string) error {
+	// As in Update, a new mailbox drops a pending change.
+	query := `
+	UPDATE users SET
+		email = $1,
+		email_normalized = $2,
+		pending_email = CASE WHEN email_normalized = $2 THEN pending_email END,
+		pending_email_normalized = CASE WHEN email_normalized = $2 THEN pending_email_normalized END,
+		pending_email_expires_at = CASE WHEN email_normalized = $2 THEN pending_email_expires_at END
+	WHERE id = $3`
+	ctx, span := startSpan(ctx, "UpdateEmail", query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, email, normalizedEmail, id)
+	if err != nil {
+		return recordError(ctx, span, wrapError(err))
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if affected == 0 {
+		return errors.Join(ErrDatabase, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) RequestEmailChange(ctx context.Context, id int, email, normalizedEmail string, expiresAt time.Time) error {
+	query := `
+	UPDATE users SET pending_email = $1, pending_email_normalized = $2, pending_email_expires_at = $3
+	WHERE id = $4`
+	ctx, span := startSpan(ctx, "RequestEmailChange", query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, email, normalizedEmail, expiresAt, id)
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if affected == 0 {
+		return errors.Join(ErrDatabase, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (r *Repository) ConfirmEmailChange(ctx context.Context, id int, normalizedEmail string) (*models.User, error) {
+	// The subquery locks the row and still sees the email being replaced.
+	query := `
+	UPDATE users u SET
+		email = u.pending_email,
+		email_normalized = u.pending_email_normalized,
+		pending_email = NULL,
+		pending_email_normalized = NULL,
+		pending_email_expires_at = NULL,
+		email_changed_at = now()
+	FROM (SELECT id, email, email_normalized FROM users WHERE id = $1 FOR UPDATE) old
+	WHERE u.id = old.id AND u.pending_email_normalized = $2 AND u.pending_email_expires_at > now()
+	RETURNING old.email, COALESCE(old.email_normalized, ''), u.name`
+	ctx, span := startSpan(ctx, "ConfirmEmailChange", query)
+	defer span.End()
+
+	previous := &models.User{Id: id}
+
+	row := r.conn.QueryRowContext(ctx, query, id, normalizedEmail)
+	if err := row.Scan(&previous.Email, &previous.NormalizedEmail, &previous.Name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return nil, recordError(ctx, span, wrapError(err))
+	}
+
+	return previous, nil
+}
+
+func (r *Repository) RevertEmailChange(ctx context.Context, id int, previous *models.User, issuedAt time.Time) error {
+	// One statement, so whoever held the account cannot keep a session or
+	// reset link past the revert.
+	query := `
+	WITH reverted AS (
+		UPDATE users SET
+			email = $1,
+			email_normalized = $2,
+			pending_email = NULL,
+			pending_email_normalized = NULL,
+			pending_email_expires_at = NULL,
+			password_reset_hash = NULL,
+			password_reset_expires_at = NULL,
+			email_changed_at = NULL
+		WHERE id = $3 AND email_normalized <> $2 AND NOT EXISTS (
+			SELECT 1 FROM email_reverts WHERE user_id = $3 AND $4 BETWEEN issued_at AND reverted_at
+		)
+		RETURNING id
+	), recorded AS (
+		INSERT INTO email_reverts (user_id, issued_at) SELECT id, $4 FROM reverted
+	), sessions AS (
+		DELETE FROM sessions s USING reverted WHERE s.user_id = reverted.id
+	), challenges AS (
+		DELETE FROM mfa_challenges c USING reverted WHERE c.user_id = reverted.id
+	)
+	SELECT count(*) FROM reverted`
+	ctx, span := startSpan(ctx, "RevertEmailChange", query)
+	defer span.End()
+
+	var affected int
+	err := r.conn.QueryRowContext(ctx, query, previous.Email, normalizedEmail(previous), id, issuedAt).Scan(&affected)
+	if err != nil {
+		return recordError(ctx, span, wrapError(err))
 	}
 
 	if affected == 0 {
@@ -101,15 +327,27 @@ func (r *Repository) UpdateEmail(ctx context.Context, id int, email string) erro
 }
 
 func (r *Repository) Update(ctx context.Context, user *models.User) error {
-	query := "UPDATE users SET email = $1, name = $2 WHERE id = $3"
-	res, err := r.conn.ExecContext(ctx, query, user.Email, user.Name, user.Id)
+	// A new mailbox makes a pending change moot; the CASEs see the old row.
+	query := `
+	UPDATE users SET
+		email = $1,
+		email_normalized = $2,
+		name = $3,
+		pending_email = CASE WHEN email_normalized = $2 THEN pending_email END,
+		pending_email_normalized = CASE WHEN email_normalized = $2 THEN pending_email_normalized END,
+		pending_email_expires_at = CASE WHEN email_normalized = $2 THEN pending_email_expires_at END
+	WHERE id = $4`
+	ctx, span := startSpan(ctx, "Update", query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, user.Email, normalizedEmail(user), user.Name, user.Id)
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return recordError(ctx, span, wrapError(err))
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
 	}
 
 	if affected == 0 {
@@ -121,15 +359,17 @@ func (r *Repository) Update(ctx context.Context, user *models.User) error {
 
 func (r *Repository) Delete(ctx context.Context, id int) error {
 	query := `DELETE FROM users WHERE id = $1`
+	ctx, span := startSpan(ctx, "Delete", query)
+	defer span.End()
 
 	res, err := r.conn.ExecContext(ctx, query, id)
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return errors.Join(ErrDatabase, err)
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
 	}
 
 	if affected == 0 {
@@ -139,24 +379,289 @@ func (r *Repository) Delete(ctx context.Context, id int) error {
 	return nil
 }
 
-func createDatabase(db *sql.DB) error {
-	var exists bool
-	query := `SELECT EXISTS (SELECT FROM pg_database WHERE datname = $1)`
-	err := db.QueryRow(query, dbName).Scan(&exists)
+func (r *Repository) Import(ctx context.Context, records []repository.ImportRecord, opts repository.ImportOptions) ([]repository.ImportOutcome, error) {
+	// The first line wins when a batch repeats an email.
+	query := `
+	INSERT INTO users(email, email_normalized, name)
+	SELECT DISTINCT ON (email_normalized) email, email_normalized, name FROM users_import ORDER BY email_normalized, line
+	ON CONFLICT (email_normalized) DO NOTHING
+	RETURNING email_normalized, true`
+	if opts.Upsert {
+		query = `
+	INSERT INTO users(email, email_normalized, name)
+	SELECT DISTINCT ON (email_normalized) email, email_normalized, name FROM users_import ORDER BY email_normalized, line
+	ON CONFLICT (email_normalized) DO UPDATE SET name = EXCLUDED.name
+	RETURNING email_normalized, xmax = 0`
+	}
+
+	ctx, span := startSpan(ctx, "Import", query)
+	defer span.End()
+
+	tx, err := r.conn.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
 	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMP TABLE users_import (line INTEGER, email TEXT, email_normalized TEXT, name TEXT) ON COMMIT DROP`)
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("users_import", "line", "email", "email_normalized", "name"))
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	for _, record := range records {
+		_, err = stmt.ExecContext(ctx, record.Line, record.User.Email, normalizedEmail(&record.User), record.User.Name)
+		if err != nil {
+			stmt.Close()
+			return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+		}
+	}
+
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if err := stmt.Close(); err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer rows.Close()
+
+	written := make(map[string]bool, len(records))
+	for rows.Next() {
+		var email string
+		var inserted bool
+		if err := rows.Scan(&email, &inserted); err != nil {
+			return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+		}
+
+		written[email] = inserted
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	outcomes := make([]repository.ImportOutcome, len(records))
+	seen := make(map[string]bool, len(records))
+	for i, record := range records {
+		email := normalizedEmail(&record.User)
+		inserted, ok := written[email]
+
+		switch {
+		case seen[email]:
+			outcomes[i] = repository.ImportDuplicate
+		case !ok:
+			outcomes[i] = repository.ImportConflict
+		case inserted:
+			outcomes[i] = repository.ImportInserted
+		default:
+			outcomes[i] = repository.ImportUpdated
+		}
+
+		seen[email] = true
+	}
+
+	if opts.DryRun {
+		return outcomes, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return outcomes, nil
+}
+
+func (r *Repository) CreateMany(ctx context.Context, users []*models.User, atomic bool) ([]repository.BatchResult, error) {
+	query := `INSERT INTO users(email, email_normalized, name) VALUES ($1, $2, $3) RETURNING id`
+	ctx, span := startSpan(ctx, "CreateMany", query)
+	defer span.End()
+
+	results, err := r.batch(ctx, len(users), atomic, func(tx *sql.Tx, i int) (int, error) {
+		id := 0
+		err := tx.QueryRowContext(ctx, query, users[i].Email, normalizedEmail(users[i]), users[i].Name).Scan(&id)
+		if err != nil {
+			return 0, wrapError(err)
+		}
+
+		return id, nil
+	})
+
+	return results, recordError(ctx, span, err)
+}
+
+func (r *Repository) DeleteMany(ctx context.Context, ids []int, atomic bool) ([]repository.BatchResult, error) {
+	query := `DELETE FROM users WHERE id = $1`
+	ctx, span := startSpan(ctx, "DeleteMany", query)
+	defer span.End()
+
+	results, err := r.batch(ctx, len(ids), atomic, func(tx *sql.Tx, i int) (int, error) {
+		res, err := tx.ExecContext(ctx, query, ids[i])
+		if err != nil {
+			return 0, errors.Join(ErrDatabase, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Join(ErrDatabase, err)
+		}
+
+		if affected == 0 {
+			return 0, ErrNotFound
+		}
+
+		return ids[i], nil
+	})
+
+	return results, recordError(ctx, span, err)
+}
+
+// batch runs item for indexes 0..n-1 in one transaction, each behind a
+// savepoint so a failed item does not abort the ones after it. Failed items
+// are rolled back individually; in atomic mode any failure rolls back the
+// whole transaction and the remaining results become ErrBatchAborted.
+func (r *Repository) batch(ctx context.Context, n int, atomic bool, item func(tx *sql.Tx, i int) (int, error)) ([]repository.BatchResult, error) {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(ErrDatabase, err)
+	}
+	defer tx.Rollback()
+
+	results := make([]repository.BatchResult, n)
+	failed := false
+	for i := 0; i < n; i++ {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+			return nil, errors.Join(ErrDatabase, err)
+		}
+
+		id, err := item(tx, i)
+		if err != nil {
+			results[i].Err = err
+			failed = true
+
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); err != nil {
+				return nil, errors.Join(ErrDatabase, err)
+			}
+
+			continue
+		}
+
+		results[i].Id = id
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item"); err != nil {
+			return nil, errors.Join(ErrDatabase, err)
+		}
+	}
+
+	if atomic && failed {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = repository.BatchResult{Err: repository.ErrBatchAborted}
+			}
+		}
+
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Join(ErrDatabase, err)
+	}
+
+	return results, nil
+}
+
+func (r *Repository) Export(ctx context.Context, filter repository.ExportFilter, fn func(*models.User) error) error {
+	// The cursor keeps the result set on the server; only one fetch is held in memory.
+	query := `
+	DECLARE users_export NO SCROLL CURSOR FOR
+	SELECT ` + userColumns + ` FROM users
+	WHERE id > $1 AND ($2::text = '' OR lower(split_part(email, '@', 2)) = lower($2))
+	ORDER BY id`
+
+	ctx, span := startSpan(ctx, "Export", query)
+	defer span.End()
+
+	tx, err := r.conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, filter.AfterId, filter.EmailDomain)
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM users_export", exportFetchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return recordError(ctx, span, errors.Join(ErrDatabase, err))
+		}
+
+		users, err := scanUsers(rows, exportFetchSize)
+		rows.Close()
+		if err != nil {
+			return recordError(ctx, span, err)
+		}
+
+		span.AddEvent("fetch", trace.WithAttributes(attribute.Int("rows", len(users))))
+
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+func scanUsers(rows *sql.Rows, capacity int) ([]*models.User, error) {
+	users := make([]*models.User, 0, capacity)
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(&user.Id, &user.Email, &user.Name, &user.PendingEmail); err != nil {
+			return nil, errors.Join(ErrDatabase, err)
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(ErrDatabase, err)
+	}
+
+	return users, nil
+}
+
+// normalizedEmail falls back to the lowercased email for users that did not
+// go through validation, as the migration does for existing users.
+func normalizedEmail(user *models.User) string {
+	if user.NormalizedEmail != "" {
+		return user.NormalizedEmail
+	}
+
+	return strings.ToLower(strings.TrimSpace(user.Email))
+}
 
-	if exists {
-		return nil
+func wrapError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return errors.Join(ErrDatabase, repository.ErrAlreadyExists, err)
 	}
 
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email TEXT UNIQUE NOT NULL,
-		name TEXT NOT NULL
-	);
-`)
-	return err
+	return errors.Join(ErrDatabase, err)
 }
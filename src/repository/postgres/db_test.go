@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
 
+	"go-user-service/src/mailer"
+	"go-user-service/src/ratelimit"
 	"go-user-service/src/repository"
 	"go-user-service/src/repository/models"
 )
@@ -55,6 +58,17 @@ func TestPostgres(t *testing.T) {
 	})
 	require.NoError(t, err, err)
 
+	t.Run("Migrations", func(t *testing.T) {
+		status, err := db.MigrationStatus(ctx)
+		require.NoError(t, err, err)
+		require.True(t, status.UpToDate())
+		require.Equal(t, status.Latest, status.Current)
+
+		applied, err := db.Migrate(ctx)
+		require.NoError(t, err, err)
+		require.Empty(t, applied)
+	})
+
 	t.Run("Create", func(t *testing.T) {
 		newValidUser := &models.User{
 			Id:    0,
@@ -74,6 +88,12 @@ func TestPostgres(t *testing.T) {
 			require.Equal(t, id, 0)
 		})
 
+		t.Run("SameNormalizedEmail", func(t *testing.T) {
+			user := &models.User{Email: "Test@Email.com", Name: "testname", NormalizedEmail: "test@email.com"}
+			_, err := db.Create(ctx, user)
+			require.ErrorIs(t, err, repository.ErrAlreadyExists)
+		})
+
 		t.Run("EmptyName", func(t *testing.T) {
 			user := &models.User{
 				Email: "test2@email.com",
@@ -133,7 +153,7 @@ func TestPostgres(t *testing.T) {
 
 		newEmail := "new@email.com"
 		t.Run("ValidEmail", func(t *testing.T) {
-			err := db.UpdateEmail(ctx, validUser.Id, newEmail)
+			err := db.UpdateEmail(ctx, validUser.Id, newEmail, newEmail)
 			require.NoError(t, err, err)
 
 			u, err := db.Get(ctx, validUser.Id)
@@ -144,7 +164,7 @@ func TestPostgres(t *testing.T) {
 		})
 
 		t.Run("BadIdEmail", func(t *testing.T) {
-			err := db.UpdateEmail(ctx, 0, newEmail)
+			err := db.UpdateEmail(ctx, 0, newEmail, newEmail)
 			require.Error(t, err, ErrNotFound.Error())
 		})
 
@@ -165,11 +185,404 @@ func TestPostgres(t *testing.T) {
 		})
 
 		t.Run("BadIdEmail", func(t *testing.T) {
-			err := db.UpdateEmail(ctx, 0, newEmail)
+			err := db.UpdateEmail(ctx, 0, newEmail, newEmail)
 			require.Error(t, err, ErrNotFound.Error())
 		})
 	})
 
+	t.Run("Import", func(t *testing.T) {
+		records := []repository.ImportRecord{
+			{Line: 1, User: models.User{Email: "import1@email.com", Name: "import1"}},
+			{Line: 2, User: models.User{Email: "toget@email.com", Name: "conflict"}},
+			{Line: 3, User: models.User{Email: "import1@email.com", Name: "duplicate"}},
+		}
+
+		t.Run("DryRun", func(t *testing.T) {
+			outcomes, err := db.Import(ctx, records, repository.ImportOptions{DryRun: true})
+			require.NoError(t, err, err)
+			require.Equal(t, []repository.ImportOutcome{
+				repository.ImportInserted, repository.ImportConflict, repository.ImportDuplicate,
+			}, outcomes)
+
+			_, err = db.GetByEmail(ctx, "import1@email.com")
+			require.True(t, errors.Is(err, ErrNotFound))
+		})
+
+		t.Run("Upsert", func(t *testing.T) {
+			outcomes, err := db.Import(ctx, records, repository.ImportOptions{Upsert: true})
+			require.NoError(t, err, err)
+			require.Equal(t, []repository.ImportOutcome{
+				repository.ImportInserted, repository.ImportUpdated, repository.ImportDuplicate,
+			}, outcomes)
+
+			u, err := db.GetByEmail(ctx, "toget@email.com")
+			require.NoError(t, err, err)
+			require.Equal(t, "conflict", u.Name)
+		})
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		t.Run("CreateAtomic", func(t *testing.T) {
+			results, err := db.CreateMany(ctx, []*models.User{
+				{Email: "batch1@email.com", Name: "batch1"},
+				{Email: "toget@email.com", Name: "conflict"},
+			}, true)
+			require.NoError(t, err, err)
+			require.ErrorIs(t, results[0].Err, repository.ErrBatchAborted)
+			require.ErrorIs(t, results[1].Err, repository.ErrAlreadyExists)
+
+			_, err = db.GetByEmail(ctx, "batch1@email.com")
+			require.True(t, errors.Is(err, ErrNotFound))
+		})
+
+		var created []int
+		t.Run("CreatePartial", func(t *testing.T) {
+			results, err := db.CreateMany(ctx, []*models.User{
+				{Email: "batch1@email.com", Name: "batch1"},
+				{Email: "toget@email.com", Name: "conflict"},
+				{Email: "batch2@email.com", Name: "batch2"},
+			}, false)
+			require.NoError(t, err, err)
+			require.NoError(t, results[0].Err)
+			require.ErrorIs(t, results[1].Err, repository.ErrAlreadyExists)
+			require.NoError(t, results[2].Err)
+			created = []int{results[0].Id, results[2].Id}
+
+			u, err := db.Get(ctx, results[2].Id)
+			require.NoError(t, err, err)
+			require.Equal(t, "batch2@email.com", u.Email)
+		})
+
+		t.Run("DeleteAtomic", func(t *testing.T) {
+			results, err := db.DeleteMany(ctx, []int{created[0], 0}, true)
+			require.NoError(t, err, err)
+			require.ErrorIs(t, results[0].Err, repository.ErrBatchAborted)
+			require.ErrorIs(t, results[1].Err, ErrNotFound)
+
+			_, err = db.Get(ctx, created[0])
+			require.NoError(t, err, err)
+		})
+
+		t.Run("DeletePartial", func(t *testing.T) {
+			results, err := db.DeleteMany(ctx, []int{created[0], 0, created[1]}, false)
+			require.NoError(t, err, err)
+			require.NoError(t, results[0].Err)
+			require.ErrorIs(t, results[1].Err, ErrNotFound)
+			require.NoError(t, results[2].Err)
+
+			users, err := db.GetMany(ctx, created)
+			require.NoError(t, err, err)
+			require.Empty(t, users)
+		})
+	})
+
+	t.Run("PendingEmail", func(t *testing.T) {
+		id, err := db.Create(ctx, &models.User{Email: "pending@email.com", Name: "Pending"})
+		require.NoError(t, err, err)
+
+		err = db.RequestEmailChange(ctx, id, "New@email.com", "new@email.com", time.Now().Add(time.Hour))
+		require.NoError(t, err, err)
+
+		users, err := db.GetMany(ctx, []int{id})
+		require.NoError(t, err, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "New@email.com", users[0].PendingEmail)
+
+		users, err = db.List(ctx, id-1, 1)
+		require.NoError(t, err, err)
+		require.Equal(t, "New@email.com", users[0].PendingEmail)
+
+		err = db.RequestEmailChange(ctx, id, "New@email.com", "new@email.com", time.Now().Add(-time.Hour))
+		require.NoError(t, err, err)
+
+		users, err = db.GetMany(ctx, []int{id})
+		require.NoError(t, err, err)
+		require.Empty(t, users[0].PendingEmail)
+
+		// Updating the name keeps a pending change, a new email drops it.
+		err = db.RequestEmailChange(ctx, id, "New@email.com", "new@email.com", time.Now().Add(time.Hour))
+		require.NoError(t, err, err)
+
+		err = db.Update(ctx, &models.User{Id: id, Email: "pending@email.com", Name: "Renamed"})
+		require.NoError(t, err, err)
+		user, err := db.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.Equal(t, "New@email.com", user.PendingEmail)
+
+		err = db.Update(ctx, &models.User{Id: id, Email: "provisioned@email.com", Name: "Renamed"})
+		require.NoError(t, err, err)
+		user, err = db.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.Empty(t, user.PendingEmail)
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		var all []*models.User
+		err := db.Export(ctx, repository.ExportFilter{}, func(u *models.User) error {
+			all = append(all, u)
+			return nil
+		})
+		require.NoError(t, err, err)
+
+		count, err := db.Count(ctx)
+		require.NoError(t, err, err)
+		require.Len(t, all, count)
+		for i := 1; i < len(all); i++ {
+			require.Less(t, all[i-1].Id, all[i].Id)
+		}
+
+		var filtered []*models.User
+		err = db.Export(ctx, repository.ExportFilter{EmailDomain: "EMAIL.com", AfterId: all[0].Id}, func(u *models.User) error {
+			filtered = append(filtered, u)
+			return nil
+		})
+		require.NoError(t, err, err)
+		require.NotEmpty(t, filtered)
+		for _, u := range filtered {
+			require.Greater(t, u.Id, all[0].Id)
+			require.True(t, strings.HasSuffix(u.Email, "@email.com"))
+		}
+
+		stop := errors.New("stop")
+		err = db.Export(ctx, repository.ExportFilter{}, func(*models.User) error { return stop })
+		require.ErrorIs(t, err, stop)
+	})
+
+	t.Run("RateLimit", func(t *testing.T) {
+		store := db.RateLimitStore()
+		limit := ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 2}
+
+		for i, allowed := range []bool{true, true, false} {
+			res, err := store.Take(ctx, "test|ip:127.0.0.1", limit)
+			require.NoError(t, err, err)
+			require.Equal(t, allowed, res.Allowed, i)
+		}
+
+		res, err := store.Take(ctx, "test|ip:127.0.0.2", limit)
+		require.NoError(t, err, err)
+		require.True(t, res.Allowed)
+		require.Equal(t, 1, res.Remaining)
+	})
+
+	t.Run("MailQueue", func(t *testing.T) {
+		store := db.MailQueueStore()
+		msg := mailer.Message{To: "queued@email.com", Subject: "Hello", Text: "Hi\n"}
+		require.NoError(t, store.Enqueue(ctx, msg))
+
+		claimed, err := store.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, msg, claimed[0].Message)
+		require.Equal(t, 1, claimed[0].Attempts)
+
+		// Leased messages are not claimed again.
+		again, err := store.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err, err)
+		require.Empty(t, again)
+
+		require.NoError(t, store.Retry(ctx, claimed[0].Id, time.Now().Add(-time.Second), "busy"))
+		again, err = store.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err, err)
+		require.Len(t, again, 1)
+		require.Equal(t, 2, again[0].Attempts)
+
+		// Failed messages stay, without their body, but are never claimed.
+		require.NoError(t, store.Fail(ctx, again[0].Id, "busy"))
+		require.NoError(t, store.Retry(ctx, again[0].Id, time.Now().Add(-time.Second), "busy"))
+		again, err = store.Claim(ctx, 10, time.Minute)
+		require.NoError(t, err, err)
+		require.Empty(t, again)
+
+		var body sql.NullString
+		err = db.conn.QueryRowContext(ctx, "SELECT message->>'text' FROM mail_queue WHERE id = $1", claimed[0].Id).Scan(&body)
+		require.NoError(t, err, err)
+		require.False(t, body.Valid)
+
+		purged, err := store.Purge(ctx, time.Now().Add(-time.Hour))
+		require.NoError(t, err, err)
+		require.Zero(t, purged)
+
+		purged, err = store.Purge(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err, err)
+		require.Equal(t, int64(1), purged)
+	})
+
+	t.Run("RevertEmail", func(t *testing.T) {
+		id, err := db.Create(ctx, &models.User{Email: "revert@email.com", Name: "Revert"})
+		require.NoError(t, err, err)
+
+		original := &models.User{Email: "revert@email.com", NormalizedEmail: "revert@email.com"}
+		intermediate := &models.User{Email: "second@email.com", NormalizedEmail: "second@email.com"}
+		originalIssued := time.Now().Add(-time.Hour).Truncate(time.Second)
+		intermediateIssued := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+		// Nothing to revert while the email still is the original.
+		err = db.RevertEmailChange(ctx, id, original, originalIssued)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		changedAt, err := db.EmailChangedAt(ctx, id)
+		require.NoError(t, err, err)
+		require.True(t, changedAt.IsZero())
+
+		err = db.RequestEmailChange(ctx, id, "third@email.com", "third@email.com", time.Now().Add(time.Hour))
+		require.NoError(t, err, err)
+		_, err = db.ConfirmEmailChange(ctx, id, "third@email.com")
+		require.NoError(t, err, err)
+		changedAt, err = db.EmailChangedAt(ctx, id)
+		require.NoError(t, err, err)
+		require.WithinDuration(t, time.Now(), changedAt, time.Minute)
+
+		require.NoError(t, db.CreateSession(ctx, &repository.Session{
+			UserId:           id,
+			TokenHash:        []byte("revert-token"),
+			RefreshHash:      []byte("revert-refresh"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		}))
+		require.NoError(t, db.SetPasswordReset(ctx, id, []byte("revert-reset"), time.Now().Add(time.Hour)))
+
+		// The original address can revert although the email changed twice.
+		require.NoError(t, db.RevertEmailChange(ctx, id, original, originalIssued))
+
+		user, err := db.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.Equal(t, "revert@email.com", user.Email)
+		changedAt, err = db.EmailChangedAt(ctx, id)
+		require.NoError(t, err, err)
+		require.True(t, changedAt.IsZero(), "a revert leaves no change to wait for")
+
+		_, err = db.GetSessionUser(ctx, []byte("revert-token"))
+		require.ErrorIs(t, err, ErrNotFound)
+		_, err = db.ResetPassword(ctx, []byte("revert-reset"), "hash")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		// The link of the address it replaced is void, and so is a replay.
+		err = db.RevertEmailChange(ctx, id, intermediate, intermediateIssued)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, db.UpdateEmail(ctx, id, "fourth@email.com", "fourth@email.com"))
+		err = db.RevertEmailChange(ctx, id, original, originalIssued)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		// Links issued after the revert work.
+		require.NoError(t, db.RevertEmailChange(ctx, id, original, time.Now().Add(time.Second)))
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		id, err := db.Create(ctx, &models.User{Email: "auth@email.com", Name: "auth"})
+		require.NoError(t, err, err)
+
+		hash, err := db.GetPasswordHash(ctx, id)
+		require.NoError(t, err, err)
+		require.Empty(t, hash)
+
+		session := &repository.Session{
+			UserId:           id,
+			TokenHash:        []byte("token"),
+			RefreshHash:      []byte("refresh"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		}
+		require.NoError(t, db.CreateSession(ctx, session))
+
+		u, err := db.GetSessionUser(ctx, []byte("token"))
+		require.NoError(t, err, err)
+		require.Equal(t, id, u.Id)
+
+		next := &repository.Session{
+			TokenHash:        []byte("token2"),
+			RefreshHash:      []byte("refresh2"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		}
+		userId, err := db.RefreshSession(ctx, []byte("refresh"), next)
+		require.NoError(t, err, err)
+		require.Equal(t, id, userId)
+
+		_, err = db.GetSessionUser(ctx, []byte("token"))
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, db.SetPasswordReset(ctx, id, []byte("expired"), time.Now().Add(-time.Minute)))
+		_, err = db.ResetPassword(ctx, []byte("expired"), "hash")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, db.SetPasswordReset(ctx, id, []byte("reset"), time.Now().Add(time.Hour)))
+		u, err = db.ResetPassword(ctx, []byte("reset"), "hash")
+		require.NoError(t, err, err)
+		require.Equal(t, "auth@email.com", u.Email)
+
+		_, err = db.ResetPassword(ctx, []byte("reset"), "other")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		hash, err = db.GetPasswordHash(ctx, id)
+		require.NoError(t, err, err)
+		require.Equal(t, "hash", hash)
+
+		// The reset ended the session.
+		_, err = db.GetSessionUser(ctx, []byte("token2"))
+		require.ErrorIs(t, err, ErrNotFound)
+		require.ErrorIs(t, db.DeleteSession(ctx, []byte("token2")), ErrNotFound)
+	})
+
+	t.Run("MFA", func(t *testing.T) {
+		id, err := db.Create(ctx, &models.User{Email: "mfa@email.com", Name: "mfa"})
+		require.NoError(t, err, err)
+
+		require.NoError(t, db.SetPendingTOTP(ctx, id, "PENDING"))
+		require.ErrorIs(t, db.EnableTOTP(ctx, id, "OTHER", 1, nil), ErrNotFound)
+
+		session := &repository.Session{
+			UserId:           id,
+			TokenHash:        []byte("mfa-token"),
+			RefreshHash:      []byte("mfa-refresh"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		}
+		require.NoError(t, db.CreateSession(ctx, session))
+
+		require.NoError(t, db.EnableTOTP(ctx, id, "PENDING", 10, [][]byte{[]byte("code1"), []byte("code2")}))
+
+		mfa, err := db.GetTOTP(ctx, id)
+		require.NoError(t, err, err)
+		require.Equal(t, &repository.TOTP{Secret: "PENDING", LastStep: 10}, mfa)
+
+		u, err := db.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.True(t, u.MFAEnabled)
+
+		// Enabling ended the session.
+		_, err = db.GetSessionUser(ctx, []byte("mfa-token"))
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.ErrorIs(t, db.UseTOTPStep(ctx, id, 10), ErrNotFound)
+		require.NoError(t, db.UseTOTPStep(ctx, id, 11))
+
+		require.NoError(t, db.UseRecoveryCode(ctx, id, []byte("code1")))
+		require.ErrorIs(t, db.UseRecoveryCode(ctx, id, []byte("code1")), ErrNotFound)
+
+		require.NoError(t, db.CreateMFAChallenge(ctx, []byte("challenge"), id, time.Now().Add(time.Minute)))
+		for range 2 {
+			userId, err := db.AttemptMFAChallenge(ctx, []byte("challenge"), 2)
+			require.NoError(t, err, err)
+			require.Equal(t, id, userId)
+		}
+
+		_, err = db.AttemptMFAChallenge(ctx, []byte("challenge"), 2)
+		require.ErrorIs(t, err, ErrNotFound)
+		require.NoError(t, db.DeleteMFAChallenge(ctx, []byte("challenge")))
+
+		require.NoError(t, db.CreateMFAChallenge(ctx, []byte("expired"), id, time.Now().Add(-time.Minute)))
+		_, err = db.AttemptMFAChallenge(ctx, []byte("expired"), 2)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, db.DisableTOTP(ctx, id))
+		require.ErrorIs(t, db.UseRecoveryCode(ctx, id, []byte("code2")), ErrNotFound)
+
+		u, err = db.Get(ctx, id)
+		require.NoError(t, err, err)
+		require.False(t, u.MFAEnabled)
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		validUser := &models.User{
 			Id:    0,
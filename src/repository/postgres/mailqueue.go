@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go-user-service/src/mailer"
+)
+
+var _ mailer.QueueStore = (*MailQueueStore)(nil)
+
+// claimMailQuery leases due messages by moving their next attempt past the
+// lease; SKIP LOCKED lets replicas claim disjoint batches.
+const claimMailQuery = `
+UPDATE mail_queue SET attempts = attempts + 1, next_attempt_at = now() + make_interval(secs => $2)
+WHERE id IN (
+	SELECT id FROM mail_queue
+	WHERE failed_at IS NULL AND next_attempt_at <= now()
+	ORDER BY next_attempt_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, message, attempts`
+
+// MailQueueStore keeps outgoing email in the database, so it survives
+// restarts and any replica can send it.
+type MailQueueStore struct {
+	conn *sql.DB
+}
+
+func (r *Repository) MailQueueStore() *MailQueueStore {
+	return &MailQueueStore{conn: r.conn}
+}
+
+func (s *MailQueueStore) Enqueue(ctx context.Context, msg mailer.Message) error {
+	query := "INSERT INTO mail_queue (message) VALUES ($1)"
+	ctx, span := startSpan(ctx, "MailEnqueue", query)
+	defer span.End()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return recordError(ctx, span, err)
+	}
+
+	if _, err := s.conn.ExecContext(ctx, query, data); err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return nil
+}
+
+func (s *MailQueueStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]mailer.QueuedMessage, error) {
+	ctx, span := startSpan(ctx, "MailClaim", claimMailQuery)
+	defer span.End()
+
+	rows, err := s.conn.QueryContext(ctx, claimMailQuery, limit, lease.Seconds())
+	if err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+	defer rows.Close()
+
+	var claimed []mailer.QueuedMessage
+	for rows.Next() {
+		var queued mailer.QueuedMessage
+		var data []byte
+		if err := rows.Scan(&queued.Id, &data, &queued.Attempts); err != nil {
+			return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+		}
+
+		if err := json.Unmarshal(data, &queued.Message); err != nil {
+			return nil, recordError(ctx, span, err)
+		}
+
+		claimed = append(claimed, queued)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return claimed, nil
+}
+
+func (s *MailQueueStore) Delete(ctx context.Context, id int64) error {
+	return s.exec(ctx, "MailDelete", "DELETE FROM mail_queue WHERE id = $1", id)
+}
+
+func (s *MailQueueStore) Retry(ctx context.Context, id int64, at time.Time, lastErr string) error {
+	return s.exec(ctx, "MailRetry", "UPDATE mail_queue SET next_attempt_at = $2, last_error = $3 WHERE id = $1", id, at, lastErr)
+}
+
+func (s *MailQueueStore) Fail(ctx context.Context, id int64, lastErr string) error {
+	query := "UPDATE mail_queue SET failed_at = now(), last_error = $2, message = message - 'text' - 'html' WHERE id = $1"
+	return s.exec(ctx, "MailFail", query, id, lastErr)
+}
+
+func (s *MailQueueStore) Purge(ctx context.Context, failedBefore time.Time) (int64, error) {
+	query := "DELETE FROM mail_queue WHERE failed_at < $1"
+	ctx, span := startSpan(ctx, "MailPurge", query)
+	defer span.End()
+
+	res, err := s.conn.ExecContext(ctx, query, failedBefore)
+	if err != nil {
+		return 0, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return n, nil
+}
+
+func (s *MailQueueStore) exec(ctx context.Context, name, query string, args ...any) error {
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	if _, err := s.conn.ExecContext(ctx, query, args...); err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return nil
+}
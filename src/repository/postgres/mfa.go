@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"go-user-service/src/repository"
+)
+
+func (r *Repository) GetTOTP(ctx context.Context, id int) (*repository.TOTP, error) {
+	query := "SELECT COALESCE(totp_secret, ''), COALESCE(totp_pending_secret, ''), totp_last_step FROM users WHERE id = $1"
+	ctx, span := startSpan(ctx, "GetTOTP", query)
+	defer span.End()
+
+	totp := &repository.TOTP{}
+
+	row := r.conn.QueryRowContext(ctx, query, id)
+	if err := row.Scan(&totp.Secret, &totp.PendingSecret, &totp.LastStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return nil, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return totp, nil
+}
+
+func (r *Repository) SetPendingTOTP(ctx context.Context, id int, secret string) error {
+	return r.execOne(ctx, "SetPendingTOTP", "UPDATE users SET totp_pending_secret = $1 WHERE id = $2", secret, id)
+}
+
+func (r *Repository) EnableTOTP(ctx context.Context, id int, pendingSecret string, step int64, recoveryCodeHashes [][]byte) error {
+	// One statement, so sessions that skipped the second factor cannot
+	// outlive its activation.
+	query := `
+	WITH enabled AS (
+		UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_last_step = $3
+		WHERE id = $1 AND totp_pending_secret = $2
+		RETURNING id
+	), old_codes AS (
+		DELETE FROM mfa_recovery_codes c USING enabled WHERE c.user_id = enabled.id
+	), codes AS (
+		INSERT INTO mfa_recovery_codes (user_id, code_hash)
+		SELECT enabled.id, code FROM enabled, unnest($4::bytea[]) code
+	), revoked AS (
+		DELETE FROM sessions s USING enabled WHERE s.user_id = enabled.id
+	)
+	SELECT id FROM enabled`
+
+	return r.queryOne(ctx, "EnableTOTP", query, id, pendingSecret, step, pq.ByteaArray(recoveryCodeHashes))
+}
+
+func (r *Repository) DisableTOTP(ctx context.Context, id int) error {
+	query := `
+	WITH disabled AS (
+		UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL WHERE id = $1
+		RETURNING id
+	), codes AS (
+		DELETE FROM mfa_recovery_codes c USING disabled WHERE c.user_id = disabled.id
+	)
+	SELECT id FROM disabled`
+
+	return r.queryOne(ctx, "DisableTOTP", query, id)
+}
+
+func (r *Repository) UseTOTPStep(ctx context.Context, id int, step int64) error {
+	query := "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1"
+	return r.execOne(ctx, "UseTOTPStep", query, step, id)
+}
+
+func (r *Repository) UseRecoveryCode(ctx context.Context, id int, codeHash []byte) error {
+	query := "DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND code_hash = $2"
+	return r.execOne(ctx, "UseRecoveryCode", query, id, codeHash)
+}
+
+func (r *Repository) CreateMFAChallenge(ctx context.Context, tokenHash []byte, id int, expiresAt time.Time) error {
+	// Expired challenges are dropped on the way; the index keeps it cheap.
+	query := `
+	WITH expired AS (
+		DELETE FROM mfa_challenges WHERE expires_at <= now()
+	)
+	INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+	ctx, span := startSpan(ctx, "CreateMFAChallenge", query)
+	defer span.End()
+
+	if _, err := r.conn.ExecContext(ctx, query, tokenHash, id, expiresAt); err != nil {
+		return recordError(ctx, span, wrapError(err))
+	}
+
+	return nil
+}
+
+func (r *Repository) AttemptMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	query := `
+	UPDATE mfa_challenges SET attempts = attempts + 1
+	WHERE token_hash = $1 AND expires_at > now() AND attempts < $2
+	RETURNING user_id`
+	ctx, span := startSpan(ctx, "AttemptMFAChallenge", query)
+	defer span.End()
+
+	var id int
+	if err := r.conn.QueryRowContext(ctx, query, tokenHash, maxAttempts).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return 0, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return id, nil
+}
+
+func (r *Repository) DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error {
+	return r.execOne(ctx, "DeleteMFAChallenge", "DELETE FROM mfa_challenges WHERE token_hash = $1", tokenHash)
+}
+
+// execOne runs a statement expected to change a row, and returns ErrNotFound
+// when it changed none.
+func (r *Repository) execOne(ctx context.Context, name, query string, args ...any) error {
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	res, err := r.conn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	if affected == 0 {
+		return errors.Join(ErrDatabase, ErrNotFound)
+	}
+
+	return nil
+}
+
+// queryOne runs a statement expected to return a row, and returns
+// ErrNotFound when it returned none.
+func (r *Repository) queryOne(ctx context.Context, name, query string, args ...any) error {
+	ctx, span := startSpan(ctx, name, query)
+	defer span.End()
+
+	var id int
+	if err := r.conn.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.Join(ErrDatabase, ErrNotFound)
+		}
+
+		return recordError(ctx, span, wrapError(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationsLock is the pg_advisory_lock key serialising migrations across
+// replicas starting at the same time.
+const migrationsLock = 72_110_001
+
+var ErrBadMigrationName = errors.New("migration file name must start with a version number")
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+type MigrationStatus struct {
+	Current int
+	Latest  int
+	Pending []Migration
+}
+
+func (s MigrationStatus) UpToDate() bool {
+	return len(s.Pending) == 0
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, errors.Join(ErrBadMigrationName, fmt.Errorf("file %q", entry.Name()))
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    strings.TrimSuffix(entry.Name(), ".sql"),
+			sql:     string(body),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrate applies pending migrations, each in its own transaction.
+func (r *Repository) Migrate(ctx context.Context) ([]Migration, error) {
+	conn, err := r.conn.Conn(ctx)
+	if err != nil {
+		return nil, errors.Join(ErrDatabase, err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLock)
+	if err != nil {
+		return nil, errors.Join(ErrDatabase, err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLock)
+
+	status, err := migrationStatus(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, migration := range status.Pending {
+		err := applyMigration(ctx, conn, migration)
+		if err != nil {
+			return status.Pending[:i], errors.Join(ErrDatabase, fmt.Errorf("migration %s: %w", migration.Name, err))
+		}
+	}
+
+	return status.Pending, nil
+}
+
+func (r *Repository) MigrationStatus(ctx context.Context) (MigrationStatus, error) {
+	conn, err := r.conn.Conn(ctx)
+	if err != nil {
+		return MigrationStatus{}, errors.Join(ErrDatabase, err)
+	}
+	defer conn.Close()
+
+	return migrationStatus(ctx, conn)
+}
+
+func migrationStatus(ctx context.Context, conn *sql.Conn) (MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+`)
+	if err != nil {
+		return MigrationStatus{}, errors.Join(ErrDatabase, err)
+	}
+
+	status := MigrationStatus{}
+	err = conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&status.Current)
+	if err != nil {
+		return MigrationStatus{}, errors.Join(ErrDatabase, err)
+	}
+
+	for _, migration := range migrations {
+		status.Latest = migration.Version
+		if migration.Version > status.Current {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	return status, nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, migration.sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations(version) VALUES ($1)", migration.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
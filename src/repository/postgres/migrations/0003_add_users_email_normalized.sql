@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_normalized TEXT;
+
+-- Existing emails are only lowercased. Where several differ only in case the
+-- oldest user keeps the normalized email and the others are left without one
+-- until their email changes, so the migration never fails on old data.
+UPDATE users u SET email_normalized = lower(trim(u.email))
+WHERE u.email_normalized IS NULL
+	AND u.id = (SELECT min(d.id) FROM users d WHERE lower(trim(d.email)) = lower(trim(u.email)));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_normalized ON users (email_normalized);
+
+-- The normalized email replaces the case-sensitive constraint.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
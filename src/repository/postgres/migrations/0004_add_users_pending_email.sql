@@ -0,0 +1,5 @@
+-- An email change waits here until the new address is confirmed.
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS pending_email TEXT,
+	ADD COLUMN IF NOT EXISTS pending_email_normalized TEXT,
+	ADD COLUMN IF NOT EXISTS pending_email_expires_at TIMESTAMPTZ;
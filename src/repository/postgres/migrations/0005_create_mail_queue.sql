@@ -0,0 +1,13 @@
+-- Outgoing email waits here until it is sent; failed_at marks a message
+-- given up after its last attempt.
+CREATE TABLE IF NOT EXISTS mail_queue (
+	id BIGSERIAL PRIMARY KEY,
+	message JSONB NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	failed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS mail_queue_due ON mail_queue (next_attempt_at) WHERE failed_at IS NULL;
@@ -0,0 +1,20 @@
+-- Passwords are argon2id hashes; reset and session tokens are stored as
+-- SHA-256 hashes only.
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS password_hash TEXT,
+	ADD COLUMN IF NOT EXISTS password_reset_hash BYTEA,
+	ADD COLUMN IF NOT EXISTS password_reset_expires_at TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_password_reset_hash ON users (password_reset_hash);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id BIGSERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	token_hash BYTEA NOT NULL UNIQUE,
+	refresh_hash BYTEA NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	refresh_expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
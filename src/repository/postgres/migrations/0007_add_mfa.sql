@@ -0,0 +1,22 @@
+-- A TOTP secret waits in totp_pending_secret until a code from it is
+-- verified. totp_last_step keeps a code from being used twice.
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+	ADD COLUMN IF NOT EXISTS totp_pending_secret TEXT,
+	ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	code_hash BYTEA NOT NULL,
+	PRIMARY KEY (user_id, code_hash)
+);
+
+-- A login that passed the password check and waits for the second factor.
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	token_hash BYTEA PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS mfa_challenges_expires_at ON mfa_challenges (expires_at);
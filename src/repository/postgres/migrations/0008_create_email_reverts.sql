@@ -0,0 +1,11 @@
+-- Every applied email revert, recorded with the time its link was issued.
+-- A revert voids the links issued from then until it was applied, so the
+-- address it replaced cannot take the account back, while the links mailed
+-- to earlier addresses keep working.
+CREATE TABLE IF NOT EXISTS email_reverts (
+	user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	issued_at TIMESTAMPTZ NOT NULL,
+	reverted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS email_reverts_user_id ON email_reverts (user_id);
@@ -0,0 +1,5 @@
+-- Failed messages keep their recipient and subject but not their body,
+-- which holds tokens, and are deleted after mail.queue.retention.
+UPDATE mail_queue SET message = message - 'text' - 'html' WHERE failed_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS mail_queue_failed ON mail_queue (failed_at) WHERE failed_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- When the user last confirmed an email change; password resets to the new
+-- address wait until the old one can no longer revert it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_changed_at TIMESTAMPTZ;
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"go-user-service/src/ratelimit"
+)
+
+// rateLimitSweepInterval is how often refilled buckets are deleted.
+const rateLimitSweepInterval = time.Minute
+
+var _ ratelimit.Store = (*RateLimitStore)(nil)
+
+// takeTokenQuery refills and takes from a bucket in one statement, so
+// concurrent requests from any replica serialise on the row lock. $2 is the
+// capacity and $3 the refill rate per second; the SET expressions all see the
+// row as it was, so the refill is spelled out in each.
+const takeTokenQuery = `
+INSERT INTO rate_limits AS b (key, tokens, allowed, updated_at, full_at)
+VALUES ($1, $2::float8 - 1, true, now(), now() + make_interval(secs => 1 / $3::float8))
+ON CONFLICT (key) DO UPDATE SET
+	tokens = CASE
+		WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8) >= 1
+		THEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8) - 1
+		ELSE LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8)
+	END,
+	allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8) >= 1,
+	updated_at = now(),
+	full_at = now() + make_interval(secs => ($2::float8 - CASE
+		WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8) >= 1
+		THEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8) - 1
+		ELSE LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::float8)
+	END) / $3::float8)
+RETURNING tokens, allowed`
+
+// RateLimitStore keeps rate limit buckets in the database, so all replicas
+// share them.
+type RateLimitStore struct {
+	conn  *sql.DB
+	swept atomic.Int64
+}
+
+func (r *Repository) RateLimitStore() *RateLimitStore {
+	return &RateLimitStore{conn: r.conn}
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	ctx, span := startSpan(ctx, "RateLimitTake", takeTokenQuery)
+	defer span.End()
+
+	err := s.sweep(ctx)
+	if err != nil {
+		return ratelimit.Result{}, recordError(ctx, span, err)
+	}
+
+	var tokens float64
+	var allowed bool
+	err = s.conn.QueryRowContext(ctx, takeTokenQuery, key, float64(limit.Capacity()), limit.Rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return ratelimit.Result{}, recordError(ctx, span, errors.Join(ErrDatabase, err))
+	}
+
+	return ratelimit.NewResult(allowed, tokens, limit), nil
+}
+
+// sweep deletes the buckets that are full by now, at most once a minute
+// across the goroutines of this replica; a new bucket starts full anyway.
+func (s *RateLimitStore) sweep(ctx context.Context) error {
+	now := time.Now().Unix()
+	last := s.swept.Load()
+	if now-last < int64(rateLimitSweepInterval.Seconds()) || !s.swept.CompareAndSwap(last, now) {
+		return nil
+	}
+
+	_, err := s.conn.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at < now()")
+	if err != nil {
+		return errors.Join(ErrDatabase, err)
+	}
+
+	return nil
+}
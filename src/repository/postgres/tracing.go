@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"go-user-service/src/logging"
+)
+
+var tracer = otel.Tracer("go-user-service/src/repository/postgres")
+
+// startSpan starts a client span for a repository operation. query is the
+// main statement the operation runs; it carries placeholders, never values.
+func startSpan(ctx context.Context, operation string, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "postgres."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(query),
+		),
+	)
+}
+
+// recordError records unexpected errors on span and in the request log and
+// returns err unchanged. Missing rows are an expected outcome and are skipped.
+func recordError(ctx context.Context, span trace.Span, err error) error {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		logging.FromContext(ctx).Debug("query failed", zap.Error(err))
+	}
+
+	return err
+}
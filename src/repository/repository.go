@@ -2,23 +2,204 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"go-user-service/src/repository/models"
 )
 
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrBatchAborted marks items of an atomic batch that would have
+	// succeeded but were rolled back because another item failed.
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
+type ImportOutcome int
+
+const (
+	ImportInserted ImportOutcome = iota + 1
+	ImportUpdated
+	// ImportConflict means the email already belongs to another user.
+	ImportConflict
+	// ImportDuplicate means an earlier record in the same batch had the email.
+	ImportDuplicate
+)
+
+type ImportRecord struct {
+	Line int
+	User models.User
+}
+
+type ImportOptions struct {
+	// Upsert updates the name of users whose email already exists.
+	Upsert bool
+	// DryRun reports the outcome without persisting anything.
+	DryRun bool
+}
+
+// BatchResult is the outcome of one item of a batch write. Id is the id of
+// the created or deleted user and Err is nil when the item succeeded.
+type BatchResult struct {
+	Id  int
+	Err error
+}
+
+// ExportFilter narrows an export; zero values match every user.
+type ExportFilter struct {
+	// EmailDomain matches the part of the email after "@", case-insensitively.
+	EmailDomain string
+	// AfterId skips users with an id less than or equal to it.
+	AfterId int
+}
+
+// Session is a login as stored; its tokens are kept as SHA-256 hashes.
+type Session struct {
+	UserId           int
+	TokenHash        []byte
+	RefreshHash      []byte
+	ExpiresAt        time.Time
+	RefreshExpiresAt time.Time
+}
+
+// TOTP is a user's time-based one-time password setup. Secret is empty
+// until enrollment is verified.
+type TOTP struct {
+	Secret        string
+	PendingSecret string
+	// LastStep is the time step of the last code accepted.
+	LastStep int64
+}
+
+// SslModes are the sslmode values lib/pq accepts.
+var SslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
 type Config struct {
-	Host     string `mapstructure:"host"`
-	Port     int    `mapstructure:"port"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	DbName   string `mapstructure:"dbname"`
-	SslMode  string `mapstructure:"sslmode"`
+	Host     string `mapstructure:"host" yaml:"host"`
+	Port     int    `mapstructure:"port" yaml:"port"`
+	User     string `mapstructure:"user" yaml:"user"`
+	Password string `mapstructure:"password" yaml:"password"`
+	DbName   string `mapstructure:"dbname" yaml:"dbname"`
+	SslMode  string `mapstructure:"sslmode" yaml:"sslmode"`
+	// SkipMigrations leaves schema changes to an explicit "migrate" run.
+	SkipMigrations bool `mapstructure:"skip_migrations" yaml:"skip_migrations"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("host: must not be empty"))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port: must be between 1 and 65535, got %d", c.Port))
+	}
+
+	if c.User == "" {
+		errs = append(errs, errors.New("user: must not be empty"))
+	}
+
+	if c.DbName == "" {
+		errs = append(errs, errors.New("dbname: must not be empty"))
+	}
+
+	if !slices.Contains(SslModes, c.SslMode) {
+		errs = append(errs, fmt.Errorf("sslmode: must be one of %s, got %q", strings.Join(SslModes, ", "), c.SslMode))
+	}
+
+	return errors.Join(errs...)
 }
 
 type Repository interface {
 	Create(ctx context.Context, user *models.User) (int, error)
 	Get(ctx context.Context, id int) (*models.User, error)
-	UpdateEmail(ctx context.Context, id int, email string) error
+	// GetByEmail finds the user by normalized email, see models.User.
+	GetByEmail(ctx context.Context, normalizedEmail string) (*models.User, error)
+	// GetMany returns the users that exist among ids, in no particular order.
+	GetMany(ctx context.Context, ids []int) ([]*models.User, error)
+	// List returns up to limit users with id greater than afterId, ordered by id.
+	List(ctx context.Context, afterId int, limit int) ([]*models.User, error)
+	// ListOffset returns up to limit users ordered by id, skipping the first offset.
+	ListOffset(ctx context.Context, offset int, limit int) ([]*models.User, error)
+	Count(ctx context.Context) (int, error)
+	// UpdateEmail stores the user's email. A new mailbox drops any pending
+	// email change.
+	UpdateEmail(ctx context.Context, id int, email, normalizedEmail string) error
+	// RequestEmailChange records email as the user's pending email until
+	// expiresAt, replacing any earlier pending change.
+	RequestEmailChange(ctx context.Context, id int, email, normalizedEmail string, expiresAt time.Time) error
+	// ConfirmEmailChange makes the pending email the user's email if it is
+	// normalizedEmail and has not expired, and returns the user as it was
+	// before. It returns ErrNotFound otherwise.
+	ConfirmEmailChange(ctx context.Context, id int, normalizedEmail string) (*models.User, error)
+	// RevertEmailChange restores previous's email for a revert link issued
+	// at issuedAt, drops any pending change and, in the same transaction,
+	// revokes the user's sessions, pending logins and password reset. It
+	// returns ErrNotFound when the email already is previous's or an earlier
+	// revert voided the link, that is one applied after issuedAt with a link
+	// issued no later.
+	RevertEmailChange(ctx context.Context, id int, previous *models.User, issuedAt time.Time) error
+	// SetPasswordReset stores the hash of the user's password reset token
+	// until expiresAt, replacing any earlier one.
+	SetPasswordReset(ctx context.Context, id int, tokenHash []byte, expiresAt time.Time) error
+	// ResetPassword sets the password hash of the user holding the unexpired
+	// reset token tokenHash, uses the token up, deletes the user's sessions
+	// and returns the user. It returns ErrNotFound otherwise.
+	ResetPassword(ctx context.Context, tokenHash []byte, passwordHash string) (*models.User, error)
+	// GetPasswordHash returns the user's password hash, empty when none is set.
+	GetPasswordHash(ctx context.Context, id int) (string, error)
+	// EmailChangedAt returns when the user last confirmed an email change,
+	// or the zero time when they never did or a revert undid it.
+	EmailChangedAt(ctx context.Context, id int) (time.Time, error)
+	CreateSession(ctx context.Context, session *Session) error
+	// RefreshSession replaces the tokens of the session whose unexpired
+	// refresh token is refreshHash with next's, and returns the session's
+	// user id. It returns ErrNotFound otherwise.
+	RefreshSession(ctx context.Context, refreshHash []byte, next *Session) (int, error)
+	// GetSessionUser returns the user of the unexpired session tokenHash
+	// authenticates, or ErrNotFound.
+	GetSessionUser(ctx context.Context, tokenHash []byte) (*models.User, error)
+	DeleteSession(ctx context.Context, tokenHash []byte) error
+	// GetTOTP returns the user's TOTP setup, zero when there is none.
+	GetTOTP(ctx context.Context, id int) (*TOTP, error)
+	// SetPendingTOTP stores secret until EnableTOTP, replacing any earlier
+	// pending one; an enabled secret stays in use meanwhile.
+	SetPendingTOTP(ctx context.Context, id int, secret string) error
+	// EnableTOTP makes the pending secret the user's if it still is
+	// pendingSecret, records step as used, replaces the recovery codes and
+	// deletes the user's sessions. It returns ErrNotFound otherwise.
+	EnableTOTP(ctx context.Context, id int, pendingSecret string, step int64, recoveryCodeHashes [][]byte) error
+	// DisableTOTP removes the user's TOTP setup and recovery codes.
+	DisableTOTP(ctx context.Context, id int) error
+	// UseTOTPStep records step as used, or returns ErrNotFound when it or a
+	// later step already was.
+	UseTOTPStep(ctx context.Context, id int, step int64) error
+	// UseRecoveryCode deletes the user's recovery code codeHash, or returns
+	// ErrNotFound.
+	UseRecoveryCode(ctx context.Context, id int, codeHash []byte) error
+	CreateMFAChallenge(ctx context.Context, tokenHash []byte, id int, expiresAt time.Time) error
+	// AttemptMFAChallenge counts an attempt at the unexpired challenge
+	// tokenHash and returns its user id, or ErrNotFound once maxAttempts
+	// were made.
+	AttemptMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error
+	// Update stores the user's email and name. A new mailbox drops any
+	// pending email change.
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id int) error
+	// CreateMany creates users in one transaction and returns one result per
+	// user. When atomic is set, any failed item rolls the whole batch back.
+	CreateMany(ctx context.Context, users []*models.User, atomic bool) ([]BatchResult, error)
+	// DeleteMany deletes users in one transaction with the same semantics as CreateMany.
+	DeleteMany(ctx context.Context, ids []int, atomic bool) ([]BatchResult, error)
+	// Import stores a batch of records atomically and returns one outcome per record.
+	Import(ctx context.Context, records []ImportRecord, opts ImportOptions) ([]ImportOutcome, error)
+	// Export calls fn for every user matching filter, ordered by id, from a
+	// single consistent snapshot. It stops at the first error fn returns.
+	Export(ctx context.Context, filter ExportFilter, fn func(*models.User) error) error
 }
@@ -0,0 +1,69 @@
+package scim
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MinTokenLength keeps bearer tokens out of reach of guessing.
+const MinTokenLength = 32
+
+const bearerPrefix = "Bearer "
+
+var ErrUnauthorized = errors.New("missing or invalid bearer token")
+
+type Config struct {
+	// BearerTokens lists the tokens identity providers authenticate with,
+	// one per provider so each can be rotated on its own. SCIM answers 401
+	// to every request while the list is empty.
+	BearerTokens []string `mapstructure:"bearer_tokens" yaml:"bearer_tokens"`
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	for i, token := range c.BearerTokens {
+		if len(token) < MinTokenLength {
+			errs = append(errs, fmt.Errorf("bearer_tokens[%d]: must be at least %d bytes", i, MinTokenLength))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func hashTokens(tokens []string) [][]byte {
+	hashes := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		sum := sha256.Sum256([]byte(token))
+		hashes[i] = sum[:]
+	}
+
+	return hashes
+}
+
+// Authenticate answers 401 unless the request carries one of the configured
+// bearer tokens. Tokens are compared by hash in constant time, so neither
+// their content nor their length leaks through timing.
+func (h *Handler) Authenticate(c *fiber.Ctx) error {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > len(bearerPrefix) && strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		sum := sha256.Sum256([]byte(header[len(bearerPrefix):]))
+
+		match := 0
+		for _, hash := range h.tokenHashes {
+			match |= subtle.ConstantTimeCompare(sum[:], hash)
+		}
+
+		if match == 1 {
+			return c.Next()
+		}
+	}
+
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="SCIM"`)
+	return sendError(c, fiber.StatusUnauthorized, "", ErrUnauthorized)
+}
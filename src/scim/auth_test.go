@@ -0,0 +1,50 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticate(t *testing.T) {
+	token := strings.Repeat("t", MinTokenLength)
+	require.NoError(t, Config{BearerTokens: []string{token}}.Validate())
+	require.Error(t, Config{BearerTokens: []string{"short"}}.Validate())
+
+	request := func(h *Handler, authorization string) int {
+		app := fiber.New()
+		app.Get(BasePath+"/ServiceProviderConfig", h.Authenticate, h.ServiceProviderConfig)
+
+		req := httptest.NewRequest(fiber.MethodGet, BasePath+"/ServiceProviderConfig", nil)
+		if authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, authorization)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+
+		if resp.StatusCode == fiber.StatusOK {
+			var config serviceProviderConfig
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&config))
+			require.Equal(t, "oauthbearertoken", config.AuthenticationSchemes[0].Type)
+		} else {
+			require.Equal(t, `Bearer realm="SCIM"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+		}
+
+		return resp.StatusCode
+	}
+
+	h := New(nil, Config{BearerTokens: []string{"other-" + token, token}})
+	require.Equal(t, fiber.StatusOK, request(h, "Bearer "+token))
+	require.Equal(t, fiber.StatusOK, request(h, "bearer "+token))
+	require.Equal(t, fiber.StatusUnauthorized, request(h, "Bearer "+token+"x"))
+	require.Equal(t, fiber.StatusUnauthorized, request(h, "Basic "+token))
+	require.Equal(t, fiber.StatusUnauthorized, request(h, ""))
+
+	// No tokens configured locks SCIM.
+	require.Equal(t, fiber.StatusUnauthorized, request(New(nil, Config{}), "Bearer "))
+}
@@ -0,0 +1,145 @@
+package scim
+
+type attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []attribute `json:"subAttributes,omitempty"`
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupported struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SpecUri     string `json:"specUri"`
+	Primary     bool   `json:"primary"`
+}
+
+type serviceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupported          `json:"bulk"`
+	Filter                filterSupported        `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	Etag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+type resourceType struct {
+	Schemas  []string `json:"schemas"`
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	Schema   string   `json:"schema"`
+	Meta     Meta     `json:"meta"`
+}
+
+type schema struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []attribute `json:"attributes"`
+	Meta        Meta        `json:"meta"`
+}
+
+func newServiceProviderConfig(maxResults int) serviceProviderConfig {
+	return serviceProviderConfig{
+		Schemas: []string{SchemaServiceProviderConfig},
+		Patch:   supported{Supported: true},
+		Filter:  filterSupported{Supported: true, MaxResults: maxResults},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Authentication with a bearer token from scim.bearer_tokens",
+			SpecUri:     "https://www.rfc-editor.org/info/rfc6750",
+			Primary:     true,
+		}},
+		Meta: Meta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     BasePath + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func userResourceType() resourceType {
+	return resourceType{
+		Schemas:  []string{SchemaResourceType},
+		Id:       "User",
+		Name:     "User",
+		Endpoint: "/Users",
+		Schema:   SchemaUser,
+		Meta: Meta{
+			ResourceType: "ResourceType",
+			Location:     BasePath + "/ResourceTypes/User",
+		},
+	}
+}
+
+func userSchema() schema {
+	str := func(name string, required bool, uniqueness string) attribute {
+		return attribute{
+			Name:       name,
+			Type:       "string",
+			Required:   required,
+			Mutability: "readWrite",
+			Returned:   "default",
+			Uniqueness: uniqueness,
+		}
+	}
+
+	emailValue := str("value", true, "server")
+	primary := attribute{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+	emails := attribute{
+		Name:          "emails",
+		Type:          "complex",
+		MultiValued:   true,
+		Mutability:    "readWrite",
+		Returned:      "default",
+		Uniqueness:    "none",
+		SubAttributes: []attribute{emailValue, str("type", false, "none"), primary},
+	}
+	name := attribute{
+		Name:          "name",
+		Type:          "complex",
+		Mutability:    "readWrite",
+		Returned:      "default",
+		Uniqueness:    "none",
+		SubAttributes: []attribute{str("formatted", false, "none"), str("givenName", false, "none"), str("familyName", false, "none")},
+	}
+	active := attribute{Name: "active", Type: "boolean", Mutability: "readOnly", Returned: "default", Uniqueness: "none"}
+
+	return schema{
+		Schemas:     []string{SchemaSchema},
+		Id:          SchemaUser,
+		Name:        "User",
+		Description: "User account; userName is the user's email address",
+		Attributes:  []attribute{str("userName", true, "server"), name, str("displayName", false, "none"), emails, active},
+		Meta: Meta{
+			ResourceType: "Schema",
+			Location:     BasePath + "/Schemas/" + SchemaUser,
+		},
+	}
+}
@@ -0,0 +1,38 @@
+package scim
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrBadFilter = errors.New(`only 'userName eq "..."', 'emails.value eq "..."' and 'id eq "..."' filters are supported`)
+
+var filterPattern = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z0-9.]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+type filter struct {
+	attribute string
+	value     string
+}
+
+func parseFilter(raw string) (*filter, error) {
+	match := filterPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return nil, ErrBadFilter
+	}
+
+	value, err := strconv.Unquote(`"` + match[2] + `"`)
+	if err != nil {
+		return nil, ErrBadFilter
+	}
+
+	attribute := strings.ToLower(match[1])
+	switch attribute {
+	case "username", "emails.value", "emails", "id":
+	default:
+		return nil, ErrBadFilter
+	}
+
+	return &filter{attribute: attribute, value: value}, nil
+}
@@ -0,0 +1,294 @@
+package scim
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/logging"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+const (
+	scimTypeInvalidFilter = "invalidFilter"
+	scimTypeInvalidPath   = "invalidPath"
+	scimTypeInvalidSyntax = "invalidSyntax"
+	scimTypeInvalidValue  = "invalidValue"
+	scimTypeMutability    = "mutability"
+	scimTypeUniqueness    = "uniqueness"
+)
+
+var (
+	ErrBadUserId      = errors.New("bad user id")
+	ErrBadUserPayload = errors.New("bad user payload")
+	ErrInternal       = errors.New("internal error")
+	ErrUnknownSchema  = errors.New("unknown schema")
+	ErrUnknownType    = errors.New("unknown resource type")
+)
+
+type Handler struct {
+	controller  *controllers.Controller
+	tokenHashes [][]byte
+}
+
+func New(controller *controllers.Controller, cfg Config) *Handler {
+	return &Handler{controller: controller, tokenHashes: hashTokens(cfg.BearerTokens)}
+}
+
+func (h *Handler) List(c *fiber.Ctx) error {
+	startIndex := c.QueryInt("startIndex", 1)
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	// count=0 asks for totalResults only; RFC 7644 reads negative counts as 0.
+	count := c.QueryInt("count", controllers.DefaultPageSize)
+	if count > 0 {
+		count = controllers.PageSize(count)
+	} else {
+		count = 0
+	}
+
+	if raw := c.Query("filter"); raw != "" {
+		f, err := parseFilter(raw)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, scimTypeInvalidFilter, err)
+		}
+
+		user, err := h.find(c, f)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return sendControllerError(c, err)
+		}
+
+		resp := ListResponse{
+			Schemas:    []string{SchemaListResponse},
+			StartIndex: 1,
+			Resources:  []User{},
+		}
+		if user != nil {
+			resp.TotalResults = 1
+			if count > 0 {
+				resp.ItemsPerPage = 1
+				resp.Resources = append(resp.Resources, fromModel(user))
+			}
+		}
+
+		return send(c, fiber.StatusOK, resp)
+	}
+
+	total, err := h.controller.Count(c.UserContext())
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	var users []*models.User
+	if count > 0 {
+		users, err = h.controller.ListOffset(c.UserContext(), startIndex-1, count)
+		if err != nil {
+			return sendControllerError(c, err)
+		}
+	}
+
+	resp := ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    make([]User, 0, len(users)),
+	}
+	for _, user := range users {
+		resp.Resources = append(resp.Resources, fromModel(user))
+	}
+
+	return send(c, fiber.StatusOK, resp)
+}
+
+func (h *Handler) Create(c *fiber.Ctx) error {
+	req := User{}
+	if err := c.BodyParser(&req); err != nil {
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidSyntax, ErrBadUserPayload)
+	}
+
+	user := req.toModel()
+	id, err := h.controller.Create(c.UserContext(), user)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	user.Id = id
+	resource := fromModel(user)
+	c.Location(resource.Meta.Location)
+
+	return send(c, fiber.StatusCreated, resource)
+}
+
+func (h *Handler) Get(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return sendError(c, fiber.StatusNotFound, "", ErrBadUserId)
+	}
+
+	user, err := h.controller.Get(c.UserContext(), id)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	return send(c, fiber.StatusOK, fromModel(user))
+}
+
+func (h *Handler) Replace(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return sendError(c, fiber.StatusNotFound, "", ErrBadUserId)
+	}
+
+	req := User{}
+	if err := c.BodyParser(&req); err != nil {
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidSyntax, ErrBadUserPayload)
+	}
+
+	if req.Active != nil && !*req.Active {
+		return sendError(c, fiber.StatusBadRequest, scimTypeMutability, ErrNoDeactivation)
+	}
+
+	user := req.toModel()
+	user.Id = id
+	err = h.controller.UpdateProvisioned(c.UserContext(), user)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	return send(c, fiber.StatusOK, fromModel(user))
+}
+
+func (h *Handler) Patch(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return sendError(c, fiber.StatusNotFound, "", ErrBadUserId)
+	}
+
+	req := PatchRequest{}
+	if err := c.BodyParser(&req); err != nil {
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidSyntax, ErrBadUserPayload)
+	}
+
+	user, err := h.controller.Get(c.UserContext(), id)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	err = applyPatch(user, req.Operations)
+	switch {
+	case errors.Is(err, ErrBadPatchPath):
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidPath, err)
+	case errors.Is(err, ErrBadPatchValue):
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidValue, err)
+	case errors.Is(err, ErrNoDeactivation), errors.Is(err, ErrRemoveForbidden):
+		return sendError(c, fiber.StatusBadRequest, scimTypeMutability, err)
+	case err != nil:
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidSyntax, err)
+	}
+
+	err = h.controller.UpdateProvisioned(c.UserContext(), user)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	return send(c, fiber.StatusOK, fromModel(user))
+}
+
+func (h *Handler) Delete(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return sendError(c, fiber.StatusNotFound, "", ErrBadUserId)
+	}
+
+	err = h.controller.Delete(c.UserContext(), id)
+	if err != nil {
+		return sendControllerError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *Handler) ServiceProviderConfig(c *fiber.Ctx) error {
+	return send(c, fiber.StatusOK, newServiceProviderConfig(controllers.MaxPageSize))
+}
+
+func (h *Handler) ResourceTypes(c *fiber.Ctx) error {
+	return send(c, fiber.StatusOK, listOf(userResourceType()))
+}
+
+func (h *Handler) ResourceType(c *fiber.Ctx) error {
+	if c.Params("id") != "User" {
+		return sendError(c, fiber.StatusNotFound, "", ErrUnknownType)
+	}
+
+	return send(c, fiber.StatusOK, userResourceType())
+}
+
+func (h *Handler) Schemas(c *fiber.Ctx) error {
+	return send(c, fiber.StatusOK, listOf(userSchema()))
+}
+
+func (h *Handler) Schema(c *fiber.Ctx) error {
+	if c.Params("id") != SchemaUser {
+		return sendError(c, fiber.StatusNotFound, "", ErrUnknownSchema)
+	}
+
+	return send(c, fiber.StatusOK, userSchema())
+}
+
+func (h *Handler) find(c *fiber.Ctx, f *filter) (*models.User, error) {
+	if f.attribute == "id" {
+		id, err := strconv.Atoi(f.value)
+		if err != nil {
+			return nil, repository.ErrNotFound
+		}
+
+		return h.controller.Get(c.UserContext(), id)
+	}
+
+	return h.controller.GetByEmail(c.UserContext(), f.value)
+}
+
+func listOf(resource interface{}) fiber.Map {
+	return fiber.Map{
+		"schemas":      []string{SchemaListResponse},
+		"totalResults": 1,
+		"startIndex":   1,
+		"itemsPerPage": 1,
+		"Resources":    []interface{}{resource},
+	}
+}
+
+func send(c *fiber.Ctx, status int, body interface{}) error {
+	return c.Status(status).JSON(body, ContentType)
+}
+
+func sendError(c *fiber.Ctx, status int, scimType string, err error) error {
+	return send(c, status, ErrorResponse{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   err.Error(),
+	})
+}
+
+func sendControllerError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, controllers.ErrBadEmail), errors.Is(err, controllers.ErrBadName):
+		return sendError(c, fiber.StatusBadRequest, scimTypeInvalidValue, err)
+	case errors.Is(err, repository.ErrNotFound):
+		return sendError(c, fiber.StatusNotFound, "", repository.ErrNotFound)
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return sendError(c, fiber.StatusConflict, scimTypeUniqueness, repository.ErrAlreadyExists)
+	default:
+		logging.FromContext(c.UserContext()).Error("scim request failed", zap.Error(err))
+		return sendError(c, fiber.StatusInternalServerError, "", ErrInternal)
+	}
+}
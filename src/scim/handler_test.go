@@ -0,0 +1,146 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"go-user-service/src/controllers"
+	"go-user-service/src/mailer"
+	"go-user-service/src/repository"
+	"go-user-service/src/repository/models"
+)
+
+// stubRepository holds users in id order and counts the pages listed.
+// Updates change the stored users.
+type stubRepository struct {
+	repository.Repository
+
+	users []*models.User
+	pages int
+}
+
+func (r *stubRepository) Count(context.Context) (int, error) {
+	return len(r.users), nil
+}
+
+func (r *stubRepository) ListOffset(_ context.Context, offset int, limit int) ([]*models.User, error) {
+	r.pages++
+	end := min(offset+limit, len(r.users))
+	return r.users[min(offset, end):end], nil
+}
+
+func (r *stubRepository) Get(_ context.Context, id int) (*models.User, error) {
+	for _, user := range r.users {
+		if user.Id == id {
+			copied := *user
+			return &copied, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func (r *stubRepository) Update(_ context.Context, user *models.User) error {
+	for _, stored := range r.users {
+		if stored.Id == user.Id {
+			stored.Email, stored.Name = user.Email, user.Name
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+func (r *stubRepository) GetByEmail(_ context.Context, normalizedEmail string) (*models.User, error) {
+	for _, user := range r.users {
+		if user.Email == normalizedEmail {
+			return user, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+func TestList(t *testing.T) {
+	repo := &stubRepository{users: []*models.User{
+		{Id: 1, Email: "a@example.com", Name: "A"},
+		{Id: 2, Email: "b@example.com", Name: "B"},
+	}}
+
+	app := fiber.New()
+	app.Get(BasePath+"/Users", New(controllers.New(repo, controllers.Options{}), Config{}).List)
+
+	list := func(t *testing.T, query string) ListResponse {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, BasePath+"/Users?"+query, nil))
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var list ListResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		return list
+	}
+
+	t.Run("Page", func(t *testing.T) {
+		page := list(t, "startIndex=2&count=1")
+		require.Equal(t, 2, page.TotalResults)
+		require.Equal(t, 1, page.ItemsPerPage)
+		require.Equal(t, "2", page.Resources[0].Id)
+	})
+
+	t.Run("CountOnly", func(t *testing.T) {
+		repo.pages = 0
+		for _, count := range []string{"0", "-1"} {
+			page := list(t, "count="+count)
+			require.Equal(t, 2, page.TotalResults)
+			require.Zero(t, page.ItemsPerPage)
+			require.Empty(t, page.Resources)
+		}
+		require.Zero(t, repo.pages)
+
+		page := list(t, "count=0&filter="+strings.ReplaceAll(`userName eq "a@example.com"`, " ", "%20"))
+		require.Equal(t, 1, page.TotalResults)
+		require.Empty(t, page.Resources)
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	repo := &stubRepository{users: []*models.User{{Id: 1, Email: "a@example.com", Name: "A"}}}
+
+	// Email changes are verified, yet provisioning applies them at once.
+	templates, err := mailer.LoadTemplates("", "en")
+	require.NoError(t, err, err)
+	changes, err := controllers.NewEmailChanges(controllers.EmailChangeConfig{Verify: true}, mailer.NewSender(templates, mailer.NewLogMailer(zap.NewNop())))
+	require.NoError(t, err, err)
+
+	handler := New(controllers.New(repo, controllers.Options{EmailChanges: changes}), Config{})
+	app := fiber.New()
+	app.Put(BasePath+"/Users/:id", handler.Replace)
+	app.Patch(BasePath+"/Users/:id", handler.Patch)
+
+	send := func(t *testing.T, method, body string) User {
+		req := httptest.NewRequest(method, BasePath+"/Users/1", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var user User
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+		return user
+	}
+
+	user := send(t, fiber.MethodPut, `{"userName":"b@example.com","displayName":"B"}`)
+	require.Equal(t, "b@example.com", user.UserName)
+	require.Equal(t, "b@example.com", repo.users[0].Email)
+
+	user = send(t, fiber.MethodPatch, `{"Operations":[{"op":"replace","path":"userName","value":"c@example.com"}]}`)
+	require.Equal(t, "c@example.com", user.UserName)
+	require.Equal(t, "c@example.com", repo.users[0].Email)
+}
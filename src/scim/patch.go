@@ -0,0 +1,133 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go-user-service/src/repository/models"
+)
+
+var (
+	ErrBadPatchOp      = errors.New("unsupported patch operation")
+	ErrBadPatchPath    = errors.New("unsupported patch path")
+	ErrBadPatchValue   = errors.New("bad patch value")
+	ErrNoDeactivation  = errors.New("users cannot be deactivated, delete them instead")
+	ErrRemoveForbidden = errors.New("required attributes cannot be removed")
+)
+
+func applyPatch(user *models.User, ops []PatchOperation) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if err := applyValue(user, op.Path, op.Value); err != nil {
+				return err
+			}
+		case "remove":
+			if err := removeValue(op.Path); err != nil {
+				return err
+			}
+		default:
+			return ErrBadPatchOp
+		}
+	}
+
+	return nil
+}
+
+func applyValue(user *models.User, path string, value interface{}) error {
+	path = strings.ToLower(path)
+
+	switch {
+	case path == "":
+		attrs, ok := value.(map[string]interface{})
+		if !ok {
+			return ErrBadPatchValue
+		}
+
+		for key, v := range attrs {
+			if err := applyValue(user, key, v); err != nil {
+				return err
+			}
+		}
+	case path == "username":
+		return setString(&user.Email, value)
+	case path == "displayname", path == "name.formatted":
+		return setString(&user.Name, value)
+	case path == "name":
+		attrs, ok := value.(map[string]interface{})
+		if !ok {
+			return ErrBadPatchValue
+		}
+
+		if formatted, ok := attrs["formatted"].(string); ok && formatted != "" {
+			user.Name = formatted
+			return nil
+		}
+
+		given, _ := attrs["givenName"].(string)
+		family, _ := attrs["familyName"].(string)
+		if name := joinName(given, family); name != "" {
+			user.Name = name
+		}
+	case path == "emails":
+		emails, ok := value.([]interface{})
+		if !ok || len(emails) == 0 {
+			return ErrBadPatchValue
+		}
+
+		for _, e := range emails {
+			email, _ := e.(map[string]interface{})
+			v, _ := email["value"].(string)
+			primary, _ := email["primary"].(bool)
+			if v != "" && (primary || len(emails) == 1) {
+				user.Email = v
+			}
+		}
+	case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		// The service keeps a single address, so any email filter targets it.
+		return setString(&user.Email, value)
+	case path == "active":
+		// Some providers send booleans as strings.
+		if value != true && !strings.EqualFold(fmt.Sprint(value), "true") {
+			return ErrNoDeactivation
+		}
+	case path == "externalid":
+		// External ids are not stored; identity providers match on userName.
+	default:
+		return ErrBadPatchPath
+	}
+
+	return nil
+}
+
+// removeValue accepts removing the optional attributes. The service keeps
+// one name, which users must have, for name and displayName alike, so
+// removing either, or parts of name, leaves it as is; external ids and
+// email types are not stored in the first place.
+func removeValue(path string) error {
+	path = strings.ToLower(path)
+
+	switch {
+	case path == "":
+		return ErrBadPatchPath
+	case path == "username", path == "emails", path == "active",
+		strings.HasPrefix(path, "emails[") && (strings.HasSuffix(path, "]") || strings.HasSuffix(path, "].value")):
+		return ErrRemoveForbidden
+	case path == "displayname", path == "name", strings.HasPrefix(path, "name."),
+		path == "externalid", strings.HasPrefix(path, "emails["):
+		return nil
+	default:
+		return ErrBadPatchPath
+	}
+}
+
+func setString(dst *string, value interface{}) error {
+	s, ok := value.(string)
+	if !ok {
+		return ErrBadPatchValue
+	}
+
+	*dst = s
+	return nil
+}
@@ -0,0 +1,104 @@
+package scim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go-user-service/src/repository/models"
+)
+
+func TestParseFilter(t *testing.T) {
+	t.Run("UserName", func(t *testing.T) {
+		f, err := parseFilter(`userName eq "bob@example.com"`)
+		require.NoError(t, err, err)
+		require.Equal(t, "username", f.attribute)
+		require.Equal(t, "bob@example.com", f.value)
+	})
+
+	t.Run("EscapedQuote", func(t *testing.T) {
+		f, err := parseFilter(`emails.value EQ "a\"b@example.com"`)
+		require.NoError(t, err, err)
+		require.Equal(t, `a"b@example.com`, f.value)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		for _, raw := range []string{
+			`userName sw "bob"`,
+			`displayName eq "Bob"`,
+			`userName eq "a" and id eq "1"`,
+			``,
+		} {
+			_, err := parseFilter(raw)
+			require.ErrorIs(t, err, ErrBadFilter, raw)
+		}
+	})
+}
+
+func TestApplyPatch(t *testing.T) {
+	newUser := func() *models.User {
+		return &models.User{Id: 1, Email: "old@example.com", Name: "Old"}
+	}
+
+	t.Run("PathlessReplace", func(t *testing.T) {
+		user := newUser()
+		err := applyPatch(user, []PatchOperation{{
+			Op:    "Replace",
+			Value: map[string]interface{}{"userName": "new@example.com", "displayName": "New"},
+		}})
+		require.NoError(t, err, err)
+		require.Equal(t, "new@example.com", user.Email)
+		require.Equal(t, "New", user.Name)
+	})
+
+	t.Run("EmailFilterPath", func(t *testing.T) {
+		user := newUser()
+		err := applyPatch(user, []PatchOperation{{
+			Op:    "replace",
+			Path:  `emails[type eq "work"].value`,
+			Value: "work@example.com",
+		}})
+		require.NoError(t, err, err)
+		require.Equal(t, "work@example.com", user.Email)
+	})
+
+	t.Run("GivenAndFamilyName", func(t *testing.T) {
+		user := newUser()
+		err := applyPatch(user, []PatchOperation{{
+			Op:    "add",
+			Path:  "name",
+			Value: map[string]interface{}{"givenName": "Ada", "familyName": "Lovelace"},
+		}})
+		require.NoError(t, err, err)
+		require.Equal(t, "Ada Lovelace", user.Name)
+	})
+
+	t.Run("Deactivate", func(t *testing.T) {
+		for _, value := range []interface{}{false, "False"} {
+			err := applyPatch(newUser(), []PatchOperation{{Op: "replace", Path: "active", Value: value}})
+			require.ErrorIs(t, err, ErrNoDeactivation)
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		for _, path := range []string{"displayName", "name", "name.givenName", "externalId", `emails[type eq "work"].type`} {
+			user := newUser()
+			err := applyPatch(user, []PatchOperation{{Op: "remove", Path: path}})
+			require.NoError(t, err, path)
+			require.Equal(t, newUser(), user, path)
+		}
+
+		for _, path := range []string{"userName", "emails", `emails[type eq "work"]`, `emails[type eq "work"].value`, "active"} {
+			err := applyPatch(newUser(), []PatchOperation{{Op: "remove", Path: path}})
+			require.ErrorIs(t, err, ErrRemoveForbidden, path)
+		}
+
+		err := applyPatch(newUser(), []PatchOperation{{Op: "remove"}})
+		require.ErrorIs(t, err, ErrBadPatchPath)
+	})
+
+	t.Run("UnknownPath", func(t *testing.T) {
+		err := applyPatch(newUser(), []PatchOperation{{Op: "replace", Path: "nickName", Value: "x"}})
+		require.ErrorIs(t, err, ErrBadPatchPath)
+	})
+}
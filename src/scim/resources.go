@@ -0,0 +1,133 @@
+package scim
+
+import (
+	"strconv"
+
+	"go-user-service/src/repository/models"
+)
+
+const (
+	BasePath = "/scim/v2"
+
+	ContentType = "application/scim+json"
+
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// User is the SCIM core User resource. userName carries the user's email,
+// which is the only identifier the service has besides the numeric id.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	Id          string   `json:"id,omitempty"`
+	ExternalId  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []User   `json:"Resources"`
+}
+
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func fromModel(user *models.User) User {
+	active := true
+	id := strconv.Itoa(user.Id)
+
+	return User{
+		Schemas:     []string{SchemaUser},
+		Id:          id,
+		UserName:    user.Email,
+		Name:        &Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     BasePath + "/Users/" + id,
+		},
+	}
+}
+
+// toModel maps a SCIM user onto models.User. The display name wins over
+// name.formatted, which wins over the given and family names.
+func (u *User) toModel() *models.User {
+	user := &models.User{Email: u.UserName}
+
+	if user.Email == "" {
+		for _, email := range u.Emails {
+			if email.Primary || user.Email == "" {
+				user.Email = email.Value
+			}
+		}
+	}
+
+	switch {
+	case u.DisplayName != "":
+		user.Name = u.DisplayName
+	case u.Name != nil && u.Name.Formatted != "":
+		user.Name = u.Name.Formatted
+	case u.Name != nil:
+		user.Name = joinName(u.Name.GivenName, u.Name.FamilyName)
+	}
+
+	return user
+}
+
+func joinName(given, family string) string {
+	switch {
+	case given == "":
+		return family
+	case family == "":
+		return given
+	default:
+		return given + " " + family
+	}
+}
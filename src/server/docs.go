@@ -0,0 +1,595 @@
+package server
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"go-user-service/src/api/rest"
+	"go-user-service/src/gql"
+	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/openapi"
+	"go-user-service/src/repository/models"
+	"go-user-service/src/scim"
+)
+
+const (
+	specPath = "/openapi.json"
+	docsPath = "/docs"
+)
+
+// The docs page renders the spec with its own script and stylesheet, so
+// nothing is loaded from third parties. Both are inlined when the server
+// starts and the page only runs them by hash.
+var (
+	//go:embed docs.html
+	docsHTML string
+	//go:embed docs.js
+	docsScript string
+	//go:embed docs.css
+	docsStyle string
+)
+
+// docsPage returns the page and the Content-Security-Policy allowing exactly
+// its inline script and style, and requests to this origin.
+func docsPage() ([]byte, string) {
+	page := strings.NewReplacer("{{script}}", docsScript, "{{style}}", docsStyle).Replace(docsHTML)
+
+	csp := "default-src 'none'; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'; " +
+		"script-src " + cspHash(docsScript) + "; style-src " + cspHash(docsStyle)
+
+	return []byte(page), csp
+}
+
+func cspHash(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+var apiInfo = openapi.Info{
+	Title:   "User service",
+	Version: "1.0.0",
+}
+
+type object = map[string]interface{}
+
+// routes documents every HTTP route registered in New. TestRoutesDocumented
+// fails when the two drift apart.
+func routes() []openapi.Route {
+	idParam := map[string]string{"id": "integer"}
+	badRequest := openapi.Response{Status: fiber.StatusBadRequest, Body: rest.ErrorResponse{}}
+	notFound := openapi.Response{Status: fiber.StatusNotFound, Body: rest.ErrorResponse{}}
+	conflict := openapi.Response{Status: fiber.StatusConflict, Body: rest.ErrorResponse{}}
+	internal := openapi.Response{Status: fiber.StatusInternalServerError, Body: rest.ErrorResponse{}}
+	unauthorized := openapi.Response{Status: fiber.StatusUnauthorized, Body: rest.ErrorResponse{}}
+	forbidden := openapi.Response{Status: fiber.StatusForbidden, Body: rest.ErrorResponse{}}
+
+	scimError := func(status int) openapi.Response {
+		return openapi.Response{Status: status, Body: scim.ErrorResponse{}}
+	}
+
+	return []openapi.Route{
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users",
+			Summary: "Create a user",
+			Tag:     "users",
+			Request: rest.CreateRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusCreated, Body: rest.CreateResponse{}},
+				badRequest,
+				conflict,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/users",
+			Summary: "List users ordered by id, starting after the after query parameter",
+			Tag:     "users",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: rest.ListResponse{}},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:      fiber.MethodPost,
+			Path:        "/users\\:import",
+			Summary:     "Bulk import users from CSV (email,name header) or NDJSON; query: format, mode=insert|upsert, dry_run",
+			Tag:         "users",
+			ContentType: "text/csv",
+			Request:     "",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.ImportResponse{}, ContentType: fiber.MIMEApplicationJSON},
+				{Status: fiber.StatusBadRequest, Body: rest.ErrorResponse{}, ContentType: fiber.MIMEApplicationJSON},
+				{Status: fiber.StatusInternalServerError, Body: rest.ErrorResponse{}, ContentType: fiber.MIMEApplicationJSON},
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/users\\:export",
+			Summary: "Stream users as CSV, NDJSON (ending with a count line) or Parquet; query: format, email_domain, after",
+			Tag:     "users",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: "", ContentType: "text/csv"},
+				badRequest,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users\\:batchGet",
+			Summary: "Get up to 1000 users by id; results follow the request order",
+			Tag:     "users",
+			Request: handlers.BatchGetRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.BatchGetResponse{}},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users\\:batchCreate",
+			Summary: "Create up to 1000 users with per-item results, all-or-nothing when atomic",
+			Tag:     "users",
+			Request: handlers.BatchCreateRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.BatchResponse{}},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users\\:batchDelete",
+			Summary: "Delete up to 1000 users with per-item results, all-or-nothing when atomic",
+			Tag:     "users",
+			Request: handlers.BatchDeleteRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.BatchResponse{}},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/users/:id",
+			Summary: "Get a user",
+			Tag:     "users",
+			Params:  idParam,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				badRequest,
+				notFound,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPut,
+			Path:    "/users/:id",
+			Summary: "Replace a user's email and name; needs the user's session",
+			Tag:     "users",
+			Params:  idParam,
+			Request: rest.UpdateRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				conflict,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPatch,
+			Path:    "/users/:id",
+			Summary: "Change a user's email; needs the user's session",
+			Tag:     "users",
+			Params:  idParam,
+			Request: rest.UpdateEmailRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				{Status: fiber.StatusAccepted, Description: "The new email waits for confirmation", Body: models.User{}},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				conflict,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users/:id/email/confirm",
+			Summary: "Confirm a pending email change with the mailed token; needs the user's session",
+			Tag:     "users",
+			Params:  idParam,
+			Request: rest.EmailTokenRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				badRequest,
+				unauthorized,
+				forbidden,
+				conflict,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users/:id/email/revert",
+			Summary: "Restore the email a change replaced, with the token mailed to it",
+			Tag:     "users",
+			Params:  idParam,
+			Request: rest.EmailTokenRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				badRequest,
+				conflict,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodDelete,
+			Path:    "/users/:id",
+			Summary: "Delete a user; needs the user's session",
+			Tag:     "users",
+			Params:  idParam,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				internal,
+			},
+		},
+		{
+			Method:          fiber.MethodPost,
+			Path:            "/users/:id/mfa/totp",
+			Summary:         "Start TOTP enrollment, returning the secret as an otpauth URI and QR code; needs the user's session, and a code when MFA is enabled",
+			Tag:             "users",
+			Params:          idParam,
+			Request:         handlers.MFACodeRequest{},
+			OptionalRequest: true,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.TOTPEnrollmentResponse{}},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/users/:id/mfa/totp/verify",
+			Summary: "Enable TOTP with a code from the enrolled secret, ending all sessions and returning recovery codes; needs the user's session",
+			Tag:     "users",
+			Params:  idParam,
+			Request: handlers.MFACodeRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.RecoveryCodesResponse{}},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				internal,
+			},
+		},
+		{
+			Method:          fiber.MethodDelete,
+			Path:            "/users/:id/mfa/totp",
+			Summary:         "Disable TOTP and drop the recovery codes; needs the user's session and, when MFA is enabled, a code",
+			Tag:             "users",
+			Params:          idParam,
+			Request:         handlers.MFACodeRequest{},
+			OptionalRequest: true,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusNoContent},
+				badRequest,
+				unauthorized,
+				forbidden,
+				notFound,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/login",
+			Summary: "Log in with email and password",
+			Tag:     "auth",
+			Request: handlers.LoginRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.SessionResponse{}},
+				{Status: fiber.StatusAccepted, Description: "MFA is enabled: complete the login at /auth/login/mfa", Body: handlers.MFAChallengeResponse{}},
+				badRequest,
+				unauthorized,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/login/mfa",
+			Summary: "Complete a login with a TOTP or recovery code",
+			Tag:     "auth",
+			Request: handlers.MFALoginRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.SessionResponse{}},
+				badRequest,
+				unauthorized,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/refresh",
+			Summary: "Replace a session with a new one using its refresh token",
+			Tag:     "auth",
+			Request: handlers.RefreshRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: handlers.SessionResponse{}},
+				badRequest,
+				unauthorized,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/auth/session",
+			Summary: "Get the user the bearer token authenticates",
+			Tag:     "auth",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: models.User{}},
+				unauthorized,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/logout",
+			Summary: "End the session of the bearer token",
+			Tag:     "auth",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusNoContent},
+				unauthorized,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/password/forgot",
+			Summary: "Mail a password reset link to the email's user, if any",
+			Tag:     "auth",
+			Request: handlers.ForgotPasswordRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusAccepted, Description: "Answered whether or not the email belongs to a user"},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/auth/password/reset",
+			Summary: "Set a new password with a mailed reset token, ending all sessions",
+			Tag:     "auth",
+			Request: handlers.ResetPasswordRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusNoContent},
+				badRequest,
+				internal,
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    livenessPath,
+			Summary: "Liveness probe: the process is serving HTTP",
+			Tag:     "operations",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: health.Report{}},
+			},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    readinessPath,
+			Summary: "Readiness probe: database reachable, migrations applied and not shutting down",
+			Tag:     "operations",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: health.Report{}},
+				{Status: fiber.StatusServiceUnavailable, Body: health.Report{}},
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        metricsPath,
+			Summary:     "Prometheus metrics",
+			Tag:         "operations",
+			ContentType: "text/plain",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: ""},
+			},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/graphql",
+			Summary: "Execute a GraphQL query or mutation",
+			Tag:     "graphql",
+			Request: gql.Request{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				{Status: fiber.StatusBadRequest, Body: gql.ErrorResponse{}},
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/Users",
+			Summary:     "List or filter users",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: scim.ListResponse{}},
+				scimError(fiber.StatusBadRequest),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodPost,
+			Path:        scim.BasePath + "/Users",
+			Summary:     "Provision a user",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Request:     scim.User{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusCreated, Body: scim.User{}},
+				scimError(fiber.StatusBadRequest),
+				scimError(fiber.StatusConflict),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/Users/:id",
+			Summary:     "Get a provisioned user",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: scim.User{}},
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodPut,
+			Path:        scim.BasePath + "/Users/:id",
+			Summary:     "Replace a provisioned user",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Request:     scim.User{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: scim.User{}},
+				scimError(fiber.StatusBadRequest),
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodPatch,
+			Path:        scim.BasePath + "/Users/:id",
+			Summary:     "Patch a provisioned user",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Request:     scim.PatchRequest{},
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: scim.User{}},
+				scimError(fiber.StatusBadRequest),
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodDelete,
+			Path:        scim.BasePath + "/Users/:id",
+			Summary:     "Deprovision a user",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusNoContent},
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/ServiceProviderConfig",
+			Summary:     "Describe supported SCIM features",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/ResourceTypes",
+			Summary:     "List SCIM resource types",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/ResourceTypes/:id",
+			Summary:     "Get a SCIM resource type",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/Schemas",
+			Summary:     "List SCIM schemas",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:      fiber.MethodGet,
+			Path:        scim.BasePath + "/Schemas/:id",
+			Summary:     "Get a SCIM schema",
+			Tag:         "scim",
+			ContentType: scim.ContentType,
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: object{}},
+				scimError(fiber.StatusNotFound),
+				scimError(fiber.StatusUnauthorized),
+			},
+		},
+		{
+			Method:    fiber.MethodGet,
+			Path:      specPath,
+			Summary:   "This OpenAPI document",
+			Tag:       "docs",
+			Responses: []openapi.Response{{Status: fiber.StatusOK, Body: object{}}},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    docsPath,
+			Summary: "Interactive API documentation",
+			Tag:     "docs",
+			Responses: []openapi.Response{
+				{Status: fiber.StatusOK, Body: "", ContentType: fiber.MIMETextHTMLCharsetUTF8},
+			},
+		},
+	}
+}
+
+func registerDocs(app *fiber.App) {
+	spec := openapi.Build(apiInfo, routes())
+
+	app.Get(specPath, func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(spec)
+	})
+
+	page, csp := docsPage()
+	app.Get(docsPath, func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		c.Set(fiber.HeaderContentSecurityPolicy, csp)
+		return c.Status(fiber.StatusOK).Send(page)
+	})
+}
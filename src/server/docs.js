@@ -0,0 +1,157 @@
+"use strict";
+
+// Renders /openapi.json without third-party code. Everything taken from the
+// document is inserted as text, never as markup.
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    node.setAttribute(key, value);
+  }
+  for (const child of children) {
+    node.append(child);
+  }
+  return node;
+}
+
+// describe turns a schema into a readable type, resolving component refs.
+function describe(schema, components, depth = 0) {
+  if (!schema) {
+    return "any";
+  }
+  if (schema.$ref) {
+    const name = schema.$ref.split("/").pop();
+    return depth > 2 ? name : describe(components[name], components, depth + 1);
+  }
+  if (schema.type === "array") {
+    return [describe(schema.items, components, depth + 1)];
+  }
+  if (schema.properties) {
+    const required = schema.required || [];
+    return Object.fromEntries(Object.entries(schema.properties).map(([name, prop]) =>
+      [required.includes(name) ? name : name + "?", describe(prop, components, depth + 1)]));
+  }
+  if (schema.additionalProperties) {
+    return { "*": describe(schema.additionalProperties, components, depth + 1) };
+  }
+  return [].concat(schema.type || "any").join(" | ") + (schema.format ? " (" + schema.format + ")" : "");
+}
+
+function schemaBlock(content, components) {
+  const [type, media] = Object.entries(content)[0];
+  return el("div", {}, el("code", {}, type), el("pre", {}, JSON.stringify(describe(media.schema, components), null, 2)));
+}
+
+function tryIt(method, path, op) {
+  const form = el("form");
+  const params = (op.parameters || []).map((param) => {
+    const input = el("input", { name: param.name, placeholder: param.in });
+    form.append(el("label", {}, param.name + " ", input), " ");
+    return [param, input];
+  });
+
+  let body;
+  if (op.requestBody) {
+    body = el("textarea", { placeholder: "request body" });
+    form.append(body);
+  }
+
+  const output = el("pre");
+  form.append(el("button", { type: "submit" }, "Send"), output);
+
+  form.addEventListener("submit", async (event) => {
+    event.preventDefault();
+
+    let url = path;
+    const query = new URLSearchParams();
+    for (const [param, input] of params) {
+      if (param.in === "path") {
+        url = url.replace("{" + param.name + "}", encodeURIComponent(input.value));
+      } else if (input.value !== "") {
+        query.append(param.name, input.value);
+      }
+    }
+    if (query.toString() !== "") {
+      url += "?" + query;
+    }
+
+    const headers = {};
+    const token = document.getElementById("token").value;
+    if (token) {
+      headers.Authorization = "Bearer " + token;
+    }
+    if (body) {
+      headers["Content-Type"] = Object.keys(op.requestBody.content)[0];
+    }
+
+    try {
+      const resp = await fetch(url, { method: method.toUpperCase(), headers, body: body ? body.value : undefined });
+      output.textContent = resp.status + " " + resp.statusText + "\n\n" + await resp.text();
+    } catch (err) {
+      output.textContent = String(err);
+    }
+  });
+
+  return form;
+}
+
+function operation(method, path, op, components) {
+  const body = el("div", { class: "body" });
+
+  if (op.parameters) {
+    const table = el("table", {}, el("tr", {}, el("th", {}, "Parameter"), el("th", {}, "In"), el("th", {}, "Type")));
+    for (const param of op.parameters) {
+      table.append(el("tr", {}, el("td", {}, param.name + (param.required ? "" : "?")), el("td", {}, param.in),
+        el("td", {}, JSON.stringify(describe(param.schema, components)))));
+    }
+    body.append(table);
+  }
+
+  if (op.requestBody) {
+    body.append(el("h4", {}, "Request"), schemaBlock(op.requestBody.content, components));
+  }
+
+  body.append(el("h4", {}, "Responses"));
+  for (const [status, resp] of Object.entries(op.responses)) {
+    body.append(el("div", {}, el("strong", {}, status + " "), resp.description));
+    if (resp.content) {
+      body.append(schemaBlock(resp.content, components));
+    }
+  }
+
+  body.append(el("h4", {}, "Try it"), tryIt(method, path, op));
+
+  return el("details", {},
+    el("summary", {}, el("span", { class: "method " + method }, method), el("code", {}, path), " " + (op.summary || "")),
+    body);
+}
+
+async function render() {
+  const main = document.getElementById("operations");
+
+  let doc;
+  try {
+    doc = await (await fetch("/openapi.json")).json();
+  } catch (err) {
+    main.textContent = "Cannot load /openapi.json: " + err;
+    return;
+  }
+
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  const components = (doc.components && doc.components.schemas) || {};
+
+  const tags = new Map();
+  for (const path of Object.keys(doc.paths).sort()) {
+    for (const [method, op] of Object.entries(doc.paths[path])) {
+      const tag = (op.tags || ["other"])[0];
+      if (!tags.has(tag)) {
+        tags.set(tag, el("section", {}, el("h2", {}, tag)));
+      }
+      tags.get(tag).append(operation(method, path, op, components));
+    }
+  }
+
+  main.append(...tags.values());
+}
+
+render();
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"go-user-service/src/gql"
+	"go-user-service/src/grpchandlers"
+	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/metrics"
+	"go-user-service/src/openapi"
+	"go-user-service/src/scim"
+)
+
+func newDocsServer(t *testing.T) *Server {
+	graphqlHandler, err := gql.New(nil)
+	require.NoError(t, err, err)
+
+	return New(Config{}, zap.NewNop(), metrics.New(), health.NewRegistry(0), Handlers{
+		HTTP:    handlers.New(nil),
+		GRPC:    grpchandlers.New(nil),
+		GraphQL: graphqlHandler,
+		SCIM:    scim.New(nil, scim.Config{}),
+	}, Options{})
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	server := newDocsServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, specPath, nil)
+	resp, err := server.app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var doc openapi.Document
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+	require.NoError(t, err)
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+
+	registered := make(map[string]bool)
+	for _, route := range server.app.GetRoutes(true) {
+		// Fiber adds a HEAD route for every GET route.
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+
+		key := route.Method + " " + openapi.PathOf(route.Path)
+		registered[key] = true
+
+		op := doc.Paths[openapi.PathOf(route.Path)][strings.ToLower(route.Method)]
+		assert.NotNil(t, op, "route %s is not documented in routes()", key)
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			key := strings.ToUpper(method) + " " + path
+			assert.True(t, registered[key], "documented route %s is not registered", key)
+		}
+	}
+}
+
+func TestDocsPage(t *testing.T) {
+	server := newDocsServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, docsPath, nil)
+	resp, err := server.app.Test(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), "text/html")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Everything the page runs is served with it.
+	page := string(body)
+	assert.NotContains(t, page, "{{")
+	assert.NotRegexp(t, `(src|href)=`, page)
+	assert.Contains(t, page, docsScript)
+	assert.Contains(t, page, docsStyle)
+
+	csp := resp.Header.Get(fiber.HeaderContentSecurityPolicy)
+	assert.Contains(t, csp, "default-src 'none'")
+	assert.Contains(t, csp, "script-src "+cspHash(docsScript))
+	assert.Contains(t, csp, "style-src "+cspHash(docsStyle))
+}
@@ -2,49 +2,421 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
+	"go-user-service/src/api/userpb"
+	"go-user-service/src/gql"
 	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/logging"
+	"go-user-service/src/mailer"
+	"go-user-service/src/metrics"
+	"go-user-service/src/ratelimit"
+	"go-user-service/src/scim"
+	"go-user-service/src/tracing"
+)
+
+const (
+	DefaultShutdownTimeout = 30 * time.Second
+
+	FeatureGraphQL = "graphql"
+	FeatureSCIM    = "scim"
+	FeatureAuth    = "auth"
+
+	metricsPath   = "/metrics"
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
 )
 
 type Config struct {
-	Port string
+	Port string `mapstructure:"port" yaml:"port"`
+	// GrpcPort is empty to disable the gRPC server.
+	GrpcPort string `mapstructure:"grpc_port" yaml:"grpc_port"`
+	// HealthTimeout bounds each readiness check; health.DefaultTimeout when zero.
+	HealthTimeout time.Duration `mapstructure:"health_timeout" yaml:"health_timeout"`
+	// DrainDelay is how long the server keeps serving after readiness starts
+	// failing, so load balancers stop routing to it before listeners close.
+	DrainDelay time.Duration `mapstructure:"drain_delay" yaml:"drain_delay"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish;
+	// DefaultShutdownTimeout when zero.
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout"`
+	// TLS secures the HTTP listener; plain HTTP when no certificate is set.
+	TLS TLSConfig `mapstructure:"tls" yaml:"tls"`
+
+	// The settings below can change at runtime, see Reload.
+
+	// CORSOrigins lists the origins, like https://admin.example.com, browsers
+	// may call the HTTP API from; "*" allows any. Empty disables CORS.
+	CORSOrigins []string `mapstructure:"cors_origins" yaml:"cors_origins"`
+	// DisabledFeatures lists optional APIs, out of Features, that answer 404.
+	DisabledFeatures []string `mapstructure:"disabled_features" yaml:"disabled_features"`
+}
+
+// Features are the optional APIs that can be switched off at runtime.
+var Features = []string{FeatureGraphQL, FeatureSCIM, FeatureAuth}
+
+// Validate reports every invalid setting, one error per key.
+func (c Config) Validate() error {
+	var errs []error
+	if !validPort(c.Port) {
+		errs = append(errs, fmt.Errorf("port: must be a number between 1 and 65535, got %q", c.Port))
+	}
+
+	if c.GrpcPort != "" && !validPort(c.GrpcPort) {
+		errs = append(errs, fmt.Errorf("grpc_port: must be empty or a number between 1 and 65535, got %q", c.GrpcPort))
+	}
+
+	if c.GrpcPort != "" && c.GrpcPort == c.Port {
+		errs = append(errs, fmt.Errorf("grpc_port: must differ from port %s", c.Port))
+	}
+
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"health_timeout", c.HealthTimeout},
+		{"drain_delay", c.DrainDelay},
+		{"shutdown_timeout", c.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative, got %s", d.key, d.value))
+		}
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		for _, cause := range err.(interface{ Unwrap() []error }).Unwrap() {
+			errs = append(errs, fmt.Errorf("tls.%w", cause))
+		}
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if !validOrigin(origin) {
+			errs = append(errs, fmt.Errorf("cors_origins: must be \"*\" or scheme://host[:port], got %q", origin))
+		}
+	}
+
+	for _, feature := range c.DisabledFeatures {
+		if !slices.Contains(Features, feature) {
+			errs = append(errs, fmt.Errorf("disabled_features: must be one of %s, got %q", strings.Join(Features, ", "), feature))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.User == nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
 }
 
 type Server struct {
 	cfg Config
+	// reloadable holds the latest configuration passed to Reload.
+	reloadable atomic.Pointer[Config]
 
 	app     *fiber.App
 	handler *handlers.Handler
 
+	grpcServer *grpc.Server
+	grpcHealth *grpchealth.Server
+	health     *health.Registry
+
 	logger *zap.Logger
 }
 
-func New(cfg Config, logger *zap.Logger, handler *handlers.Handler) *Server {
-	app := fiber.New()
+// Handlers serve the APIs of a Server, one for each protocol.
+type Handlers struct {
+	HTTP    *handlers.Handler
+	GRPC    userpb.UserServiceServer
+	GraphQL *gql.Handler
+	SCIM    *scim.Handler
+}
 
-	app.Post("/users", handler.Create)
-	app.Get("/users/:id", handler.Get)
-	app.Put("/users/:id", handler.Update)
-	app.Patch("/users/:id", handler.UpdateEmail)
-	app.Delete("/users/:id", handler.Delete)
+// Options holds the optional dependencies of a Server.
+type Options struct {
+	// Limiter rate limits requests; when nil they are unlimited.
+	Limiter *ratelimit.Limiter
+}
+
+func New(cfg Config, logger *zap.Logger, metrics *metrics.Metrics, health *health.Registry, apis Handlers, opts Options) *Server {
+	handler, grpcHandler, graphqlHandler, scimHandler := apis.HTTP, apis.GRPC, apis.GraphQL, apis.SCIM
 
-	return &Server{
+	app := fiber.New(fiber.Config{
+		// Lets imports read bodies larger than the body limit as a stream.
+		StreamRequestBody: true,
+	})
+
+	s := &Server{
 		cfg:     cfg,
 		app:     app,
 		handler: handler,
+		health:  health,
 		logger:  logger,
 	}
+	s.reloadable.Store(&cfg)
+
+	// Probes are registered ahead of the middleware so frequent polling
+	// does not flood the access log, traces and request metrics.
+	app.Get(livenessPath, health.Liveness)
+	app.Get(readinessPath, health.Readiness)
+
+	if cfg.TLS.ClientCAFile != "" {
+		app.Use(requireClientCert)
+	}
+
+	app.Use(tracing.Middleware(), logging.Middleware(logger), metrics.Middleware(), mailer.Middleware())
+	app.Use(cors.New(cors.Config{
+		AllowOriginsFunc: s.allowOrigin,
+		AllowHeaders:     "Authorization, Content-Type, " + logging.HeaderRequestID + ", " + ratelimit.HeaderAPIKey,
+		ExposeHeaders: strings.Join([]string{
+			logging.HeaderRequestID,
+			ratelimit.HeaderLimit,
+			ratelimit.HeaderRemaining,
+			ratelimit.HeaderReset,
+			ratelimit.HeaderPolicy,
+			fiber.HeaderRetryAfter,
+		}, ", "),
+	}))
+
+	if opts.Limiter != nil {
+		app.Use(opts.Limiter.Middleware())
+	}
+	app.Get(metricsPath, metrics.Handler())
+
+	app.Post("/users", handler.Create)
+	app.Get("/users", handler.List)
+	app.Post("/users\\:import", handler.Import)
+	app.Get("/users\\:export", handler.Export)
+	app.Post("/users\\:batchGet", handler.BatchGet)
+	app.Post("/users\\:batchCreate", handler.BatchCreate)
+	app.Post("/users\\:batchDelete", handler.BatchDelete)
+	app.Get("/users/:id", handler.Get)
+	app.Put("/users/:id", s.requireOwner, handler.Update)
+	app.Patch("/users/:id", s.requireOwner, handler.UpdateEmail)
+	app.Post("/users/:id/email/confirm", s.requireOwner, handler.ConfirmEmail)
+	// The revert token alone authorizes a revert: it goes to the address an
+	// attacker replaced, whose owner has no session left.
+	app.Post("/users/:id/email/revert", handler.RevertEmail)
+	app.Delete("/users/:id", s.requireOwner, handler.Delete)
+	app.Post("/users/:id/mfa/totp", s.requireFeature(FeatureAuth), handler.RequireSelf, handler.EnrollTOTP)
+	app.Post("/users/:id/mfa/totp/verify", s.requireFeature(FeatureAuth), handler.RequireSelf, handler.ConfirmTOTP)
+	app.Delete("/users/:id/mfa/totp", s.requireFeature(FeatureAuth), handler.RequireSelf, handler.DisableTOTP)
+
+	authApi := app.Group("/auth", s.requireFeature(FeatureAuth))
+	authApi.Post("/login", handler.Login)
+	authApi.Post("/login/mfa", handler.LoginMFA)
+	authApi.Post("/refresh", handler.Refresh)
+	authApi.Get("/session", handler.Session)
+	authApi.Post("/logout", handler.Logout)
+	authApi.Post("/password/forgot", handler.ForgotPassword)
+	authApi.Post("/password/reset", handler.ResetPassword)
+
+	app.Post("/graphql", s.requireFeature(FeatureGraphQL), graphqlHandler.Serve)
+
+	scimApi := app.Group(scim.BasePath, s.requireFeature(FeatureSCIM), scimHandler.Authenticate)
+	scimApi.Get("/Users", scimHandler.List)
+	scimApi.Post("/Users", scimHandler.Create)
+	scimApi.Get("/Users/:id", scimHandler.Get)
+	scimApi.Put("/Users/:id", scimHandler.Replace)
+	scimApi.Patch("/Users/:id", scimHandler.Patch)
+	scimApi.Delete("/Users/:id", scimHandler.Delete)
+	scimApi.Get("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	scimApi.Get("/ResourceTypes", scimHandler.ResourceTypes)
+	scimApi.Get("/ResourceTypes/:id", scimHandler.ResourceType)
+	scimApi.Get("/Schemas", scimHandler.Schemas)
+	scimApi.Get("/Schemas/:id", scimHandler.Schema)
+
+	registerDocs(app)
+
+	grpcServer := grpc.NewServer()
+	userpb.RegisterUserServiceServer(grpcServer, grpcHandler)
+
+	healthServer := grpchealth.NewServer()
+	healthServer.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	reflection.Register(grpcServer)
+
+	s.grpcServer = grpcServer
+	s.grpcHealth = healthServer
+
+	return s
+}
+
+// Reload applies the settings of cfg that can change at runtime, CORSOrigins
+// and DisabledFeatures; changes to the others need a restart.
+func (s *Server) Reload(cfg Config) {
+	s.reloadable.Store(&cfg)
+}
+
+func (s *Server) allowOrigin(origin string) bool {
+	for _, allowed := range s.reloadable.Load().CORSOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requireOwner guards the routes changing a user. With a client CA every
+// request passed requireClientCert and comes from a trusted service, so it
+// is let through; otherwise the request needs the auth feature and a
+// session of the user in :id.
+func (s *Server) requireOwner(c *fiber.Ctx) error {
+	if s.cfg.TLS.ClientCAFile != "" {
+		return c.Next()
+	}
+
+	if slices.Contains(s.reloadable.Load().DisabledFeatures, FeatureAuth) {
+		return fiber.ErrNotFound
+	}
+
+	return s.handler.RequireSelf(c)
+}
+
+// requireFeature answers 404 while feature is disabled.
+func (s *Server) requireFeature(feature string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if slices.Contains(s.reloadable.Load().DisabledFeatures, feature) {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}
 }
 
+// Start serves HTTP and, when a gRPC port is configured, gRPC. It returns
+// once either listener stops.
 func (s *Server) Start() error {
-	s.logger.Info(fmt.Sprintf("server listening on port %s", s.cfg.Port))
-	return s.app.Listen(":" + s.cfg.Port)
+	errCh := make(chan error, 2)
+
+	if s.cfg.GrpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+s.cfg.GrpcPort)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			s.logger.Info(fmt.Sprintf("grpc server listening on port %s", s.cfg.GrpcPort))
+			errCh <- s.grpcServer.Serve(lis)
+		}()
+	}
+
+	lis, scheme, err := s.listenHTTP()
+	if err != nil {
+		// Stop closes the gRPC listener too, even if Serve has yet to run.
+		s.grpcServer.Stop()
+		return err
+	}
+
+	go func() {
+		s.logger.Info(fmt.Sprintf("server listening on port %s (%s)", s.cfg.Port, scheme))
+		errCh <- s.app.Listener(lis)
+	}()
+
+	return <-errCh
 }
 
+func (s *Server) listenHTTP() (net.Listener, string, error) {
+	lis, err := net.Listen("tcp", ":"+s.cfg.Port)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !s.cfg.TLS.Enabled() {
+		return lis, "http", nil
+	}
+
+	tlsConfig, err := newTLSConfig(s.cfg.TLS, s.logger)
+	if err != nil {
+		lis.Close()
+		return nil, "", err
+	}
+
+	return tls.NewListener(lis, tlsConfig), "https", nil
+}
+
+// HTTPHandler exposes the HTTP API as a net/http handler, e.g. for httptest.
+func (s *Server) HTTPHandler() http.HandlerFunc {
+	return adaptor.FiberApp(s.app)
+}
+
+// Drain reports the service as not ready, on /readyz and through the gRPC
+// health service, then keeps serving for DrainDelay or until ctx is done.
+func (s *Server) Drain(ctx context.Context) error {
+	s.health.SetShuttingDown()
+	s.grpcHealth.Shutdown()
+
+	timer := time.NewTimer(s.cfg.DrainDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownTimeout is how long Shutdown should be given to finish in-flight
+// requests.
+func (s *Server) ShutdownTimeout() time.Duration {
+	if s.cfg.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+
+	return s.cfg.ShutdownTimeout
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests and
+// streams until ctx is done, then closes whatever is left.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.app.ShutdownWithContext(ctx)
+	s.health.SetShuttingDown()
+	s.grpcHealth.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	err := s.app.ShutdownWithContext(ctx)
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+	}
+
+	return err
 }
@@ -3,9 +3,11 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -18,16 +20,23 @@ import (
 	testpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
 	"go.uber.org/zap"
 
+	"go-user-service/src/api/rest"
 	"go-user-service/src/controllers"
+	"go-user-service/src/gql"
+	"go-user-service/src/grpchandlers"
 	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/mailer"
+	"go-user-service/src/metrics"
 	"go-user-service/src/repository"
 	"go-user-service/src/repository/models"
 	"go-user-service/src/repository/postgres"
+	"go-user-service/src/scim"
 )
 
 type shutDown = func() error
 
-func setupApp() (*Server, shutDown, error) {
+func setupApp() (*Server, *postgres.Repository, shutDown, error) {
 	ctx := context.Background()
 
 	testUser := "user"
@@ -44,17 +53,17 @@ func setupApp() (*Server, shutDown, error) {
 		testcontainers.WithLogger(testcontainers.Logger),
 	)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	dbHost, err := pgContainer.Host(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	dbPortStr, err := pgContainer.MappedPort(ctx, "5432")
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	repo, err := postgres.NewRepository(repository.Config{
@@ -66,7 +75,7 @@ func setupApp() (*Server, shutDown, error) {
 		SslMode:  "disable",
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	logger, err := zap.NewProduction()
@@ -75,9 +84,27 @@ func setupApp() (*Server, shutDown, error) {
 	}
 	defer logger.Sync()
 
-	userController := controllers.New(repo)
+	templates, err := mailer.LoadTemplates("", "en")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	auth := controllers.NewAuth(controllers.AuthConfig{SessionTTL: time.Hour, RefreshTTL: time.Hour}, mailer.NewSender(templates, mailer.NewLogMailer(logger)))
+	userController := controllers.New(repo, controllers.Options{Auth: auth})
 	userHandler := handlers.New(userController)
-	microservice := New(Config{Port: "8081"}, logger, userHandler)
+	grpcHandler := grpchandlers.New(userController)
+	graphqlHandler, err := gql.New(userController)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	scimHandler := scim.New(userController, scim.Config{})
+	microservice := New(Config{Port: "8081"}, logger, metrics.New(), health.NewRegistry(0), Handlers{
+		HTTP:    userHandler,
+		GRPC:    grpcHandler,
+		GraphQL: graphqlHandler,
+		SCIM:    scimHandler,
+	}, Options{})
 	go microservice.Start()
 
 	shutDown := func() error {
@@ -89,15 +116,22 @@ func setupApp() (*Server, shutDown, error) {
 		return err
 	}
 
-	return microservice, shutDown, err
+	return microservice, repo, shutDown, err
 }
 
 func TestUser(t *testing.T) {
-	server, shudDown, err := setupApp()
+	server, repo, shudDown, err := setupApp()
 	require.NoError(t, err, err)
 	defer shudDown()
 
-	user := handlers.CreateRequest{
+	// Changing a user needs their session.
+	const sessionToken = "test-session"
+	withSession := func(req *http.Request) *http.Request {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+sessionToken)
+		return req
+	}
+
+	user := rest.CreateRequest{
 		Email: "test@example.com",
 		Name:  "Test User",
 	}
@@ -112,11 +146,21 @@ func TestUser(t *testing.T) {
 
 		assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
 
-		var createResp handlers.CreateResponse
+		var createResp rest.CreateResponse
 		err = json.NewDecoder(resp.Body).Decode(&createResp)
 		require.NoError(t, err)
 		assert.NotZero(t, createResp.Id)
 		userId = createResp.Id
+
+		tokenHash := sha256.Sum256([]byte(sessionToken))
+		err = repo.CreateSession(context.Background(), &repository.Session{
+			UserId:           userId,
+			TokenHash:        tokenHash[:],
+			RefreshHash:      []byte("test-refresh"),
+			ExpiresAt:        time.Now().Add(time.Hour),
+			RefreshExpiresAt: time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err, err)
 	})
 
 	t.Run("Get", func(t *testing.T) {
@@ -133,7 +177,7 @@ func TestUser(t *testing.T) {
 	})
 
 	t.Run("Update", func(t *testing.T) {
-		user := handlers.UpdateRequest{
+		user := rest.UpdateRequest{
 			Email: "updated@example.com",
 			Name:  "Updated User",
 		}
@@ -143,6 +187,12 @@ func TestUser(t *testing.T) {
 		req.Header.Set("Content-Type", "application/json")
 		resp, err := server.app.Test(req)
 		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+		req = httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%d", userId), bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = server.app.Test(withSession(req))
+		require.NoError(t, err)
 
 		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 
@@ -155,14 +205,14 @@ func TestUser(t *testing.T) {
 
 	t.Run("UpdateEmail", func(t *testing.T) {
 		email := "new-email@example.com"
-		user := handlers.UpdateEmailRequest{
+		user := rest.UpdateEmailRequest{
 			Email: &email,
 		}
 		body, _ := json.Marshal(user)
 
 		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%d", userId), bytes.NewReader(body))
 		req.Header.Set("Content-Type", "application/json")
-		resp, err := server.app.Test(req)
+		resp, err := server.app.Test(withSession(req))
 		require.NoError(t, err)
 
 		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
@@ -175,9 +225,161 @@ func TestUser(t *testing.T) {
 
 	t.Run("Delete", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d", userId), nil)
-		resp, err := server.app.Test(req)
+		resp, err := server.app.Test(withSession(req))
 		require.NoError(t, err)
 
 		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 	})
 }
+
+func TestRequireOwner(t *testing.T) {
+	server := newDocsServer(t)
+
+	routes := []struct {
+		method, path string
+	}{
+		{http.MethodPut, "/users/1"},
+		{http.MethodPatch, "/users/1"},
+		{http.MethodPost, "/users/1/email/confirm"},
+		{http.MethodDelete, "/users/1"},
+	}
+	status := func(method, path string) int {
+		resp, err := server.app.Test(httptest.NewRequest(method, path, nil))
+		require.NoError(t, err, err)
+		return resp.StatusCode
+	}
+
+	for _, route := range routes {
+		require.Equal(t, fiber.StatusUnauthorized, status(route.method, route.path), route)
+	}
+
+	server.Reload(Config{DisabledFeatures: []string{FeatureAuth}})
+	for _, route := range routes {
+		require.Equal(t, fiber.StatusNotFound, status(route.method, route.path), route)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	graphqlHandler, err := gql.New(nil)
+	require.NoError(t, err, err)
+
+	cfg := Config{Port: "8082", DrainDelay: 200 * time.Millisecond, ShutdownTimeout: time.Second}
+	microservice := New(cfg, zap.NewNop(), metrics.New(), health.NewRegistry(0), Handlers{
+		HTTP:    handlers.New(nil),
+		GRPC:    grpchandlers.New(nil),
+		GraphQL: graphqlHandler,
+		SCIM:    scim.New(nil, scim.Config{}),
+	}, Options{})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- microservice.Start()
+	}()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://127.0.0.1:8082" + readinessPath)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == fiber.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- microservice.Drain(context.Background())
+	}()
+
+	// While draining the server keeps serving but reports not ready. The
+	// condition runs on another goroutine, so it reports failed requests by
+	// returning false; the requests below assert the server still answers.
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://127.0.0.1:8082" + readinessPath)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == fiber.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get("http://127.0.0.1:8082" + readinessPath)
+	require.NoError(t, err, err)
+	resp.Body.Close()
+	require.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = http.Get("http://127.0.0.1:8082" + livenessPath)
+	require.NoError(t, err, err)
+	resp.Body.Close()
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	require.NoError(t, <-drained)
+
+	// The client may hold connections it dialed but never sent a request
+	// on, which fasthttp only counts as idle after five seconds.
+	http.DefaultClient.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), microservice.ShutdownTimeout())
+	defer cancel()
+	require.NoError(t, microservice.Shutdown(ctx))
+	require.NoError(t, <-errCh)
+}
+
+func TestStartStopsGrpcOnHTTPError(t *testing.T) {
+	graphqlHandler, err := gql.New(nil)
+	require.NoError(t, err, err)
+
+	taken, err := net.Listen("tcp", ":8083")
+	require.NoError(t, err, err)
+	defer taken.Close()
+
+	cfg := Config{Port: "8083", GrpcPort: "8084"}
+	microservice := New(cfg, zap.NewNop(), metrics.New(), health.NewRegistry(0), Handlers{
+		HTTP:    handlers.New(nil),
+		GRPC:    grpchandlers.New(nil),
+		GraphQL: graphqlHandler,
+		SCIM:    scim.New(nil, scim.Config{}),
+	}, Options{})
+	require.Error(t, microservice.Start())
+
+	// The gRPC port is released once its listener is closed.
+	require.Eventually(t, func() bool {
+		lis, err := net.Listen("tcp", ":8084")
+		if err != nil {
+			return false
+		}
+
+		lis.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestReload(t *testing.T) {
+	server := newDocsServer(t)
+
+	corsRequest := func() *http.Response {
+		req := httptest.NewRequest(http.MethodOptions, "/users", nil)
+		req.Header.Set(fiber.HeaderOrigin, "https://admin.example.com")
+		req.Header.Set(fiber.HeaderAccessControlRequestMethod, http.MethodPost)
+		resp, err := server.app.Test(req)
+		require.NoError(t, err, err)
+
+		return resp
+	}
+
+	require.Empty(t, corsRequest().Header.Get(fiber.HeaderAccessControlAllowOrigin))
+
+	server.Reload(Config{
+		CORSOrigins:      []string{"https://admin.example.com"},
+		DisabledFeatures: []string{FeatureGraphQL, FeatureSCIM},
+	})
+	require.Equal(t, "https://admin.example.com", corsRequest().Header.Get(fiber.HeaderAccessControlAllowOrigin))
+
+	for _, path := range []string{"/graphql", scim.BasePath + "/Users"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		resp, err := server.app.Test(req)
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusNotFound, resp.StatusCode, path)
+	}
+}
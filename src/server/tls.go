@@ -0,0 +1,258 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+
+	// certCheckInterval throttles how often handshakes look for renewed
+	// certificate files.
+	certCheckInterval = 10 * time.Second
+)
+
+var (
+	ErrClientCertRequired = errors.New("client certificate required")
+	ErrClientNotAllowed   = errors.New("client certificate subject not allowed")
+)
+
+var tlsVersions = map[string]uint16{
+	TLSVersion12: tls.VersionTLS12,
+	TLSVersion13: tls.VersionTLS13,
+}
+
+// subjectFields are the certificate fields client subject rules can match.
+var subjectFields = []string{"CN", "DNS", "URI"}
+
+type TLSConfig struct {
+	// CertFile and KeyFile enable HTTPS. Renewed files are picked up without
+	// a restart.
+	CertFile string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile  string `mapstructure:"key_file" yaml:"key_file"`
+	// MinVersion is 1.2 (default) or 1.3.
+	MinVersion string `mapstructure:"min_version" yaml:"min_version"`
+	// ClientCAFile enables mTLS: every route but the health probes requires a
+	// client certificate signed by one of these CAs.
+	ClientCAFile string `mapstructure:"client_ca_file" yaml:"client_ca_file"`
+	// AllowedClients restricts mTLS clients to certificates matching one of
+	// these rules, written FIELD=pattern with FIELD one of CN, DNS or URI and
+	// a path.Match pattern, e.g. URI=spiffe://cluster.local/ns/*/sa/billing.
+	// Empty allows any certificate signed by the client CA.
+	AllowedClients []string `mapstructure:"allowed_clients" yaml:"allowed_clients"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// Validate reports every invalid setting, one error per key.
+func (c TLSConfig) Validate() error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("key_file: must be set together with cert_file"))
+	}
+
+	if _, ok := tlsVersions[c.MinVersion]; c.MinVersion != "" && !ok {
+		errs = append(errs, fmt.Errorf("min_version: must be %s or %s, got %q", TLSVersion12, TLSVersion13, c.MinVersion))
+	}
+
+	if c.ClientCAFile != "" && !c.Enabled() {
+		errs = append(errs, errors.New("client_ca_file: requires cert_file and key_file"))
+	}
+
+	if len(c.AllowedClients) > 0 && c.ClientCAFile == "" {
+		errs = append(errs, errors.New("allowed_clients: requires client_ca_file"))
+	}
+
+	for _, rule := range c.AllowedClients {
+		field, pattern, ok := strings.Cut(rule, "=")
+		_, err := path.Match(pattern, "")
+		if !ok || !slices.Contains(subjectFields, field) || err != nil {
+			errs = append(errs, fmt.Errorf("allowed_clients: must be CN=, DNS= or URI= followed by a pattern, got %q", rule))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newTLSConfig builds the listener configuration, loading the certificate
+// and client CAs once to fail fast on unreadable files.
+func newTLSConfig(cfg TLSConfig, logger *zap.Logger) (*tls.Config, error) {
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	minVersion, ok := tlsVersions[cfg.MinVersion]
+	if !ok {
+		minVersion = tls.VersionTLS12
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA file %s", cfg.ClientCAFile)
+	}
+
+	// Certificates are verified when given and required by requireClientCert,
+	// so health probes, which cannot present one, still get through.
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	tlsConfig.ClientCAs = pool
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 || clientAllowed(cfg.AllowedClients, state.PeerCertificates[0]) {
+			return nil
+		}
+
+		return errors.Join(ErrClientNotAllowed, fmt.Errorf("subject %q", state.PeerCertificates[0].Subject))
+	}
+
+	return tlsConfig, nil
+}
+
+func clientAllowed(rules []string, cert *x509.Certificate) bool {
+	if len(rules) == 0 {
+		return true
+	}
+
+	for _, rule := range rules {
+		field, pattern, _ := strings.Cut(rule, "=")
+
+		var values []string
+		switch field {
+		case "CN":
+			values = []string{cert.Subject.CommonName}
+		case "DNS":
+			values = cert.DNSNames
+		case "URI":
+			for _, uri := range cert.URIs {
+				values = append(values, uri.String())
+			}
+		}
+
+		for _, value := range values {
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requireClientCert rejects requests that came without a verified client
+// certificate.
+func requireClientCert(c *fiber.Ctx) error {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return fiber.NewError(fiber.StatusUnauthorized, ErrClientCertRequired.Error())
+	}
+
+	return c.Next()
+}
+
+// certReloader serves the certificate from disk and reloads it once the
+// files change, e.g. after renewal or a Kubernetes secret update. When the
+// new files cannot be loaded the previous certificate stays in use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *zap.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *zap.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) >= certCheckInterval {
+		r.checkedAt = time.Now()
+		if modTime, err := r.latestModTime(); err == nil && !modTime.Equal(r.modTime) {
+			err = r.load()
+			if err != nil {
+				r.logger.Error("cannot reload TLS certificate", zap.Error(err))
+			} else {
+				r.logger.Info("TLS certificate reloaded", zap.String("cert_file", r.certFile))
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+
+	return nil
+}
+
+// latestModTime follows symlinks, so swapping the target of a mounted
+// secret counts as a change.
+func (r *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"go-user-service/src/gql"
+	"go-user-service/src/grpchandlers"
+	"go-user-service/src/handlers"
+	"go-user-service/src/health"
+	"go-user-service/src/metrics"
+	"go-user-service/src/scim"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600)
+	require.NoError(t, err, err)
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err, err)
+
+	keyFile := filepath.Join(dir, name+".key")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+	require.NoError(t, err, err)
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	caFile, _ := ca.write(t, dir, "ca")
+
+	serverCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	certFile, keyFile := serverCert.write(t, dir, "server")
+
+	newClientCert := func(commonName string) *testCert {
+		return newTestCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: commonName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca)
+	}
+
+	graphqlHandler, err := gql.New(nil)
+	require.NoError(t, err, err)
+
+	cfg := Config{
+		Port: "8443",
+		TLS: TLSConfig{
+			CertFile:       certFile,
+			KeyFile:        keyFile,
+			MinVersion:     TLSVersion13,
+			ClientCAFile:   caFile,
+			AllowedClients: []string{"CN=billing-*"},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	microservice := New(cfg, zap.NewNop(), metrics.New(), health.NewRegistry(0), Handlers{
+		HTTP:    handlers.New(nil),
+		GRPC:    grpchandlers.New(nil),
+		GraphQL: graphqlHandler,
+		SCIM:    scim.New(nil, scim.Config{}),
+	}, Options{})
+	go microservice.Start()
+	defer microservice.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	get := func(path string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+
+		resp, err := client.Get("https://127.0.0.1:8443" + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		return resp, err
+	}
+
+	require.Eventually(t, func() bool {
+		_, err := get(livenessPath)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("ProbesWithoutCertificate", func(t *testing.T) {
+		resp, err := get(livenessPath)
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("CertificateRequired", func(t *testing.T) {
+		resp, err := get(specPath)
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("AllowedClient", func(t *testing.T) {
+		resp, err := get(specPath, newClientCert("billing-api").tlsCertificate())
+		require.NoError(t, err, err)
+		require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("RejectedClient", func(t *testing.T) {
+		_, err := get(specPath, newClientCert("reporting").tlsCertificate())
+		require.Error(t, err)
+	})
+
+	t.Run("MinVersion", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    roots,
+			MaxVersion: tls.VersionTLS12,
+		}}}
+
+		_, err := client.Get("https://127.0.0.1:8443" + livenessPath)
+		require.Error(t, err)
+	})
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}}, nil)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	reloader, err := newCertReloader(certFile, keyFile, zap.NewNop())
+	require.NoError(t, err, err)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err, err)
+	require.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	second := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}}, nil)
+	second.write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+
+	// A broken key keeps the previous certificate in use.
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	reloader.checkedAt = time.Time{}
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err, err)
+	require.Equal(t, first.cert.Raw, cert.Certificate[0])
+
+	second.write(t, dir, "server")
+	require.NoError(t, os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)))
+	reloader.checkedAt = time.Time{}
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err, err)
+	require.Equal(t, second.cert.Raw, cert.Certificate[0])
+}
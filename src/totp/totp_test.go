@@ -0,0 +1,63 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	// The SHA-1 test vectors of RFC 6238, appendix B, truncated to 6 digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		actual, err := Code(secret, Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		require.Equal(t, code, actual, unix)
+	}
+
+	_, err := Code("not base32!", 0)
+	require.ErrorIs(t, err, ErrBadSecret)
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1_700_000_000, 0)
+	code, err := Code(secret, Step(now.Add(-Period)))
+	require.NoError(t, err)
+
+	step, ok, err := Validate(secret, code, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Step(now)-1, step)
+
+	_, ok, err = Validate(secret, code, now.Add(2*Period))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = Validate(secret, "12345", now)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Example Co", "jane@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Example Co:jane@example.com", u.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	require.Equal(t, "Example Co", u.Query().Get("issuer"))
+}